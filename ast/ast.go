@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"strings"
 )
 
 type Node interface {
@@ -40,3 +41,40 @@ func (p *Program) String() string {
 
 	return out.String()
 }
+
+// Exports returns the names a program exposes when imported as a module:
+// the ones marked with export or, if none are, every top-level name not
+// starting with an underscore.
+func (p *Program) Exports() []string {
+	exported := []string{}
+	public := []string{}
+
+	for _, s := range p.Statements {
+		if es, ok := s.(*ExportStatement); ok {
+			exported = append(exported, declaredName(es.Statement))
+			continue
+		}
+
+		if name := declaredName(s); name != "" && !strings.HasPrefix(name, "_") {
+			public = append(public, name)
+		}
+	}
+
+	if len(exported) > 0 {
+		return exported
+	}
+	return public
+}
+
+func declaredName(s Statement) string {
+	switch s := s.(type) {
+	case *VarStatement:
+		return s.Name.Value
+	case *EnumStatement:
+		return s.Name.Value
+	case *FunctionStatement:
+		return s.Name.Value
+	default:
+		return ""
+	}
+}
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 	"zumbra/token"
 )
@@ -26,3 +27,36 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestProgramExports(t *testing.T) {
+	name := func(value string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: value}, Value: value}
+	}
+
+	public := &Program{
+		Statements: []Statement{
+			&VarStatement{Name: name("twoTimes")},
+			&VarStatement{Name: name("_helper")},
+			&EnumStatement{Name: name("Color")},
+			&FunctionStatement{Name: name("square")},
+			&FunctionStatement{Name: name("_square")},
+		},
+	}
+
+	if got := strings.Join(public.Exports(), ","); got != "twoTimes,Color,square" {
+		t.Errorf("wrong exports without markers. got=%q", got)
+	}
+
+	marked := &Program{
+		Statements: []Statement{
+			&VarStatement{Name: name("factor")},
+			&ExportStatement{Statement: &VarStatement{Name: name("twoTimes")}},
+			&FunctionStatement{Name: name("helper")},
+			&ExportStatement{Statement: &FunctionStatement{Name: name("square")}},
+		},
+	}
+
+	if got := strings.Join(marked.Exports(), ","); got != "twoTimes,square" {
+		t.Errorf("wrong exports with markers. got=%q", got)
+	}
+}
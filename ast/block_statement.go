@@ -8,6 +8,7 @@ import (
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	End        token.Token // the closing brace
 }
 
 func (bs *BlockStatement) statementNode()       {}
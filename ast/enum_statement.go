@@ -0,0 +1,32 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"zumbra/token"
+)
+
+type EnumStatement struct {
+	Token   token.Token
+	Name    *Identifier
+	Members []*Identifier
+}
+
+func (es *EnumStatement) statementNode()       {}
+func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EnumStatement) String() string {
+	var out bytes.Buffer
+
+	members := []string{}
+	for _, m := range es.Members {
+		members = append(members, m.String())
+	}
+
+	out.WriteString("enum ")
+	out.WriteString(es.Name.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(members, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
@@ -0,0 +1,16 @@
+package ast
+
+import (
+	"zumbra/token"
+)
+
+type ExportStatement struct {
+	Token     token.Token
+	Statement Statement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
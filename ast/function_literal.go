@@ -9,8 +9,14 @@ import (
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
-	Name       string
+	// ParameterTypes holds the annotation of each parameter, or nil for
+	// the ones without one.
+	ParameterTypes []*TypeAnnotation
+	ReturnType     *TypeAnnotation
+	Body           *BlockStatement
+	Name           string
+	// IsGenerator is set when the body contains a yield statement.
+	IsGenerator bool
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -18,10 +24,7 @@ func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
+	params := parameterStrings(fl.Parameters, fl.ParameterTypes)
 
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
@@ -29,8 +32,24 @@ func (fl *FunctionLiteral) String() string {
 	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
+	out.WriteString(" ")
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
+
+func parameterStrings(params []*Identifier, types []*TypeAnnotation) []string {
+	out := []string{}
+	for i, p := range params {
+		if i < len(types) && types[i] != nil {
+			out = append(out, p.String()+": "+types[i].String())
+		} else {
+			out = append(out, p.String())
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"zumbra/token"
+)
+
+// FunctionStatement declares a named function with `fct name(...) { }`.
+// Declarations are hoisted: every function declared in a block can be
+// referred to from anywhere in the block.
+type FunctionStatement struct {
+	Token    token.Token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) String() string {
+	var out bytes.Buffer
+
+	params := parameterStrings(fs.Function.Parameters, fs.Function.ParameterTypes)
+
+	out.WriteString("fct ")
+	out.WriteString(fs.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if fs.Function.ReturnType != nil {
+		out.WriteString(": " + fs.Function.ReturnType.String())
+	}
+	out.WriteString(" ")
+	out.WriteString(fs.Function.Body.String())
+
+	return out.String()
+}
@@ -5,11 +5,17 @@ import "zumbra/token"
 type ImportStatement struct {
 	Token token.Token
 	Path  *StringLiteral
+	// Alias names the module object for `import "x.zum" as m`. Without it
+	// the imported names are added to the importing program.
+	Alias *Identifier
 }
 
 func (i *ImportStatement) statementNode()       {}
 func (i *ImportStatement) TokenLiteral() string { return i.Token.Literal }
 
 func (i *ImportStatement) String() string {
+	if i.Alias != nil {
+		return "import " + i.Path.Value + " as " + i.Alias.Value
+	}
 	return "import " + i.Path.Value
 }
@@ -0,0 +1,74 @@
+package ast
+
+import "zumbra/token"
+
+// Start returns the token node begins with in the source. Nodes built by
+// hand, without tokens, start at line 0.
+func Start(node Node) token.Token {
+	switch n := node.(type) {
+	case *InfixExpression:
+		return Start(n.Left)
+	case *CallExpression:
+		return Start(n.Function)
+	case *IndexExpression:
+		return Start(n.Left)
+	case *AttributeAccess:
+		return Start(n.Object)
+	case *AssignStatement:
+		// The token of an assignment is its `<<`.
+		return n.Name.Token
+	case *Program:
+		if len(n.Statements) > 0 {
+			return Start(n.Statements[0])
+		}
+	case *Identifier:
+		return n.Token
+	case *IntegerLiteral:
+		return n.Token
+	case *FloatLiteral:
+		return n.Token
+	case *StringLiteral:
+		return n.Token
+	case *Boolean:
+		return n.Token
+	case *PrefixExpression:
+		return n.Token
+	case *ArrayLiteral:
+		return n.Token
+	case *DictLiteral:
+		return n.Token
+	case *FunctionLiteral:
+		return n.Token
+	case *IfExpression:
+		return n.Token
+	case *TypeAnnotation:
+		return n.Token
+	case *ExpressionStatement:
+		return n.Token
+	case *VarStatement:
+		return n.Token
+	case *FunctionStatement:
+		return n.Token
+	case *ReturnStatement:
+		return n.Token
+	case *ThrowStatement:
+		return n.Token
+	case *YieldStatement:
+		return n.Token
+	case *ExportStatement:
+		return n.Token
+	case *EnumStatement:
+		return n.Token
+	case *ImportStatement:
+		return n.Token
+	case *BlockStatement:
+		return n.Token
+	case *WhileStatement:
+		return n.Token
+	case *ForStatement:
+		return n.Token
+	case *TryStatement:
+		return n.Token
+	}
+	return token.Token{}
+}
@@ -0,0 +1,169 @@
+package ast
+
+// Rewrite replaces each node of the tree rooted at node by the result of
+// calling f on it, children first, and returns the new root. f returns
+// its argument to keep a node. A replacement must fit in the field of the
+// node it replaces: a statement for a statement, an expression for an
+// expression, and a node of the same type for fields such as names and
+// blocks. Rewrite panics otherwise.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		for i, s := range n.Statements {
+			n.Statements[i] = rewriteStatement(s, f)
+		}
+
+	case *ExpressionStatement:
+		n.Expression = rewriteExpression(n.Expression, f)
+
+	case *VarStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Type = rewriteAnnotation(n.Type, f)
+		n.Value = rewriteExpression(n.Value, f)
+
+	case *AssignStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Value = rewriteExpression(n.Value, f)
+
+	case *ReturnStatement:
+		n.ReturnValue = rewriteExpression(n.ReturnValue, f)
+
+	case *ThrowStatement:
+		n.Value = rewriteExpression(n.Value, f)
+
+	case *YieldStatement:
+		n.Value = rewriteExpression(n.Value, f)
+
+	case *FunctionStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		if n.Function != nil {
+			n.Function = Rewrite(n.Function, f).(*FunctionLiteral)
+		}
+
+	case *ExportStatement:
+		n.Statement = rewriteStatement(n.Statement, f)
+
+	case *EnumStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		for i, m := range n.Members {
+			n.Members[i] = rewriteIdentifier(m, f)
+		}
+
+	case *ImportStatement:
+		if n.Path != nil {
+			n.Path = Rewrite(n.Path, f).(*StringLiteral)
+		}
+		n.Alias = rewriteIdentifier(n.Alias, f)
+
+	case *BlockStatement:
+		for i, s := range n.Statements {
+			n.Statements[i] = rewriteStatement(s, f)
+		}
+
+	case *WhileStatement:
+		n.Condition = rewriteExpression(n.Condition, f)
+		n.Body = rewriteBlock(n.Body, f)
+
+	case *ForStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Iterable = rewriteExpression(n.Iterable, f)
+		n.Body = rewriteBlock(n.Body, f)
+
+	case *TryStatement:
+		n.Block = rewriteBlock(n.Block, f)
+		n.Param = rewriteIdentifier(n.Param, f)
+		n.Catch = rewriteBlock(n.Catch, f)
+		n.Finally = rewriteBlock(n.Finally, f)
+
+	case *PrefixExpression:
+		n.Right = rewriteExpression(n.Right, f)
+
+	case *InfixExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Right = rewriteExpression(n.Right, f)
+
+	case *IfExpression:
+		n.Condition = rewriteExpression(n.Condition, f)
+		n.Consequence = rewriteBlock(n.Consequence, f)
+		n.Alternative = rewriteBlock(n.Alternative, f)
+
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			n.Parameters[i] = rewriteIdentifier(p, f)
+			if i < len(n.ParameterTypes) {
+				n.ParameterTypes[i] = rewriteAnnotation(n.ParameterTypes[i], f)
+			}
+		}
+		n.ReturnType = rewriteAnnotation(n.ReturnType, f)
+		n.Body = rewriteBlock(n.Body, f)
+
+	case *CallExpression:
+		n.Function = rewriteExpression(n.Function, f)
+		for i, a := range n.Arguments {
+			n.Arguments[i] = rewriteExpression(a, f)
+		}
+
+	case *IndexExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Index = rewriteExpression(n.Index, f)
+
+	case *AttributeAccess:
+		n.Object = rewriteExpression(n.Object, f)
+		n.Property = rewriteIdentifier(n.Property, f)
+
+	case *ArrayLiteral:
+		for i, el := range n.Elements {
+			n.Elements[i] = rewriteExpression(el, f)
+		}
+
+	case *DictLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for _, k := range n.Keys() {
+			v := n.Pairs[k]
+			pairs[rewriteExpression(k, f)] = rewriteExpression(v, f)
+		}
+		n.Pairs = pairs
+
+	case *TypeAnnotation:
+		for i, p := range n.Parameters {
+			n.Parameters[i] = rewriteAnnotation(p, f)
+		}
+	}
+
+	return f(node)
+}
+
+func rewriteStatement(s Statement, f func(Node) Node) Statement {
+	if s == nil {
+		return nil
+	}
+	return Rewrite(s, f).(Statement)
+}
+
+func rewriteExpression(e Expression, f func(Node) Node) Expression {
+	if e == nil {
+		return nil
+	}
+	return Rewrite(e, f).(Expression)
+}
+
+func rewriteIdentifier(id *Identifier, f func(Node) Node) *Identifier {
+	if id == nil {
+		return nil
+	}
+	return Rewrite(id, f).(*Identifier)
+}
+
+func rewriteBlock(b *BlockStatement, f func(Node) Node) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	return Rewrite(b, f).(*BlockStatement)
+}
+
+func rewriteAnnotation(ta *TypeAnnotation, f func(Node) Node) *TypeAnnotation {
+	if ta == nil {
+		return nil
+	}
+	return Rewrite(ta, f).(*TypeAnnotation)
+}
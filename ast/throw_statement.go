@@ -0,0 +1,23 @@
+package ast
+
+import (
+	"bytes"
+	"zumbra/token"
+)
+
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+	out.WriteString(ts.Value.String())
+	out.WriteString(";")
+
+	return out.String()
+}
@@ -0,0 +1,70 @@
+package ast
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// TreeNode is a plain description of a node, for tools that show syntax
+// trees. It marshals to JSON as
+//
+//	{"kind": "InfixExpression", "line": 1, "column": 7, "value": "+",
+//	 "children": [{"kind": "Identifier", "field": "left", ...}, ...]}
+type TreeNode struct {
+	Kind string `json:"kind"`
+	// Field names the field of the parent holding the node.
+	Field  string `json:"field,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// Value is the name, literal value or operator the node stands for.
+	Value    string      `json:"value,omitempty"`
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+// Tree describes the tree rooted at node.
+func Tree(node Node) *TreeNode {
+	return tree(node, "")
+}
+
+func tree(node Node, field string) *TreeNode {
+	start := Start(node)
+
+	t := &TreeNode{
+		Kind:   reflect.TypeOf(node).Elem().Name(),
+		Field:  field,
+		Line:   start.Line,
+		Column: start.Column,
+		Value:  valueOf(node),
+	}
+
+	eachChild(node, func(field string, child Node) {
+		t.Children = append(t.Children, tree(child, field))
+	})
+
+	return t
+}
+
+func valueOf(node Node) string {
+	switch n := node.(type) {
+	case *Identifier:
+		return n.Value
+	case *IntegerLiteral:
+		return strconv.FormatInt(n.Value, 10)
+	case *FloatLiteral:
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	case *StringLiteral:
+		return n.Value
+	case *Boolean:
+		return strconv.FormatBool(n.Value)
+	case *PrefixExpression:
+		return n.Operator
+	case *InfixExpression:
+		return n.Operator
+	case *FunctionLiteral:
+		return n.Name
+	case *TypeAnnotation:
+		return n.Name
+	default:
+		return ""
+	}
+}
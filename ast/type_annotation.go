@@ -0,0 +1,36 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"zumbra/token"
+)
+
+// TypeAnnotation is an optional type written after a name, such as the
+// `array<int>` in `var xs: array<int> << [];`. Annotations are only read
+// by the type checker; the compiler and the evaluator ignore them.
+type TypeAnnotation struct {
+	Token      token.Token
+	Name       string
+	Parameters []*TypeAnnotation
+}
+
+func (ta *TypeAnnotation) TokenLiteral() string { return ta.Token.Literal }
+func (ta *TypeAnnotation) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ta.Name)
+
+	if len(ta.Parameters) > 0 {
+		params := []string{}
+		for _, p := range ta.Parameters {
+			params = append(params, p.String())
+		}
+
+		out.WriteString("<")
+		out.WriteString(strings.Join(params, ", "))
+		out.WriteString(">")
+	}
+
+	return out.String()
+}
@@ -8,6 +8,7 @@ import (
 type VarStatement struct {
 	Token token.Token
 	Name  *Identifier
+	Type  *TypeAnnotation
 	Value Expression
 }
 
@@ -18,6 +19,9 @@ func (ls *VarStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
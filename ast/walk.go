@@ -0,0 +1,207 @@
+package ast
+
+import "sort"
+
+// A Visitor's Visit method is called for each node found by Walk. If it
+// returns a visitor w, Walk visits the children of the node with w and
+// then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth-first, visiting children
+// in the order they appear in the source.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	eachChild(node, func(_ string, child Node) {
+		Walk(v, child)
+	})
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for each node of the tree rooted at node, depth-first.
+// If f returns false the children of the node are skipped; otherwise f is
+// called with nil once they have all been inspected.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Keys returns the keys of the dict in the order they were written.
+// Dicts built by hand, without tokens, have their keys in no given order.
+func (dl *DictLiteral) Keys() []Expression {
+	keys := []Expression{}
+	for k := range dl.Pairs {
+		keys = append(keys, k)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := Start(keys[i]), Start(keys[j])
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return keys
+}
+
+// eachChild calls f with each child of node in source order, along with
+// the name of the field holding it. Missing children are skipped.
+func eachChild(node Node, f func(field string, child Node)) {
+	expression := func(field string, e Expression) {
+		if e != nil {
+			f(field, e)
+		}
+	}
+	identifier := func(field string, id *Identifier) {
+		if id != nil {
+			f(field, id)
+		}
+	}
+	block := func(field string, b *BlockStatement) {
+		if b != nil {
+			f(field, b)
+		}
+	}
+	annotation := func(field string, ta *TypeAnnotation) {
+		if ta != nil {
+			f(field, ta)
+		}
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			f("statements", s)
+		}
+
+	case *ExpressionStatement:
+		expression("expression", n.Expression)
+
+	case *VarStatement:
+		identifier("name", n.Name)
+		annotation("type", n.Type)
+		expression("value", n.Value)
+
+	case *AssignStatement:
+		identifier("name", n.Name)
+		expression("value", n.Value)
+
+	case *ReturnStatement:
+		expression("returnValue", n.ReturnValue)
+
+	case *ThrowStatement:
+		expression("value", n.Value)
+
+	case *YieldStatement:
+		expression("value", n.Value)
+
+	case *FunctionStatement:
+		identifier("name", n.Name)
+		if n.Function != nil {
+			f("function", n.Function)
+		}
+
+	case *ExportStatement:
+		if n.Statement != nil {
+			f("statement", n.Statement)
+		}
+
+	case *EnumStatement:
+		identifier("name", n.Name)
+		for _, m := range n.Members {
+			f("members", m)
+		}
+
+	case *ImportStatement:
+		if n.Path != nil {
+			f("path", n.Path)
+		}
+		identifier("alias", n.Alias)
+
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			f("statements", s)
+		}
+
+	case *WhileStatement:
+		expression("condition", n.Condition)
+		block("body", n.Body)
+
+	case *ForStatement:
+		identifier("name", n.Name)
+		expression("iterable", n.Iterable)
+		block("body", n.Body)
+
+	case *TryStatement:
+		block("block", n.Block)
+		identifier("param", n.Param)
+		block("catch", n.Catch)
+		block("finally", n.Finally)
+
+	case *PrefixExpression:
+		expression("right", n.Right)
+
+	case *InfixExpression:
+		expression("left", n.Left)
+		expression("right", n.Right)
+
+	case *IfExpression:
+		expression("condition", n.Condition)
+		block("consequence", n.Consequence)
+		block("alternative", n.Alternative)
+
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			f("parameters", p)
+			if i < len(n.ParameterTypes) {
+				annotation("parameterTypes", n.ParameterTypes[i])
+			}
+		}
+		annotation("returnType", n.ReturnType)
+		block("body", n.Body)
+
+	case *CallExpression:
+		expression("function", n.Function)
+		for _, a := range n.Arguments {
+			f("arguments", a)
+		}
+
+	case *IndexExpression:
+		expression("left", n.Left)
+		expression("index", n.Index)
+
+	case *AttributeAccess:
+		expression("object", n.Object)
+		identifier("property", n.Property)
+
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			f("elements", el)
+		}
+
+	case *DictLiteral:
+		for _, k := range n.Keys() {
+			f("keys", k)
+			expression("values", n.Pairs[k])
+		}
+
+	case *TypeAnnotation:
+		for _, p := range n.Parameters {
+			f("parameters", p)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"zumbra/ast"
+	"zumbra/lexer"
+	"zumbra/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func kinds(node ast.Node) []string {
+	names := []string{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n != nil {
+			names = append(names, reflect.TypeOf(n).Elem().Name())
+		}
+		return true
+	})
+	return names
+}
+
+func TestInspect(t *testing.T) {
+	program := parse(t, `var d: int << {"b": x, "a": -1}; if (d) { show(d.a) } else { d[0] }`)
+
+	expected := []string{
+		"Program",
+		"VarStatement", "Identifier", "TypeAnnotation", "DictLiteral",
+		"StringLiteral", "Identifier", "StringLiteral", "PrefixExpression", "IntegerLiteral",
+		"ExpressionStatement", "IfExpression", "Identifier",
+		"BlockStatement", "ExpressionStatement", "CallExpression", "Identifier",
+		"AttributeAccess", "Identifier", "Identifier",
+		"BlockStatement", "ExpressionStatement", "IndexExpression", "Identifier", "IntegerLiteral",
+	}
+
+	if got := kinds(program); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong nodes.\nwant=%v\ngot=%v", expected, got)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	program := parse(t, "fct f(a) { return a + 1; } show(f);")
+
+	names := []string{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Identifier); ok {
+			names = append(names, id.Value)
+		}
+		_, isFunction := n.(*ast.FunctionLiteral)
+		return !isFunction
+	})
+
+	if expected := []string{"f", "show", "f"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong identifiers. want=%v, got=%v", expected, names)
+	}
+}
+
+type depthCounter struct {
+	depth, max *int
+}
+
+func (c depthCounter) Visit(node ast.Node) ast.Visitor {
+	if node == nil {
+		*c.depth--
+		return nil
+	}
+	*c.depth++
+	*c.max = max(*c.max, *c.depth)
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	program := parse(t, "while (x) { x << x - 1; }")
+
+	depth, deepest := 0, 0
+	ast.Walk(depthCounter{&depth, &deepest}, program)
+
+	if depth != 0 {
+		t.Errorf("Visit(nil) not called once per node. depth=%d", depth)
+	}
+	// Program, WhileStatement, BlockStatement, AssignStatement,
+	// InfixExpression, Identifier.
+	if deepest != 6 {
+		t.Errorf("wrong depth. want=6, got=%d", deepest)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program := parse(t, "var x << a + 1; fct f(a) { return [a, {a: a}]; }")
+
+	rewritten := ast.Rewrite(program, func(n ast.Node) ast.Node {
+		if id, ok := n.(*ast.Identifier); ok && id.Value == "a" {
+			return &ast.Identifier{Token: id.Token, Value: "b"}
+		}
+		if infix, ok := n.(*ast.InfixExpression); ok {
+			return infix.Left
+		}
+		return n
+	})
+
+	value := rewritten.(*ast.Program).Statements[0].(*ast.VarStatement).Value
+	if id, ok := value.(*ast.Identifier); !ok || id.Value != "b" {
+		t.Errorf("infix expression not replaced. got=%s", value)
+	}
+
+	if names := identifiers(rewritten); !reflect.DeepEqual(names, []string{"x", "b", "f", "b", "b", "b", "b"}) {
+		t.Errorf("wrong identifiers after rewrite. got=%v", names)
+	}
+}
+
+func TestRewriteMismatch(t *testing.T) {
+	program := parse(t, "var x << 1;")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic replacing a name with a literal")
+		}
+	}()
+
+	ast.Rewrite(program, func(n ast.Node) ast.Node {
+		if id, ok := n.(*ast.Identifier); ok {
+			return &ast.IntegerLiteral{Token: id.Token, Value: 1}
+		}
+		return n
+	})
+}
+
+func identifiers(node ast.Node) []string {
+	names := []string{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Identifier); ok {
+			names = append(names, id.Value)
+		}
+		return true
+	})
+	return names
+}
+
+func TestTree(t *testing.T) {
+	program := parse(t, "show(1 + x);")
+
+	out, err := json.Marshal(ast.Tree(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"kind":"Program","line":1,"column":1,"children":[` +
+		`{"kind":"ExpressionStatement","field":"statements","line":1,"column":1,"children":[` +
+		`{"kind":"CallExpression","field":"expression","line":1,"column":1,"children":[` +
+		`{"kind":"Identifier","field":"function","line":1,"column":1,"value":"show"},` +
+		`{"kind":"InfixExpression","field":"arguments","line":1,"column":6,"value":"+","children":[` +
+		`{"kind":"IntegerLiteral","field":"left","line":1,"column":6,"value":"1"},` +
+		`{"kind":"Identifier","field":"right","line":1,"column":10,"value":"x"}]}]}]}]}`
+
+	if string(out) != expected {
+		t.Errorf("wrong tree.\nwant=%s\ngot=%s", expected, out)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"time"
 	"zumbra/compiler"
 	"zumbra/evaluator"
@@ -13,8 +16,12 @@ import (
 )
 
 var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
+var program = flag.String("program", "fibonacci", "program to run: 'fibonacci', 'loop' or 'calls'")
+var optimize = flag.String("optimize", "all", "optimizations for the vm, 'all', 'none' or a comma separated list of "+strings.Join(optimizationNames(), ", "))
+var runs = flag.Int("runs", 1, "times to run the program; the median duration is reported")
 
-var input = `
+var programs = map[string]string{
+	"fibonacci": `
 var fibonacci << fct(x) {
 	if (x == 0) {
 		0
@@ -27,48 +34,133 @@ var fibonacci << fct(x) {
 	}
 };
 fibonacci(35);
-`
+`,
+
+	// loop exercises OpAddLocalConstant and OpCompareLocalJump.
+	"loop": `
+var count << fct() {
+	var i << 0;
+	var total << 0;
+	while (i < 20000000) {
+		i << i + 1;
+		total << total + 2;
+	}
+	total
+};
+count();
+`,
+
+	// calls exercises OpCallGlobal.
+	"calls": `
+var inc << fct(x) { x + 1 };
+var count << fct() {
+	var i << 0;
+	while (i < 5000000) {
+		i << inc(i);
+	}
+	i
+};
+count();
+`,
+}
+
+var optimizations = map[string]compiler.Optimization{
+	"jumps":        compiler.ThreadJumps,
+	"push-pop":     compiler.DropPushPop,
+	"add-local":    compiler.FuseAddLocal,
+	"compare-jump": compiler.FuseCompareJump,
+	"call-global":  compiler.FuseCallGlobal,
+	"all":          compiler.AllOptimizations,
+	"none":         compiler.NoOptimizations,
+}
+
+func optimizationNames() []string {
+	names := []string{}
+	for name := range optimizations {
+		if name != "all" && name != "none" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
 
 func main() {
 	flag.Parse()
 
-	var duration time.Duration
-	var result object.Object
-
-	l := lexer.New(input)
-	p := parser.New(l)
-	program := p.ParseProgram()
+	input, ok := programs[*program]
+	if !ok {
+		fmt.Printf("unknown program %s\n", *program)
+		os.Exit(2)
+	}
 
-	if *engine == "vm" {
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Printf("compiler error: %s", err)
-			return
+	enabled := compiler.NoOptimizations
+	for _, name := range strings.Split(*optimize, ",") {
+		o, ok := optimizations[name]
+		if !ok {
+			fmt.Printf("unknown optimization %s\n", name)
+			os.Exit(2)
 		}
+		enabled |= o
+	}
 
-		machine := vm.New(comp.Bytecode())
-		start := time.Now()
+	durations := []time.Duration{}
+	var result object.Object
 
-		err = machine.Run()
+	for i := 0; i < *runs; i++ {
+		duration, r, err := run(input, enabled)
 		if err != nil {
-			fmt.Printf("vm error: %s", err)
+			fmt.Println(err)
 			return
 		}
+		durations = append(durations, duration)
+		result = r
+	}
 
-		duration = time.Since(start)
-		result = machine.LastPoppedStackElem()
-	} else {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
 
-		env := object.NewEnvironment()
-		start := time.Now()
-		result = evaluator.Eval(program, env)
-		duration = time.Since(start)
-	}
 	fmt.Printf(
-		"engine=%s, result=%s, duration=%s\n",
+		"engine=%s, program=%s, optimize=%s, result=%s, duration=%s (median of %d, min %s, max %s)\n",
 		*engine,
+		*program,
+		*optimize,
 		result.Inspect(),
-		duration)
+		durations[len(durations)/2],
+		len(durations),
+		durations[0],
+		durations[len(durations)-1])
+}
+
+// run runs input once and returns how long it took, leaving compilation
+// out of the measure.
+func run(input string, enabled compiler.Optimization) (time.Duration, object.Object, error) {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if *engine != "vm" {
+		env := object.NewEnvironment()
+		start := time.Now()
+		result := evaluator.Eval(program, env)
+		return time.Since(start), result, nil
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		return 0, nil, fmt.Errorf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+	compiler.Optimize(bytecode, enabled)
+
+	machine := vm.New(bytecode)
+	start := time.Now()
+
+	err = machine.Run()
+	if err != nil {
+		return 0, nil, fmt.Errorf("vm error: %s", err)
+	}
 
+	return time.Since(start), machine.LastPoppedStackElem(), nil
 }
@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"zumbra/object"
+)
+
+// builtin describes what a builtin function accepts and returns.
+type builtin struct {
+	min, max int // max is -1 for builtins taking any number of arguments
+	// params lists the types each leading argument may have; a nil entry
+	// accepts anything.
+	params [][]object.ObjectType
+	result func(args []*Type) *Type
+}
+
+var (
+	arrayArg  = []object.ObjectType{object.ARRAY_OBJ}
+	dictArg   = []object.ObjectType{object.DICT_OBJ}
+	stringArg = []object.ObjectType{object.STRING_OBJ}
+	intArg    = []object.ObjectType{object.INTEGER_OBJ}
+	numberArg = []object.ObjectType{object.INTEGER_OBJ, object.FLOAT_OBJ}
+)
+
+func returns(t *Type) func([]*Type) *Type {
+	return func([]*Type) *Type { return t }
+}
+
+func firstArg(args []*Type) *Type {
+	return args[0]
+}
+
+func elementOfFirst(args []*Type) *Type {
+	return args[0].parameter(0)
+}
+
+func stringBuiltin() builtin {
+	return builtin{min: 1, max: 1, params: [][]object.ObjectType{stringArg}, result: returns(String)}
+}
+
+var signatures = map[string]builtin{
+	"toString":          {min: 1, max: 1, result: returns(String)},
+	"toInt":             {min: 1, max: 1, result: returns(Integer)},
+	"toFloat":           {min: 1, max: 1, result: returns(Float)},
+	"toBool":            {min: 1, max: 1, result: returns(Boolean)},
+	"date":              {min: 0, max: 0, result: returns(&Type{Name: object.DATE_OBJ})},
+	"show":              {min: 0, max: -1},
+	"input":             {min: 0, max: 1, result: returns(String)},
+	"addToDict":         {min: 3, max: 3, params: [][]object.ObjectType{dictArg}},
+	"deleteFromDict":    {min: 2, max: 2, params: [][]object.ObjectType{dictArg}},
+	"sizeOf":            {min: 1, max: 1, params: [][]object.ObjectType{{object.ARRAY_OBJ, object.STRING_OBJ}}, result: returns(Integer)},
+	"first":             {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: elementOfFirst},
+	"last":              {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: elementOfFirst},
+	"allButFirst":       {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: firstArg},
+	"addToArrayStart":   {min: 2, max: 2, params: [][]object.ObjectType{arrayArg}},
+	"addToArrayEnd":     {min: 2, max: 2, params: [][]object.ObjectType{arrayArg}},
+	"removeFromArray":   {min: 2, max: 2, params: [][]object.ObjectType{arrayArg, intArg}},
+	"max":               {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: elementOfFirst},
+	"min":               {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: elementOfFirst},
+	"indexOf":           {min: 2, max: 2, params: [][]object.ObjectType{arrayArg}},
+	"organize":          {min: 1, max: 2, params: [][]object.ObjectType{arrayArg}, result: firstArg},
+	"toUppercase":       stringBuiltin(),
+	"toLowercase":       stringBuiltin(),
+	"capitalize":        stringBuiltin(),
+	"removeWhiteSpaces": stringBuiltin(),
+	"sum":               {min: 1, max: 1, params: [][]object.ObjectType{arrayArg}, result: elementOfFirst},
+	"bhaskara":          {min: 3, max: 3, params: [][]object.ObjectType{intArg, intArg, intArg}},
+	"getFromDict":       {min: 2, max: 2, params: [][]object.ObjectType{dictArg}, result: func(args []*Type) *Type { return args[0].parameter(1) }},
+	"sendEmail":         {min: 1, max: 1, params: [][]object.ObjectType{dictArg}},
+	"sendWhatsapp":      {min: 1, max: 1, params: [][]object.ObjectType{dictArg}},
+	"randomInteger":     {min: 0, max: 2, params: [][]object.ObjectType{intArg, intArg}, result: returns(Integer)},
+	"randomFloat":       {min: 0, max: 2, params: [][]object.ObjectType{numberArg, numberArg}, result: returns(Float)},
+	"dictKeys":          {min: 1, max: 1, params: [][]object.ObjectType{dictArg}, result: func(args []*Type) *Type { return arrayOf(args[0].parameter(0)) }},
+	"dictValues":        {min: 1, max: 1, params: [][]object.ObjectType{dictArg}, result: func(args []*Type) *Type { return arrayOf(args[0].parameter(1)) }},
+	"replace":           {min: 3, max: 3, params: [][]object.ObjectType{stringArg, stringArg, stringArg}, result: returns(String)},
+	"values":            {min: 1, max: 1, params: [][]object.ObjectType{{object.ENUM_OBJ}}, result: returns(arrayOf(nil))},
+	"next":              {min: 1, max: 1, params: [][]object.ObjectType{{object.GENERATOR_OBJ}}},
+	"spawn":             {min: 1, max: -1, params: [][]object.ObjectType{{object.FUNCTION_OBJ}}, result: returns(&Type{Name: object.TASK_OBJ})},
+	"channel":           {min: 0, max: 2, result: returns(&Type{Name: object.CHANNEL_OBJ})},
+	"send":              {min: 2, max: 2, params: [][]object.ObjectType{{object.CHANNEL_OBJ}}},
+	"receive":           {min: 1, max: 1, params: [][]object.ObjectType{{object.CHANNEL_OBJ}}},
+	"close":             {min: 1, max: 1, params: [][]object.ObjectType{{object.CHANNEL_OBJ}}},
+	"waitAll":           {min: 0, max: -1, result: returns(arrayOf(nil))},
+}
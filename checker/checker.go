@@ -0,0 +1,548 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+	"zumbra/ast"
+	"zumbra/lexer"
+	"zumbra/object"
+	"zumbra/parser"
+	"zumbra/resolver"
+)
+
+// Checker infers the types of a program's values from literals, type
+// annotations and builtin signatures, and reports the operations that are
+// certain to fail at runtime. Values whose type cannot be inferred are
+// never reported.
+type Checker struct {
+	errors []string
+	scope  *scope
+	enums  map[string]bool
+	// reassigned holds the names assigned somewhere in the program, whose
+	// type may change if they were declared without an annotation.
+	reassigned map[string]bool
+	// returnTypes holds the declared return type of each enclosing function.
+	returnTypes []*Type
+	resolver    *resolver.Resolver
+	dir         string
+	imported    map[string]bool
+}
+
+type variable struct {
+	typ      *Type
+	declared bool // the type comes from an annotation
+}
+
+// scope holds the variables of a function, or of the whole program.
+type scope struct {
+	vars  map[string]variable
+	outer *scope
+}
+
+func (s *scope) lookup(name string) (variable, bool) {
+	for ; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return variable{}, false
+}
+
+// Check returns the type errors found in program, whose imports are
+// resolved from dir. Each error starts with the line and column of the
+// code it is about.
+func Check(program *ast.Program, dir string) []string {
+	c := &Checker{
+		scope:      &scope{vars: map[string]variable{}},
+		enums:      map[string]bool{},
+		reassigned: map[string]bool{},
+		resolver:   resolver.New(dir),
+		dir:        dir,
+		imported:   map[string]bool{},
+	}
+
+	collectNames(program, c)
+	c.checkStatements(program.Statements)
+
+	return c.errors
+}
+
+// errorf reports an error at the start of node, as line:column.
+func (c *Checker) errorf(node ast.Node, format string, a ...interface{}) {
+	tok := ast.Start(node)
+	c.errors = append(c.errors, fmt.Sprintf("%d:%d: %s", tok.Line, tok.Column, fmt.Sprintf(format, a...)))
+}
+
+// collectNames records the enums declared and the names reassigned
+// anywhere in the program.
+func collectNames(program *ast.Program, c *Checker) {
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.EnumStatement:
+			c.enums[node.Name.Value] = true
+		case *ast.AssignStatement:
+			c.reassigned[node.Name.Value] = true
+		}
+		return true
+	})
+}
+
+func (c *Checker) define(name string, t *Type, declared bool) {
+	if !declared && c.reassigned[name] {
+		t = nil
+	}
+	c.scope.vars[name] = variable{typ: t, declared: declared}
+}
+
+func (c *Checker) checkStatements(statements []ast.Statement) {
+	// Function declarations are hoisted, as they are when running.
+	for _, s := range statements {
+		if es, ok := s.(*ast.ExportStatement); ok {
+			s = es.Statement
+		}
+		if fs, ok := s.(*ast.FunctionStatement); ok {
+			c.define(fs.Name.Value, c.signatureOf(fs.Function), true)
+		}
+	}
+
+	for _, s := range statements {
+		c.checkStatement(s)
+	}
+}
+
+func (c *Checker) checkStatement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		c.typeOf(s.Expression)
+
+	case *ast.VarStatement:
+		if s.Type == nil {
+			c.define(s.Name.Value, c.typeOf(s.Value), false)
+			return
+		}
+
+		declared := c.resolveAnnotation(s.Type)
+		if t := c.typeAs(s.Value, declared); !assignable(t, declared) {
+			c.errorf(s.Value, "cannot use %s as %s in var %s", t, declared, s.Name.Value)
+		}
+		c.define(s.Name.Value, declared, true)
+
+	case *ast.AssignStatement:
+		v, ok := c.scope.lookup(s.Name.Value)
+		if !ok || !v.declared {
+			c.typeOf(s.Value)
+			return
+		}
+		if t := c.typeAs(s.Value, v.typ); !assignable(t, v.typ) {
+			c.errorf(s.Value, "cannot assign %s to %s of type %s", t, s.Name.Value, v.typ)
+		}
+
+	case *ast.FunctionStatement:
+		v, _ := c.scope.lookup(s.Name.Value)
+		c.checkFunction(s.Function, v.typ)
+
+	case *ast.ReturnStatement:
+		c.checkReturn(s, c.typeOf(s.ReturnValue))
+
+	case *ast.ExportStatement:
+		c.checkStatement(s.Statement)
+
+	case *ast.EnumStatement:
+		c.define(s.Name.Value, &Type{Name: object.ENUM_OBJ}, true)
+
+	case *ast.ImportStatement:
+		if s.Alias != nil {
+			c.define(s.Alias.Value, &Type{Name: object.MODULE_OBJ}, true)
+		} else {
+			c.defineImported(s.Path.Value, c.dir)
+		}
+
+	case *ast.WhileStatement:
+		c.typeOf(s.Condition)
+		c.checkStatements(s.Body.Statements)
+
+	case *ast.ForStatement:
+		iterable := c.typeOf(s.Iterable)
+		if iterable != nil && !iterable.is(object.ARRAY_OBJ) && !iterable.is(object.GENERATOR_OBJ) && !iterable.is(object.CHANNEL_OBJ) {
+			c.errorf(s.Iterable, "cannot iterate over %s", iterable)
+		}
+		c.define(s.Name.Value, iterable.parameter(0), false)
+		c.checkStatements(s.Body.Statements)
+
+	case *ast.TryStatement:
+		c.checkStatements(s.Block.Statements)
+		if s.Catch != nil {
+			c.define(s.Param.Value, nil, false)
+			c.checkStatements(s.Catch.Statements)
+		}
+		if s.Finally != nil {
+			c.checkStatements(s.Finally.Statements)
+		}
+
+	case *ast.ThrowStatement:
+		c.typeOf(s.Value)
+
+	case *ast.YieldStatement:
+		c.typeOf(s.Value)
+	}
+}
+
+// defineImported defines the names declared by a flatly imported file,
+// and the files it imports in turn, with unknown types. The files
+// themselves are checked on their own.
+func (c *Checker) defineImported(path, dir string) {
+	src, err := c.resolver.Resolve(path, dir)
+	if err != nil || c.imported[src.Name] {
+		return
+	}
+	c.imported[src.Name] = true
+
+	p := parser.New(lexer.New(src.Content))
+	program := p.ParseProgram()
+
+	for _, s := range program.Statements {
+		if es, ok := s.(*ast.ExportStatement); ok {
+			s = es.Statement
+		}
+
+		switch s := s.(type) {
+		case *ast.VarStatement:
+			c.define(s.Name.Value, nil, true)
+		case *ast.FunctionStatement:
+			c.define(s.Name.Value, nil, true)
+		case *ast.EnumStatement:
+			c.enums[s.Name.Value] = true
+			c.define(s.Name.Value, nil, true)
+		case *ast.ImportStatement:
+			if s.Alias == nil {
+				c.defineImported(s.Path.Value, src.Dir)
+			}
+		}
+	}
+}
+
+func (c *Checker) checkReturn(node ast.Node, t *Type) {
+	if len(c.returnTypes) == 0 {
+		return
+	}
+
+	want := c.returnTypes[len(c.returnTypes)-1]
+	if !assignable(t, want) {
+		c.errorf(node, "cannot return %s from function returning %s", t, want)
+	}
+}
+
+// signatureOf returns the type of fn from its annotations.
+func (c *Checker) signatureOf(fn *ast.FunctionLiteral) *Type {
+	sig := &Signature{Result: c.resolveAnnotation(fn.ReturnType)}
+	for i := range fn.Parameters {
+		var t *Type
+		if i < len(fn.ParameterTypes) {
+			t = c.resolveAnnotation(fn.ParameterTypes[i])
+		}
+		sig.Parameters = append(sig.Parameters, t)
+	}
+
+	return &Type{Name: object.FUNCTION_OBJ, Signature: sig}
+}
+
+// checkFunction checks the body of fn, whose type t comes from its
+// annotations.
+func (c *Checker) checkFunction(fn *ast.FunctionLiteral, t *Type) *Type {
+	c.scope = &scope{vars: map[string]variable{}, outer: c.scope}
+	if fn.Name != "" {
+		c.define(fn.Name, t, true)
+	}
+	for i, p := range fn.Parameters {
+		c.define(p.Value, t.Signature.Parameters[i], t.Signature.Parameters[i] != nil)
+	}
+
+	c.returnTypes = append(c.returnTypes, t.Signature.Result)
+	c.checkStatements(fn.Body.Statements)
+
+	// The value of the last expression is returned implicitly.
+	if t.Signature.Result != nil && !fn.IsGenerator && len(fn.Body.Statements) > 0 {
+		if es, ok := fn.Body.Statements[len(fn.Body.Statements)-1].(*ast.ExpressionStatement); ok {
+			c.checkReturn(es.Expression, c.typeOf(es.Expression))
+		}
+	}
+
+	c.returnTypes = c.returnTypes[:len(c.returnTypes)-1]
+	c.scope = c.scope.outer
+
+	if fn.IsGenerator {
+		return &Type{Name: object.FUNCTION_OBJ, Signature: &Signature{
+			Parameters: t.Signature.Parameters,
+			Result:     &Type{Name: object.GENERATOR_OBJ},
+		}}
+	}
+	return t
+}
+
+func (c *Checker) typeOf(e ast.Expression) *Type {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return Integer
+	case *ast.FloatLiteral:
+		return Float
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Boolean
+
+	case *ast.ArrayLiteral:
+		elements := []*Type{}
+		for _, el := range e.Elements {
+			elements = append(elements, c.typeOf(el))
+		}
+		return arrayOf(common(elements))
+
+	case *ast.DictLiteral:
+		keys, values := []*Type{}, []*Type{}
+		for k, v := range e.Pairs {
+			keys = append(keys, c.typeOf(k))
+			values = append(values, c.typeOf(v))
+		}
+		return dictOf(common(keys), common(values))
+
+	case *ast.Identifier:
+		if v, ok := c.scope.lookup(e.Value); ok {
+			return v.typ
+		}
+		return nil
+
+	case *ast.PrefixExpression:
+		return c.prefixType(e)
+
+	case *ast.InfixExpression:
+		return c.infixType(e)
+
+	case *ast.IndexExpression:
+		return c.indexType(e)
+
+	case *ast.CallExpression:
+		return c.callType(e)
+
+	case *ast.FunctionLiteral:
+		return c.checkFunction(e, c.signatureOf(e))
+
+	case *ast.IfExpression:
+		c.typeOf(e.Condition)
+		c.checkStatements(e.Consequence.Statements)
+		if e.Alternative != nil {
+			c.checkStatements(e.Alternative.Statements)
+		}
+		return nil
+
+	case *ast.AttributeAccess:
+		c.typeOf(e.Object)
+		return nil
+	}
+
+	return nil
+}
+
+// common returns the type shared by all of types, if there is one.
+// typeAs returns the type of e, which is used where a want is expected.
+// An array literal mixing element types is an array of any, which fits
+// every array, so its elements are checked one by one against the element
+// type of want when it is known.
+func (c *Checker) typeAs(e ast.Expression, want *Type) *Type {
+	lit, ok := e.(*ast.ArrayLiteral)
+	element := want.parameter(0)
+	if !ok || !want.is(object.ARRAY_OBJ) || element == nil {
+		return c.typeOf(e)
+	}
+
+	elements := []*Type{}
+	for _, el := range lit.Elements {
+		elements = append(elements, c.typeAs(el, element))
+	}
+	if t := common(elements); t != nil {
+		return arrayOf(t)
+	}
+
+	for i, t := range elements {
+		if !assignable(t, element) {
+			c.errorf(lit.Elements[i], "cannot use %s as %s in array element", t, element)
+		}
+	}
+	return want
+}
+
+func common(types []*Type) *Type {
+	if len(types) == 0 || types[0] == nil {
+		return nil
+	}
+	for _, t := range types[1:] {
+		if t == nil || t.String() != types[0].String() {
+			return nil
+		}
+	}
+	return types[0]
+}
+
+func (c *Checker) prefixType(e *ast.PrefixExpression) *Type {
+	right := c.typeOf(e.Right)
+
+	switch e.Operator {
+	case "!":
+		return Boolean
+	case "-":
+		if right != nil && !right.isNumber() {
+			c.errorf(e, "unknown operator: -%s", right)
+			return nil
+		}
+		return right
+	}
+
+	return nil
+}
+
+func (c *Checker) infixType(e *ast.InfixExpression) *Type {
+	left := c.typeOf(e.Left)
+	right := c.typeOf(e.Right)
+
+	switch e.Operator {
+	case "==", "!=", "and", "or":
+		return Boolean
+
+	case "<", ">", "<=", ">=":
+		if left != nil && right != nil &&
+			!(left.isNumber() && right.isNumber()) &&
+			!(left.is(object.STRING_OBJ) && right.is(object.STRING_OBJ)) {
+			c.errorf(e, "cannot compare %s %s %s", left, e.Operator, right)
+		}
+		return Boolean
+
+	case "+", "-", "*", "/", "%":
+		if left == nil || right == nil {
+			return nil
+		}
+
+		switch {
+		case left.is(object.INTEGER_OBJ) && right.is(object.INTEGER_OBJ):
+			return Integer
+		case left.isNumber() && right.isNumber():
+			return Float
+		case e.Operator == "+" && left.is(object.STRING_OBJ) && right.is(object.STRING_OBJ):
+			return String
+		}
+
+		c.errorf(e, "unsupported types for binary operation: %s %s %s", left, e.Operator, right)
+	}
+
+	return nil
+}
+
+func (c *Checker) indexType(e *ast.IndexExpression) *Type {
+	left := c.typeOf(e.Left)
+	index := c.typeOf(e.Index)
+
+	switch {
+	case left.is(object.ARRAY_OBJ):
+		if index != nil && !index.is(object.INTEGER_OBJ) {
+			c.errorf(e.Index, "array index must be INTEGER, got %s", index)
+		}
+		return left.parameter(0)
+	case left.is(object.DICT_OBJ):
+		if key := left.parameter(0); !assignable(index, key) {
+			c.errorf(e.Index, "cannot use %s as key of %s", index, left)
+		}
+		return left.parameter(1)
+	case left.is(object.STRING_OBJ):
+		return String
+	case left != nil:
+		c.errorf(e, "index operator not supported: %s", left)
+	}
+
+	return nil
+}
+
+func (c *Checker) callType(e *ast.CallExpression) *Type {
+	args := []*Type{}
+	for _, arg := range e.Arguments {
+		args = append(args, c.typeOf(arg))
+	}
+
+	if ident, ok := e.Function.(*ast.Identifier); ok {
+		if _, defined := c.scope.lookup(ident.Value); !defined {
+			if b, ok := signatures[ident.Value]; ok {
+				return c.checkBuiltinCall(e, ident.Value, b, args)
+			}
+		}
+	}
+
+	fn := c.typeOf(e.Function)
+	if fn == nil {
+		return nil
+	}
+	if !fn.is(object.FUNCTION_OBJ) {
+		c.errorf(e, "calling non-function: %s", fn)
+		return nil
+	}
+	if fn.Signature == nil {
+		return nil
+	}
+
+	params := fn.Signature.Parameters
+	if len(args) != len(params) {
+		c.errorf(e, "wrong number of arguments to %s. got=%d, want=%d", e.Function, len(args), len(params))
+		return fn.Signature.Result
+	}
+
+	for i, param := range params {
+		if !assignable(args[i], param) {
+			c.errorf(e.Arguments[i], "argument %d to %s must be %s, got %s", i+1, e.Function, param, args[i])
+		}
+	}
+
+	return fn.Signature.Result
+}
+
+func (c *Checker) checkBuiltinCall(e *ast.CallExpression, name string, b builtin, args []*Type) *Type {
+	if len(args) < b.min || b.max >= 0 && len(args) > b.max {
+		want := fmt.Sprint(b.min)
+		if b.max < 0 {
+			want = "at least " + want
+		} else if b.max != b.min {
+			want = fmt.Sprintf("%d to %d", b.min, b.max)
+		}
+		c.errorf(e, "wrong number of arguments to `%s`. got=%d, want=%s", name, len(args), want)
+		return nil
+	}
+
+	for i, allowed := range b.params {
+		if i >= len(args) || args[i] == nil || allowed == nil {
+			continue
+		}
+
+		ok := false
+		names := []string{}
+		for _, t := range allowed {
+			ok = ok || args[i].Name == t
+			names = append(names, string(t))
+		}
+		if ok {
+			continue
+		}
+
+		if len(b.params) == 1 {
+			c.errorf(e.Arguments[i], "argument to `%s` must be %s, got %s", name, strings.Join(names, " or "), args[i])
+		} else {
+			c.errorf(e.Arguments[i], "argument %d to `%s` must be %s, got %s", i+1, name, strings.Join(names, " or "), args[i])
+		}
+		return nil
+	}
+
+	if name == "sum" {
+		if el := args[0].parameter(0); el != nil && !el.isNumber() {
+			c.errorf(e.Arguments[0], "argument to `sum` must be INTEGER or FLOAT, got %s", el)
+		}
+	}
+
+	if b.result == nil {
+		return nil
+	}
+	return b.result(args)
+}
@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"zumbra/lexer"
+	"zumbra/object/builtins"
+	"zumbra/parser"
+)
+
+func check(t *testing.T, input, dir string) []string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return Check(program, dir)
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`var total: int << 0; total << total + 1;`, nil},
+		{`var total: int << "zero";`, []string{"cannot use STRING as INTEGER in var total"}},
+		{`var ratio: float << 1;`, nil},
+		{`var total: int << 0; total << "one";`, []string{"cannot assign STRING to total of type INTEGER"}},
+		{`var xs: array<int> << [1, 2, "3"];`, []string{"cannot use STRING as INTEGER in array element"}},
+		{`var xs: array<float> << [1, 2.5];`, nil},
+		{`var xs: array<any> << [1, "2"];`, nil},
+		{`var xs: array<int> << []; xs << [1, true];`, []string{"cannot use BOOLEAN as INTEGER in array element"}},
+		{`var xss: array<array<int>> << [[1], [2, "x"], "y"];`, []string{
+			"cannot use STRING as INTEGER in array element",
+			"cannot use STRING as ARRAY<INTEGER> in array element",
+		}},
+		{`var xss: array<array<int>> << [[1], ["x"]];`, []string{"cannot use ARRAY<STRING> as ARRAY<INTEGER> in array element"}},
+		{`var xs: array<int> << ["1", "2"];`, []string{"cannot use ARRAY<STRING> as ARRAY<INTEGER> in var xs"}},
+		{`var ages: dict<string, float> << {"ana": 30};`, nil},
+		{`var ages: dict<string, int> << {1: 30};`, []string{"cannot use DICT<INTEGER, INTEGER> as DICT<STRING, INTEGER> in var ages"}},
+		{`var x: number << 1;`, []string{"unknown type number"}},
+		{`var x: array<int, int> << [];`, []string{"array takes 1 type parameters, got 2"}},
+		{`enum Color { Red } var c: Color << Color.Red;`, nil},
+
+		{`sum("abc");`, []string{"argument to `sum` must be ARRAY, got STRING"}},
+		{`sum(["a", "b"]);`, []string{"argument to `sum` must be INTEGER or FLOAT, got STRING"}},
+		{`var xs: array<int> << []; sum(xs) + 1;`, nil},
+		{`toUppercase(1);`, []string{"argument to `toUppercase` must be STRING, got INTEGER"}},
+		{`replace("a", 1, "b");`, []string{"argument 2 to `replace` must be STRING, got INTEGER"}},
+		{`sizeOf();`, []string{"wrong number of arguments to `sizeOf`. got=0, want=1"}},
+		{`spawn();`, []string{"wrong number of arguments to `spawn`. got=0, want=at least 1"}},
+		{`toUppercase(toString(1));`, nil},
+		{`var sum << fct(x) { x }; sum("abc");`, nil},
+		{`sendEmail("hello");`, []string{"argument to `sendEmail` must be DICT, got STRING"}},
+
+		{`fct greet(name: string, age: int): string { name } greet("ana", "30");`, []string{"argument 2 to greet must be INTEGER, got STRING"}},
+		{`fct greet(name: string): string { name } greet();`, []string{"wrong number of arguments to greet. got=0, want=1"}},
+		{`fct twice(x: int): int { x * 2 } toUppercase(twice(2));`, []string{"argument to `toUppercase` must be STRING, got INTEGER"}},
+		{`fct name(): string { return 1; }`, []string{"cannot return INTEGER from function returning STRING"}},
+		{`fct name(): string { 1 }`, []string{"cannot return INTEGER from function returning STRING"}},
+		{`var f << fct(x: int) { x }; f("1");`, []string{"argument 1 to f must be INTEGER, got STRING"}},
+		{`fct a(): int { b() } fct b(): string { "b" }`, []string{"cannot return STRING from function returning INTEGER"}},
+		{`fct add(x: int, y) { x + y } add(1, "2");`, nil},
+
+		{`1 + "a";`, []string{"unsupported types for binary operation: INTEGER + STRING"}},
+		{`"a" - "b";`, []string{"unsupported types for binary operation: STRING - STRING"}},
+		{`"a" + "b"; 1 + 2.5; 1 < 2.5; "a" < "b";`, nil},
+		{`-"a";`, []string{"unknown operator: -STRING"}},
+		{`var xs: array<string> << []; toUppercase(xs[0]); xs["0"];`, []string{"array index must be INTEGER, got STRING"}},
+		{`var xs: array<string> << []; for (x in xs) { x + 1 }`, []string{"unsupported types for binary operation: STRING + INTEGER"}},
+		{`for (x in 5) { }`, []string{"cannot iterate over INTEGER"}},
+		{`var x << 1; x << "a"; toUppercase(x);`, nil},
+		{`var x << 1; x();`, []string{"calling non-function: INTEGER"}},
+	}
+
+	for _, tt := range tests {
+		errors := check(t, tt.input, "")
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, msg := range tt.expected {
+			if got := withoutPosition(t, errors[i]); got != msg {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, msg, got)
+			}
+		}
+	}
+}
+
+// withoutPosition returns the message of err, checking that it starts
+// with a line and column.
+func withoutPosition(t *testing.T, err string) string {
+	t.Helper()
+
+	var line, column int
+	if n, _ := fmt.Sscanf(err, "%d:%d:", &line, &column); n != 2 {
+		t.Errorf("error %q has no position", err)
+		return err
+	}
+	return err[strings.Index(err, ": ")+2:]
+}
+
+func TestCheckPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`var total: int << "zero";`, []string{"1:19: cannot use STRING as INTEGER in var total"}},
+		{"var total: int << 0;\ntotal << \"one\";", []string{"2:10: cannot assign STRING to total of type INTEGER"}},
+		{`var x: number << 1;`, []string{"1:8: unknown type number"}},
+		{`var arr: array<int> << [1, 2, "x"];`, []string{"1:31: cannot use STRING as INTEGER in array element"}},
+		{"var a << 1;\n  1 + \"a\";", []string{"2:3: unsupported types for binary operation: INTEGER + STRING"}},
+		{`replace("a", 1, "b");`, []string{"1:14: argument 2 to `replace` must be STRING, got INTEGER"}},
+		{`sizeOf();`, []string{"1:1: wrong number of arguments to `sizeOf`. got=0, want=1"}},
+		{"fct name(): string {\n\treturn 1;\n}", []string{"2:2: cannot return INTEGER from function returning STRING"}},
+		{"for (x in 5) { }\nvar x << 1; x();", []string{"1:11: cannot iterate over INTEGER", "2:13: calling non-function: INTEGER"}},
+	}
+
+	for _, tt := range tests {
+		errors := check(t, tt.input, "")
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, msg := range tt.expected {
+			if errors[i] != msg {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, msg, errors[i])
+			}
+		}
+	}
+}
+
+func TestCheckFlatImports(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.zum"), []byte(`var sum << fct(x) { x };`), 0644); err != nil {
+		t.Fatalf("could not write import: %s", err)
+	}
+
+	if errors := check(t, `import "lib.zum"; sum(1);`, dir); len(errors) != 0 {
+		t.Errorf("imported names should shadow builtins. got=%q", errors)
+	}
+}
+
+func TestEveryBuiltinHasASignature(t *testing.T) {
+	for _, def := range builtins.Builtins {
+		if _, ok := signatures[def.Name]; !ok {
+			t.Errorf("builtin %s has no signature", def.Name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+	"zumbra/ast"
+	"zumbra/object"
+)
+
+// Type is what the checker knows about a value. A nil *Type means the
+// type is unknown, and unknown values are accepted everywhere.
+type Type struct {
+	Name object.ObjectType
+	// Parameters are the element type of an array, or the key and value
+	// types of a dict, when they are known.
+	Parameters []*Type
+	// Signature is set for functions whose declaration is known.
+	Signature *Signature
+}
+
+type Signature struct {
+	Parameters []*Type
+	Result     *Type
+}
+
+var (
+	Integer  = &Type{Name: object.INTEGER_OBJ}
+	Float    = &Type{Name: object.FLOAT_OBJ}
+	String   = &Type{Name: object.STRING_OBJ}
+	Boolean  = &Type{Name: object.BOOLEAN_OBJ}
+	Function = &Type{Name: object.FUNCTION_OBJ}
+)
+
+func arrayOf(element *Type) *Type {
+	if element == nil {
+		return &Type{Name: object.ARRAY_OBJ}
+	}
+	return &Type{Name: object.ARRAY_OBJ, Parameters: []*Type{element}}
+}
+
+func dictOf(key, value *Type) *Type {
+	if key == nil && value == nil {
+		return &Type{Name: object.DICT_OBJ}
+	}
+	return &Type{Name: object.DICT_OBJ, Parameters: []*Type{key, value}}
+}
+
+func (t *Type) String() string {
+	if t == nil {
+		return "any"
+	}
+	if len(t.Parameters) == 0 {
+		return string(t.Name)
+	}
+
+	params := []string{}
+	for _, p := range t.Parameters {
+		params = append(params, p.String())
+	}
+	return fmt.Sprintf("%s<%s>", t.Name, strings.Join(params, ", "))
+}
+
+// is reports whether t is known to be a name type.
+func (t *Type) is(name object.ObjectType) bool {
+	return t != nil && t.Name == name
+}
+
+func (t *Type) isNumber() bool {
+	return t.is(object.INTEGER_OBJ) || t.is(object.FLOAT_OBJ)
+}
+
+// parameter returns the i-th type parameter of t, if known.
+func (t *Type) parameter(i int) *Type {
+	if t == nil || i >= len(t.Parameters) {
+		return nil
+	}
+	return t.Parameters[i]
+}
+
+// assignable reports whether a value of type from can be used where a
+// value of type to is expected.
+func assignable(from, to *Type) bool {
+	if from == nil || to == nil {
+		return true
+	}
+	if from.Name == object.INTEGER_OBJ && to.Name == object.FLOAT_OBJ {
+		return true
+	}
+	if from.Name != to.Name {
+		return false
+	}
+
+	for i := range to.Parameters {
+		if !assignable(from.parameter(i), to.Parameters[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var annotationTypes = map[string]object.ObjectType{
+	"int":       object.INTEGER_OBJ,
+	"float":     object.FLOAT_OBJ,
+	"string":    object.STRING_OBJ,
+	"bool":      object.BOOLEAN_OBJ,
+	"array":     object.ARRAY_OBJ,
+	"dict":      object.DICT_OBJ,
+	"fct":       object.FUNCTION_OBJ,
+	"date":      object.DATE_OBJ,
+	"generator": object.GENERATOR_OBJ,
+	"task":      object.TASK_OBJ,
+	"channel":   object.CHANNEL_OBJ,
+}
+
+var typeParameterCounts = map[object.ObjectType]int{
+	object.ARRAY_OBJ: 1,
+	object.DICT_OBJ:  2,
+}
+
+// resolveAnnotation turns an annotation into the type it names. Enums
+// declared in the program are accepted but not checked.
+func (c *Checker) resolveAnnotation(ta *ast.TypeAnnotation) *Type {
+	if ta == nil || ta.Name == "any" || c.enums[ta.Name] {
+		return nil
+	}
+
+	name, ok := annotationTypes[ta.Name]
+	if !ok {
+		c.errorf(ta, "unknown type %s", ta.Name)
+		return nil
+	}
+
+	if len(ta.Parameters) == 0 {
+		return &Type{Name: name}
+	}
+
+	if len(ta.Parameters) != typeParameterCounts[name] {
+		c.errorf(ta, "%s takes %d type parameters, got %d", ta.Name, typeParameterCounts[name], len(ta.Parameters))
+		return &Type{Name: name}
+	}
+
+	t := &Type{Name: name}
+	for _, p := range ta.Parameters {
+		t.Parameters = append(t.Parameters, c.resolveAnnotation(p))
+	}
+	return t
+}
@@ -49,6 +49,35 @@ const (
 	OpAnd = iota
 	OpOr
 	OpGetAttr
+	OpThrow
+	OpYield
+	OpIter
+	OpIterNext
+	OpModule
+	OpAssignLocal
+	OpGetLocalCell
+	OpSetFree
+	OpGetFreeCell
+	OpAddLocalConstant
+	OpCompareLocalJump
+	OpCallGlobal
+	OpConstantWide
+	OpJumpNotTruthyWide
+	OpJumpWide
+	OpIterNextWide
+	OpGetLocalWide
+	OpSetLocalWide
+	OpAssignLocalWide
+	OpGetLocalCellWide
+	OpCallWide
+	OpGetBuiltinWide
+	OpClosureWide
+	OpGetFreeWide
+	OpSetFreeWide
+	OpGetFreeCellWide
+	OpModuleWide
+	OpTailCall
+	OpImport
 )
 
 type Definition struct {
@@ -95,6 +124,91 @@ var definitions = map[Opcode]*Definition{
 	OpAnd:                {"OpAnd", []int{}},
 	OpOr:                 {"OpOr", []int{}},
 	OpGetAttr:            {"OpGetAttr", []int{}},
+	OpThrow:              {"OpThrow", []int{}},
+	OpYield:              {"OpYield", []int{}},
+	OpIter:               {"OpIter", []int{}},
+	OpIterNext:           {"OpIterNext", []int{2}},
+	OpModule:             {"OpModule", []int{2, 2}},
+	OpAssignLocal:        {"OpAssignLocal", []int{1}},
+	OpGetLocalCell:       {"OpGetLocalCell", []int{1}},
+	OpSetFree:            {"OpSetFree", []int{1}},
+	OpGetFreeCell:        {"OpGetFreeCell", []int{1}},
+
+	// Superinstructions, see compiler.Optimize.
+	OpAddLocalConstant: {"OpAddLocalConstant", []int{1, 2}},
+	OpCompareLocalJump: {"OpCompareLocalJump", []int{1, 2, 1, 2}},
+	OpCallGlobal:       {"OpCallGlobal", []int{2, 1}},
+
+	// Wide variants, with operands twice as wide, for the programs that
+	// go over the limits of the instructions above. See Widen.
+	OpConstantWide:      {"OpConstantWide", []int{4}},
+	OpJumpNotTruthyWide: {"OpJumpNotTruthyWide", []int{4}},
+	OpJumpWide:          {"OpJumpWide", []int{4}},
+	OpIterNextWide:      {"OpIterNextWide", []int{4}},
+	OpGetLocalWide:      {"OpGetLocalWide", []int{2}},
+	OpSetLocalWide:      {"OpSetLocalWide", []int{2}},
+	OpAssignLocalWide:   {"OpAssignLocalWide", []int{2}},
+	OpGetLocalCellWide:  {"OpGetLocalCellWide", []int{2}},
+	OpCallWide:          {"OpCallWide", []int{2}},
+	OpGetBuiltinWide:    {"OpGetBuiltinWide", []int{2}},
+	OpClosureWide:       {"OpClosureWide", []int{4, 2}},
+	OpGetFreeWide:       {"OpGetFreeWide", []int{2}},
+	OpSetFreeWide:       {"OpSetFreeWide", []int{2}},
+	OpGetFreeCellWide:   {"OpGetFreeCellWide", []int{2}},
+	OpModuleWide:        {"OpModuleWide", []int{4, 4}},
+
+	// OpTailCall is an OpCall followed by OpReturnValue, see
+	// compiler.markTailCalls. A closure it calls reuses the frame of the
+	// caller.
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	// OpImport pushes the module kept in a global, first running the
+	// function that loads it when the global is not set yet.
+	OpImport: {"OpImport", []int{2, 4}},
+}
+
+var wide = map[Opcode]Opcode{
+	OpConstant:      OpConstantWide,
+	OpJumpNotTruthy: OpJumpNotTruthyWide,
+	OpJump:          OpJumpWide,
+	OpIterNext:      OpIterNextWide,
+	OpGetLocal:      OpGetLocalWide,
+	OpSetLocal:      OpSetLocalWide,
+	OpAssignLocal:   OpAssignLocalWide,
+	OpGetLocalCell:  OpGetLocalCellWide,
+	OpCall:          OpCallWide,
+	OpGetBuiltin:    OpGetBuiltinWide,
+	OpClosure:       OpClosureWide,
+	OpGetFree:       OpGetFreeWide,
+	OpSetFree:       OpSetFreeWide,
+	OpGetFreeCell:   OpGetFreeCellWide,
+	OpModule:        OpModuleWide,
+}
+
+// Widen returns the wide variant of op, if it has one.
+func Widen(op Opcode) (Opcode, bool) {
+	w, ok := wide[op]
+	return w, ok
+}
+
+// Fits reports whether every operand fits in its width in op.
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+
+	for i, o := range operands {
+		if o < 0 || i >= len(def.OperandWidths) || uint64(o) > MaxOperand(def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxOperand returns the largest operand an operand of width bytes holds.
+func MaxOperand(width int) uint64 {
+	return 1<<(8*width) - 1
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -123,6 +237,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -141,10 +257,16 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
 
+		if !complete(def, ins[i+1:]) {
+			fmt.Fprintf(&out, "%04d ERROR: %s is cut short\n", i, def.Name)
+			break
+		}
+
 		operands, read := ReadOperands(def, ins[i+1:])
 		fmt.Fprintf(&out, "%04d %s\n", i, ins.FmtInstruction(def, operands))
 
@@ -154,6 +276,15 @@ func (ins Instructions) String() string {
 	return out.String()
 }
 
+// complete reports whether ins holds all the operands of def.
+func complete(def *Definition, ins Instructions) bool {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return len(ins) >= width
+}
+
 func (ins Instructions) FmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
@@ -170,7 +301,11 @@ func (ins Instructions) FmtInstruction(def *Definition, operands []int) string {
 		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
-	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
 }
 
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
@@ -179,6 +314,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -197,3 +334,6 @@ func ReadUint8(ins Instructions) uint8 {
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
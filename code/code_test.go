@@ -14,6 +14,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpConstantWide, []int{65536}, []byte{byte(OpConstantWide), 0, 1, 0, 0}},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
@@ -66,6 +67,7 @@ func TestReadOperands(t *testing.T) {
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
 		{OpClosure, []int{65535, 255}, 3},
+		{OpClosureWide, []int{70000, 256}, 6},
 	}
 
 	for _, tt := range tests {
@@ -88,3 +90,49 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestWiden(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		fits     bool
+		wide     Opcode
+	}{
+		{OpGetLocal, []int{255}, true, OpGetLocalWide},
+		{OpGetLocal, []int{256}, false, OpGetLocalWide},
+		{OpClosure, []int{1, 256}, false, OpClosureWide},
+		{OpJump, []int{65536}, false, OpJumpWide},
+	}
+
+	for _, tt := range tests {
+		if fits := Fits(tt.op, tt.operands...); fits != tt.fits {
+			t.Errorf("Fits(%d, %v) wrong. want=%t, got=%t", tt.op, tt.operands, tt.fits, fits)
+		}
+
+		wide, ok := Widen(tt.op)
+		if !ok || wide != tt.wide {
+			t.Fatalf("Widen(%d) wrong. want=%d, got=%d", tt.op, tt.wide, wide)
+		}
+		if !Fits(wide, tt.operands...) {
+			t.Errorf("operands %v do not fit the wide variant of %d", tt.operands, tt.op)
+		}
+	}
+
+	if _, ok := Widen(OpSetGlobal); ok {
+		t.Errorf("OpSetGlobal has no wide variant")
+	}
+}
+
+func TestInstructionsStringBadBytes(t *testing.T) {
+	ins := Instructions{255, byte(OpAdd)}
+	ins = append(ins, Make(OpConstant, 1)[:2]...)
+
+	expected := `0000 ERROR: no definition for opcode 255
+0001 OpAdd
+0002 ERROR: OpConstant is cut short
+`
+
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, ins.String())
+	}
+}
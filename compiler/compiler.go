@@ -11,12 +11,21 @@ import (
 	"zumbra/object"
 	"zumbra/object/builtins"
 	"zumbra/parser"
+	"zumbra/resolver"
 )
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	handlers            []object.ExceptionHandler
+	finallyBlocks       []*ast.BlockStatement
+	// farJumps holds the targets of the jumps too far for their operand.
+	farJumps map[int]int
+	// positions is the line table of the instructions.
+	positions []object.SourcePosition
+	// loops is the number of loops around the code being compiled.
+	loops int
 }
 
 type Compiler struct {
@@ -27,6 +36,25 @@ type Compiler struct {
 	scopeIndex          int
 	importedFiles       map[string]bool
 	currentDir          string
+	enums               map[string]*object.Enum
+	chainedIfs          map[*ast.IfExpression]bool
+	warnings            []string
+	modules             map[string]compiledModule
+	resolver            *resolver.Resolver
+	functions           map[*ast.FunctionStatement]Symbol
+	// vars holds the variables defined ahead of their declaration because
+	// a hoisted function uses them.
+	vars          map[*ast.VarStatement]Symbol
+	constantIndex map[constantKey]int
+	// policy decides which builtins the program and its imports may use.
+	policy builtins.Policy
+	// err is the first limit of the VM the program went over.
+	err error
+	// file is the name of the file being compiled and position the
+	// source of the node being compiled, recorded for the instructions
+	// emitted for it.
+	file     string
+	position object.SourcePosition
 }
 
 func New() *Compiler {
@@ -37,10 +65,7 @@ func New() *Compiler {
 	}
 
 	symbolTable := NewSymbolTable()
-
-	for i, v := range builtins.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(builtins.Policy{})
 
 	cwd, _ := os.Getwd()
 	return &Compiler{
@@ -50,6 +75,12 @@ func New() *Compiler {
 		scopeIndex:    0,
 		importedFiles: map[string]bool{},
 		currentDir:    cwd,
+		enums:         map[string]*object.Enum{},
+		chainedIfs:    map[*ast.IfExpression]bool{},
+		modules:       map[string]compiledModule{},
+		resolver:      resolver.New(cwd),
+		functions:     map[*ast.FunctionStatement]Symbol{},
+		vars:          map[*ast.VarStatement]Symbol{},
 	}
 }
 
@@ -67,13 +98,24 @@ func NewWithStateAndDir(s *SymbolTable, constants []object.Object, baseDir strin
 		scopeIndex:    0,
 		importedFiles: map[string]bool{},
 		currentDir:    baseDir, // <-- Agora passa o caminho correto aqui
+		enums:         map[string]*object.Enum{},
+		chainedIfs:    map[*ast.IfExpression]bool{},
+		modules:       map[string]compiledModule{},
+		resolver:      resolver.New(baseDir),
+		functions:     map[*ast.FunctionStatement]Symbol{},
+		vars:          map[*ast.VarStatement]Symbol{},
 	}
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	defer c.locate(node)()
 
 	switch node := node.(type) {
 	case *ast.Program:
+		if err := c.hoistFunctions(node.Statements); err != nil {
+			return err
+		}
+
 		for _, statement := range node.Statements {
 			err := c.Compile(statement)
 			if err != nil {
@@ -89,6 +131,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
+		if value := constantValue(node); value != nil {
+			c.emitConstant(value)
+			return nil
+		}
 
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -131,6 +177,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.PrefixExpression:
+		if value := constantValue(node); value != nil {
+			c.emitConstant(value)
+			return nil
+		}
+
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -164,6 +215,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IfExpression:
+		if !c.chainedIfs[node] {
+			c.checkEnumExhaustive(node)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -178,6 +233,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 
 		jumpPos := c.emit(code.OpJump, 9999)
@@ -195,6 +252,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 
@@ -202,6 +261,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.changeOperand(jumpPos, afterAlternativePos)
 
 	case *ast.BlockStatement:
+		if err := c.hoistFunctions(node.Statements); err != nil {
+			return err
+		}
+
 		for _, statement := range node.Statements {
 			err := c.Compile(statement)
 			if err != nil {
@@ -210,21 +273,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.VarStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		symbol, hoisted := c.vars[node]
+		if !hoisted {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
+		// A hoisted function may have captured the variable already, so
+		// it is assigned through its cell.
+		if hoisted && symbol.Scope == LocalScope {
+			c.emit(code.OpAssignLocal, symbol.Index)
 		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+			c.setSymbol(symbol)
 		}
 
+	case *ast.EnumStatement:
+		members := []string{}
+		for _, m := range node.Members {
+			members = append(members, m.Value)
+		}
+
+		enum := object.NewEnum(node.Name.Value, members)
+		c.enums[enum.Name] = enum
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.emit(code.OpConstant, c.addConstant(enum))
+		c.setSymbol(symbol)
+
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
+			if caps, builtin := builtins.Needs(node.Value); builtin && !c.policy.Allows(caps) {
+				return fmt.Errorf("%s is not allowed by the sandbox, it uses %s", node.Value, caps&c.policy.Denied)
+			}
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
 
@@ -279,51 +363,49 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.FunctionLiteral:
-		c.enterScope()
+		return c.compileFunctionLiteral(node)
 
-		if node.Name != "" {
-			c.symbolTable.DefineFunctionName(node.Name)
-		}
+	case *ast.FunctionStatement:
+		// Created by hoistFunctions when the enclosing block started.
+		return nil
 
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
 		}
 
-		err := c.Compile(node.Body)
+		err = c.compilePendingFinally()
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
-		}
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
-		}
+		c.emit(code.OpReturnValue)
 
-		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
+	case *ast.TryStatement:
+		return c.compileTry(node)
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
 		}
 
-		compiledFn := &object.CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters),
-		}
-		fnIndex := c.addConstant(compiledFn)
-		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+		c.emit(code.OpThrow)
 
-	case *ast.ReturnStatement:
-		err := c.Compile(node.ReturnValue)
+	case *ast.YieldStatement:
+		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
-		c.emit(code.OpReturnValue)
+		c.emit(code.OpYield)
+
+	case *ast.ForStatement:
+		err := c.compileFor(node)
+		if err != nil {
+			return err
+		}
 
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
@@ -353,8 +435,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.ImportStatement:
+		if node.Alias != nil {
+			return c.compileModule(node)
+		}
 		return c.compileImport(node)
 
+	case *ast.ExportStatement:
+		return c.Compile(node.Statement)
+
 	case *ast.AttributeAccess:
 		if err := c.Compile(node.Object); err != nil {
 			return err
@@ -365,33 +453,110 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	}
 
-	return nil
+	return c.err
+}
+
+func (c *Compiler) Warnings() []string {
+	return c.warnings
+}
+
+// SetImportRoot only lets the program import files inside root or one of
+// the allowed directories, and the standard library.
+func (c *Compiler) SetImportRoot(root string, allowed ...string) {
+	c.resolver.Sandbox = root
+	c.resolver.Allowed = allowed
+}
+
+// SetPolicy keeps the program and the modules it imports from using the
+// builtins policy denies.
+func (c *Compiler) SetPolicy(policy builtins.Policy) {
+	c.policy = policy
+	c.symbolTable.DefineBuiltins(policy)
+}
+
+// SetFile names the file being compiled in the line tables. Imported
+// files are named by the path they are imported with. The file counts as
+// being imported, so that an import leading back to it is reported as a
+// cycle.
+func (c *Compiler) SetFile(name string) {
+	c.file = name
+
+	if path, err := filepath.Abs(name); err == nil {
+		c.resolver.Enter(path)
+	}
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	c.widenJumps()
+
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Handlers:     c.scopes[c.scopeIndex].handlers,
+		Positions:    c.scopes[c.scopeIndex].positions,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
-}
-
-func (c *Compiler) addConstant(obj object.Object) int {
-	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	Handlers     []object.ExceptionHandler
+	// Positions is the line table of the main program.
+	Positions []object.SourcePosition
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	op = c.fit(op, operands)
 	instruction := code.Make(op, operands...)
 	pos := c.addInstruction(instruction)
 	c.setLastInstruction(op, pos)
+	c.mark(pos)
 	return pos
 }
 
+// locate makes node the source of the instructions emitted until the
+// returned function restores the previous one. An infix expression is
+// located at its operator, which is what fails when the operands do not
+// fit it, and nodes built without tokens keep the position of their
+// parent.
+func (c *Compiler) locate(node ast.Node) func() {
+	previous := c.position
+
+	tok := ast.Start(node)
+	if infix, ok := node.(*ast.InfixExpression); ok {
+		tok = infix.Token
+	}
+	if tok.Line > 0 {
+		c.position = object.SourcePosition{File: c.file, Line: tok.Line, Column: tok.Column}
+	}
+
+	return func() { c.position = previous }
+}
+
+// mark records the current position for the instruction at pos, unless it
+// continues the last entry of the line table.
+func (c *Compiler) mark(pos int) {
+	if c.position.Line == 0 {
+		return
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	entry := c.position
+	entry.Offset = pos
+
+	if n := len(scope.positions); n > 0 {
+		last := &scope.positions[n-1]
+		if last.File == entry.File && last.Line == entry.Line && last.Column == entry.Column {
+			return
+		}
+		if last.Offset == pos {
+			*last = entry
+			return
+		}
+	}
+	scope.positions = append(scope.positions, entry)
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	prev := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Pos: pos}
@@ -423,6 +588,12 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= last.Pos {
+		positions = positions[:len(positions)-1]
+	}
+	c.scopes[c.scopeIndex].positions = positions
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -434,6 +605,11 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 
 func (c *Compiler) changeOperand(pos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[pos])
+	if !code.Fits(op, operand) {
+		c.farJump(pos, operand)
+		return
+	}
+
 	newInstruction := code.Make(op, operand)
 	c.replaceInstruction(pos, newInstruction)
 }
@@ -501,6 +677,162 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// loadCell pushes the cell of a variable being captured by a closure.
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// compileFunctionLiteral emits the closure for node. The closure captures
+// the cells of the variables it uses from enclosing functions, so writes
+// to them are seen on both sides.
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	c.widenJumps()
+	c.markTailCalls(node.IsGenerator)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	c.checkLocals(numLocals)
+	handlers := c.scopes[c.scopeIndex].handlers
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.loadCell(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name:          node.Name,
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Handlers:      handlers,
+		IsGenerator:   node.IsGenerator,
+		Positions:     positions,
+	}
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
+
+// hoistFunctions creates the functions declared in statements before any
+// of the statements run, so that they can be called from anywhere in the
+// block, as the evaluator does. The variables of the block the functions
+// use are defined first, so the functions can refer to them.
+func (c *Compiler) hoistFunctions(statements []ast.Statement) error {
+	functions := []*ast.FunctionStatement{}
+	for _, statement := range statements {
+		if es, ok := statement.(*ast.ExportStatement); ok {
+			statement = es.Statement
+		}
+
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			c.functions[fs] = c.symbolTable.Define(fs.Name.Value)
+			functions = append(functions, fs)
+		}
+	}
+	if len(functions) == 0 {
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, fs := range functions {
+		ast.Inspect(fs.Function, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Identifier); ok {
+				names[ident.Value] = true
+			}
+			return true
+		})
+	}
+	for _, fs := range functions {
+		delete(names, fs.Name.Value)
+	}
+
+	for _, statement := range statements {
+		if es, ok := statement.(*ast.ExportStatement); ok {
+			statement = es.Statement
+		}
+
+		if vs, ok := statement.(*ast.VarStatement); ok && names[vs.Name.Value] {
+			symbol := c.symbolTable.Define(vs.Name.Value)
+			c.vars[vs] = symbol
+			// Only the first declaration is the one the functions see.
+			delete(names, vs.Name.Value)
+
+			// In a loop, the slot still holds the cell the functions of
+			// the last iteration captured, which they keep to themselves.
+			if symbol.Scope == LocalScope && c.scopes[c.scopeIndex].loops > 0 {
+				c.emit(code.OpNull)
+				c.setSymbol(symbol)
+			}
+		}
+	}
+
+	for _, fs := range functions {
+		if err := c.createFunction(fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFunction emits the closure of a hoisted function. Closures created
+// before it may already have captured its variable, so the function is
+// assigned through the variable's cell.
+func (c *Compiler) createFunction(fs *ast.FunctionStatement) error {
+	defer c.locate(fs)()
+
+	if err := c.compileFunctionLiteral(fs.Function); err != nil {
+		return err
+	}
+
+	symbol := c.functions[fs]
+	if symbol.Scope == LocalScope {
+		c.emit(code.OpAssignLocal, symbol.Index)
+	} else {
+		c.setSymbol(symbol)
+	}
+
+	return nil
+}
+
 func (c *Compiler) compileWhile(stmt *ast.WhileStatement) error {
 	loopStartPos := len(c.currentInstructions())
 
@@ -510,7 +842,7 @@ func (c *Compiler) compileWhile(stmt *ast.WhileStatement) error {
 
 	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-	if err := c.Compile(stmt.Body); err != nil {
+	if err := c.compileLoopBody(stmt.Body); err != nil {
 		return err
 	}
 
@@ -522,6 +854,149 @@ func (c *Compiler) compileWhile(stmt *ast.WhileStatement) error {
 	return nil
 }
 
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement) error {
+	c.scopes[c.scopeIndex].loops++
+	defer func() { c.scopes[c.scopeIndex].loops-- }()
+
+	return c.Compile(body)
+}
+
+// compileFor keeps the loop's iterator in a hidden variable, since the
+// stack does not survive errors caught inside the body.
+func (c *Compiler) compileFor(stmt *ast.ForStatement) error {
+	if err := c.Compile(stmt.Iterable); err != nil {
+		return err
+	}
+
+	c.emit(code.OpIter)
+	iterator := c.symbolTable.Define("@iterator")
+	c.setSymbol(iterator)
+
+	loopStartPos := len(c.currentInstructions())
+
+	c.loadSymbol(iterator)
+	iterNextPos := c.emit(code.OpIterNext, 9999)
+
+	symbol := c.symbolTable.Define(stmt.Name.Value)
+	c.setSymbol(symbol)
+
+	if err := c.compileLoopBody(stmt.Body); err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, loopStartPos)
+
+	afterLoopPos := len(c.currentInstructions())
+	c.changeOperand(iterNextPos, afterLoopPos)
+
+	return nil
+}
+
+// compileTry lays out a try statement as
+//
+//	try body, finally, jump to end
+//	catch:    bind error, catch body, finally, jump to end
+//	rethrow:  finally, throw the pending error
+//
+// and registers handlers sending errors from the try body to the catch
+// block and errors escaping it to the rethrow block.
+func (c *Compiler) compileTry(stmt *ast.TryStatement) error {
+	c.pushFinally(stmt)
+
+	start := len(c.currentInstructions())
+	if err := c.Compile(stmt.Block); err != nil {
+		return err
+	}
+	end := len(c.currentInstructions())
+
+	exits := []int{}
+	guardedStart, guardedEnd := start, end
+
+	if stmt.Catch != nil {
+		c.popFinally(stmt)
+		if err := c.compileOptional(stmt.Finally); err != nil {
+			return err
+		}
+		exits = append(exits, c.emit(code.OpJump, 9999))
+		c.pushFinally(stmt)
+
+		catchPos := len(c.currentInstructions())
+		c.addHandler(start, end, catchPos)
+
+		symbol := c.symbolTable.Define(stmt.Param.Value)
+		c.setSymbol(symbol)
+
+		if err := c.Compile(stmt.Catch); err != nil {
+			return err
+		}
+		guardedStart, guardedEnd = catchPos, len(c.currentInstructions())
+	}
+
+	c.popFinally(stmt)
+	if err := c.compileOptional(stmt.Finally); err != nil {
+		return err
+	}
+	exits = append(exits, c.emit(code.OpJump, 9999))
+
+	if stmt.Finally != nil {
+		c.addHandler(guardedStart, guardedEnd, len(c.currentInstructions()))
+
+		if err := c.Compile(stmt.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	afterTryPos := len(c.currentInstructions())
+	for _, pos := range exits {
+		c.changeOperand(pos, afterTryPos)
+	}
+
+	return nil
+}
+
+func (c *Compiler) pushFinally(stmt *ast.TryStatement) {
+	if stmt.Finally != nil {
+		scope := &c.scopes[c.scopeIndex]
+		scope.finallyBlocks = append(scope.finallyBlocks, stmt.Finally)
+	}
+}
+
+func (c *Compiler) popFinally(stmt *ast.TryStatement) {
+	if stmt.Finally != nil {
+		scope := &c.scopes[c.scopeIndex]
+		scope.finallyBlocks = scope.finallyBlocks[:len(scope.finallyBlocks)-1]
+	}
+}
+
+func (c *Compiler) compileOptional(block *ast.BlockStatement) error {
+	if block == nil {
+		return nil
+	}
+	return c.Compile(block)
+}
+
+// compilePendingFinally inlines the finally blocks a return leaves, from the
+// innermost outwards.
+func (c *Compiler) compilePendingFinally() error {
+	blocks := c.scopes[c.scopeIndex].finallyBlocks
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		c.scopes[c.scopeIndex].finallyBlocks = blocks[:i]
+		if err := c.Compile(blocks[i]); err != nil {
+			return err
+		}
+	}
+
+	c.scopes[c.scopeIndex].finallyBlocks = blocks
+	return nil
+}
+
+func (c *Compiler) addHandler(start, end, target int) {
+	handler := object.ExceptionHandler{Start: start, End: end, Target: target}
+	c.scopes[c.scopeIndex].handlers = append(c.scopes[c.scopeIndex].handlers, handler)
+}
+
 func (c *Compiler) compileAssign(stmt *ast.AssignStatement) error {
 	if err := c.Compile(stmt.Value); err != nil {
 		return err
@@ -536,7 +1011,9 @@ func (c *Compiler) compileAssign(stmt *ast.AssignStatement) error {
 	case GlobalScope:
 		c.emit(code.OpSetGlobal, symbol.Index)
 	case LocalScope:
-		c.emit(code.OpSetLocal, symbol.Index)
+		c.emit(code.OpAssignLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, symbol.Index)
 	default:
 		return fmt.Errorf("unsupported assignment target scope: %s", symbol.Scope)
 	}
@@ -545,39 +1022,149 @@ func (c *Compiler) compileAssign(stmt *ast.AssignStatement) error {
 }
 
 func (c *Compiler) compileImport(stmt *ast.ImportStatement) error {
+	src, err := c.resolver.Resolve(stmt.Path.Value, c.currentDir)
+	if err != nil {
+		return err
+	}
+
+	if err := c.resolver.Enter(src.Name); err != nil {
+		return err
+	}
+	defer c.resolver.Leave()
+
+	if c.importedFiles[src.Name] {
+		return nil
+	}
+
+	c.importedFiles[src.Name] = true
+
+	program, err := parseImport(src, stmt.Path.Value)
+	if err != nil {
+		return err
+	}
+
+	oldDir, oldFile := c.currentDir, c.file
+	c.currentDir, c.file = src.Dir, stmt.Path.Value
+
+	err = c.Compile(program)
+	c.currentDir, c.file = oldDir, oldFile
+
+	return err
+}
+
+// compiledModule is a module compiled into a function that loads it,
+// kept in the constant pool, and the hidden global the module is kept in.
+type compiledModule struct {
+	global Symbol
+	loader int
+}
+
+// compileModule compiles `import "x.zum" as m`. The module is compiled
+// once, into a function run by the first import the program reaches, so
+// imports in functions and branches that have not run yet do not matter.
+// Its names have a global symbol table of their own, whose slots follow
+// the ones defined so far, so they never clash with the importer's.
+func (c *Compiler) compileModule(stmt *ast.ImportStatement) error {
 	path := stmt.Path.Value
 
-	if c.importedFiles == nil {
-		c.importedFiles = make(map[string]bool)
+	src, err := c.resolver.Resolve(path, c.currentDir)
+	if err != nil {
+		return err
 	}
 
-	importFullPath := filepath.Join(c.currentDir, path)
-	importFullPath = filepath.Clean(importFullPath)
+	if err := c.resolver.Enter(src.Name); err != nil {
+		return err
+	}
+	defer c.resolver.Leave()
 
-	if c.importedFiles[importFullPath] {
-		return nil
+	module, ok := c.modules[src.Name]
+	if !ok {
+		program, err := parseImport(src, path)
+		if err != nil {
+			return err
+		}
+
+		module, err = c.compileModuleProgram(program, src, path)
+		if err != nil {
+			return err
+		}
+
+		c.modules[src.Name] = module
 	}
 
-	c.importedFiles[importFullPath] = true
+	c.emit(code.OpImport, module.global.Index, module.loader)
+	symbol := c.symbolTable.Define(stmt.Alias.Value)
+	c.setSymbol(symbol)
+
+	return nil
+}
+
+// compileModuleProgram compiles the code of a module into the function
+// that loads it. The function runs the code, collects the exported values
+// into a module object and keeps it in a hidden global.
+func (c *Compiler) compileModuleProgram(program *ast.Program, src *resolver.Source, name string) (compiledModule, error) {
+	global := c.symbolTable
+	for global.Outer != nil {
+		global = global.Outer
+	}
 
-	content, err := os.ReadFile(importFullPath)
+	moduleTable := NewSymbolTable()
+	moduleTable.DefineBuiltins(c.policy)
+	moduleTable.numDefinitions = global.numDefinitions
+
+	outerTable, outerDir, outerFile := c.symbolTable, c.currentDir, c.file
+	c.enterScope()
+	c.symbolTable, c.currentDir, c.file = moduleTable, src.Dir, name
+
+	var module Symbol
+	err := c.Compile(program)
+	if err == nil {
+		exports := program.Exports()
+		sort.Strings(exports)
+
+		for _, export := range exports {
+			symbol, _ := moduleTable.Resolve(export)
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: export}))
+			c.loadSymbol(symbol)
+		}
+
+		nameIndex := c.addConstant(&object.String{Value: name})
+		c.emit(code.OpModule, nameIndex, len(exports)*2)
+
+		global.numDefinitions = moduleTable.numDefinitions
+		module = global.Define("@module " + src.Name)
+		c.emit(code.OpSetGlobal, module.Index)
+		c.emit(code.OpGetGlobal, module.Index)
+		c.emit(code.OpReturnValue)
+		c.widenJumps()
+	}
+
+	handlers := c.scopes[c.scopeIndex].handlers
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+	c.symbolTable, c.currentDir, c.file = outerTable, outerDir, outerFile
 	if err != nil {
-		return fmt.Errorf("could not read imported file: %s", path)
+		return compiledModule{}, err
 	}
 
-	l := lexer.New(string(content))
+	loader := c.addConstant(&object.CompiledFunction{
+		Name:         "module " + name,
+		Instructions: instructions,
+		Handlers:     handlers,
+		Positions:    positions,
+	})
+
+	return compiledModule{global: module, loader: loader}, nil
+}
+
+func parseImport(src *resolver.Source, path string) (*ast.Program, error) {
+	l := lexer.New(src.Content)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		return fmt.Errorf("could not parse imported file: %s", path)
+		return nil, fmt.Errorf("could not parse imported file: %s", path)
 	}
 
-	oldDir := c.currentDir
-	c.currentDir = filepath.Dir(importFullPath)
-
-	err = c.Compile(program)
-	c.currentDir = oldDir
-
-	return err
+	return program, nil
 }
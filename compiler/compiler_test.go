@@ -2,11 +2,14 @@ package compiler
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"zumbra/ast"
 	"zumbra/code"
 	"zumbra/lexer"
 	"zumbra/object"
+	"zumbra/object/builtins"
 	"zumbra/parser"
 )
 
@@ -19,10 +22,12 @@ type compilerTestCase struct {
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input:             "1 + 2",
+			input:             "var a << 1; a + 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
 				code.Make(code.OpPop),
@@ -39,40 +44,48 @@ func TestIntegerArithmetic(t *testing.T) {
 			},
 		},
 		{
-			input:             "1 - 2",
+			input:             "var a << 1; a - 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpSub),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 * 2",
+			input:             "var a << 1; a * 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpMul),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "2 / 1",
+			input:             "var a << 2; a / 1",
 			expectedConstants: []interface{}{2, 1},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpDiv),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "-1",
+			input:             "var a << 1; -a",
 			expectedConstants: []interface{}{1},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpMinus),
 				code.Make(code.OpPop),
 			},
@@ -95,6 +108,9 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		if err != nil {
 			t.Fatalf("testInstructions failed: %s", err)
 		}
+		if err := Verify(bytecode); err != nil {
+			t.Fatalf("verify error for %q: %s", tt.input, err)
+		}
 		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
 		if err != nil {
 			t.Fatalf("testConstants failed: %s", err)
@@ -151,6 +167,13 @@ func testConstants(
 					i, err)
 			}
 
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s",
+					i, err)
+			}
+
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -190,6 +213,20 @@ func testStringObject(expected string, actual object.Object) error {
 
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+
+	if !ok {
+		return fmt.Errorf("object is not *object.Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. want=%g, got=%g", expected, result.Value)
+	}
+
+	return nil
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 
@@ -223,70 +260,84 @@ func TestBooleanExpressions(t *testing.T) {
 			},
 		},
 		{
-			input:             "1 > 2",
+			input:             "var a << 1; a > 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpGreaterThan),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 < 2",
+			input:             "var a << 1; a < 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 == 2",
+			input:             "var a << 1; a == 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 != 2",
+			input:             "var a << 1; a != 2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpNotEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "true == false",
+			input:             "var a << true; a == false",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpFalse),
 				code.Make(code.OpEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "true != false",
+			input:             "var a << true; a != false",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpFalse),
 				code.Make(code.OpNotEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "!true",
+			input:             "var a << true; !a",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpBang),
 				code.Make(code.OpPop),
 			},
@@ -408,10 +459,12 @@ func TestStringExpressions(t *testing.T) {
 			},
 		},
 		{
-			input:             `"mon" + "key"`,
+			input:             `var a << "mon"; a + "key"`,
 			expectedConstants: []interface{}{"mon", "key"},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
 				code.Make(code.OpPop),
@@ -445,17 +498,11 @@ func TestArrayLiterals(t *testing.T) {
 		},
 		{
 			input:             "[1 + 2, 3 - 4, 5 * 6]",
-			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedConstants: []interface{}{3, -1, 30},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpMul),
 				code.Make(code.OpArray, 3),
 				code.Make(code.OpPop),
 			},
@@ -490,16 +537,12 @@ func TestDictLiterals(t *testing.T) {
 		},
 		{
 			input:             "{1: 2 + 3, 4: 5 * 6}",
-			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedConstants: []interface{}{1, 5, 4, 30},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpAdd),
 				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpMul),
 				code.Make(code.OpDict, 4),
 				code.Make(code.OpPop),
 			},
@@ -512,29 +555,25 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 1),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpDict, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
@@ -548,34 +587,28 @@ func TestFunctions(t *testing.T) {
 		{
 			input: `fct() { return 5 + 10 }`,
 			expectedConstants: []interface{}{
-				5,
-				10,
+				15,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input: `fct() { 5 + 10 }`,
 			expectedConstants: []interface{}{
-				5,
-				10,
+				15,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -849,7 +882,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -880,7 +913,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -911,13 +944,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -962,15 +995,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1000,16 +1033,15 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -1029,21 +1061,20 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -1078,3 +1109,625 @@ func TestCompileAttributeAccess(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestEnums(t *testing.T) {
+	program := parse(`
+	enum Status { Pending, Paid };
+	Status.Paid;
+	`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpGetAttr),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	enum, ok := bytecode.Constants[0].(*object.Enum)
+	if !ok {
+		t.Fatalf("constant 0 is not *object.Enum. got=%T", bytecode.Constants[0])
+	}
+
+	if enum.Inspect() != "enum Status { Pending, Paid }" {
+		t.Errorf("wrong enum. got=%q", enum.Inspect())
+	}
+}
+
+func TestEnumExhaustivenessWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input: `
+			enum Status { Pending, Paid, Shipped };
+			var s << Status.Paid;
+			if (s == Status.Pending) { 1 } else { if (s == Status.Paid) { 2 } };
+			`,
+			expected: []string{"non-exhaustive match on s: missing Status.Shipped"},
+		},
+		{
+			input: `
+			enum Status { Pending, Paid, Shipped };
+			var s << Status.Paid;
+			if (s == Status.Pending or s == Status.Shipped) { 1 } else { if (Status.Paid == s) { 2 } };
+			`,
+			expected: []string{},
+		},
+		{
+			input: `
+			enum Status { Pending, Paid, Shipped };
+			var s << Status.Paid;
+			if (s == Status.Pending) { 1 } else { if (s == Status.Paid) { 2 } else { 3 } };
+			`,
+			expected: []string{},
+		},
+		{
+			input: `
+			enum Status { Pending, Paid, Shipped };
+			var s << Status.Paid;
+			if (s == Status.Pending) { 1 };
+			`,
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		warnings := compiler.Warnings()
+		if len(warnings) != len(tt.expected) {
+			t.Fatalf("wrong number of warnings. want=%v, got=%v", tt.expected, warnings)
+		}
+
+		for i, w := range tt.expected {
+			if warnings[i] != w {
+				t.Errorf("wrong warning. want=%q, got=%q", w, warnings[i])
+			}
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	program := parse(`try { throw 1; } catch (e) { e; }`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		// 0000
+		code.Make(code.OpConstant, 0),
+		// 0003
+		code.Make(code.OpThrow),
+		// 0004
+		code.Make(code.OpJump, 17),
+		// 0007
+		code.Make(code.OpSetGlobal, 0),
+		// 0010
+		code.Make(code.OpGetGlobal, 0),
+		// 0013
+		code.Make(code.OpPop),
+		// 0014
+		code.Make(code.OpJump, 17),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	expected := []object.ExceptionHandler{{Start: 0, End: 4, Target: 7}}
+	if len(bytecode.Handlers) != len(expected) || bytecode.Handlers[0] != expected[0] {
+		t.Errorf("wrong handlers. want=%+v, got=%+v", expected, bytecode.Handlers)
+	}
+}
+
+func TestTryFinally(t *testing.T) {
+	program := parse(`try { 1; } finally { 2; }`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		// 0000
+		code.Make(code.OpConstant, 0),
+		// 0003
+		code.Make(code.OpPop),
+		// 0004
+		code.Make(code.OpConstant, 1),
+		// 0007
+		code.Make(code.OpPop),
+		// 0008
+		code.Make(code.OpJump, 16),
+		// 0011
+		code.Make(code.OpConstant, 1),
+		// 0014
+		code.Make(code.OpPop),
+		// 0015
+		code.Make(code.OpThrow),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	expected := []object.ExceptionHandler{{Start: 0, End: 4, Target: 11}}
+	if len(bytecode.Handlers) != len(expected) || bytecode.Handlers[0] != expected[0] {
+		t.Errorf("wrong handlers. want=%+v, got=%+v", expected, bytecode.Handlers)
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `for (x in [1, 2]) { x; }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpArray, 2),
+				// 0009
+				code.Make(code.OpIter),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpGetGlobal, 0),
+				// 0016
+				code.Make(code.OpIterNext, 29),
+				// 0019
+				code.Make(code.OpSetGlobal, 1),
+				// 0022
+				code.Make(code.OpGetGlobal, 1),
+				// 0025
+				code.Make(code.OpPop),
+				// 0026
+				code.Make(code.OpJump, 13),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fct() { yield 1; yield 2; }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpYield),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpYield),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	program := parse(`fct() { yield 1; }; fct() { fct() { yield 1; }; };`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	generators := []bool{}
+	for _, constant := range compiler.Bytecode().Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			generators = append(generators, fn.IsGenerator)
+		}
+	}
+
+	expected := []bool{true, true, false}
+	if fmt.Sprint(generators) != fmt.Sprint(expected) {
+		t.Errorf("wrong generator flags. want=%v, got=%v", expected, generators)
+	}
+}
+
+func TestModuleGlobalsAreIsolated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.zum")
+	if err := os.WriteFile(path, []byte(`var x << 5;`), 0644); err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	// The module is compiled into the function that loads it.
+	loader := []code.Instructions{
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpModule, 3, 2),
+		code.Make(code.OpSetGlobal, 2),
+		code.Make(code.OpGetGlobal, 2),
+		code.Make(code.OpReturnValue),
+	}
+
+	tests := []compilerTestCase{
+		{
+			input:             fmt.Sprintf(`var x << 1; import "%s" as m; var y << 2;`, path),
+			expectedConstants: []interface{}{1, 5, "x", path, loader, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpImport, 2, 4),
+				code.Make(code.OpSetGlobal, 3),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpSetGlobal, 4),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImportRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("could not create project: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "outside.zum"), []byte(`var x << 1;`), 0644); err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	for _, input := range []string{`import "../outside.zum"`, `import "../outside.zum" as m`} {
+		comp := NewWithStateAndDir(NewSymbolTable(), []object.Object{}, root)
+		comp.SetImportRoot(root)
+
+		err := comp.Compile(parse(input))
+		expected := "import ../outside.zum escapes the import root " + root
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", input, expected, err)
+		}
+
+		comp = NewWithStateAndDir(NewSymbolTable(), []object.Object{}, root)
+		comp.SetImportRoot(root, base)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Errorf("unexpected error for %s with %s allowed: %s", input, base, err)
+		}
+	}
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fct a() { b() }
+			fct b() { 1 }
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `
+			fct f() {
+				fct a() { b() }
+				fct b() { 1 }
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpAssignLocal, 0),
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpAssignLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-7 / 2; 7 % 3",
+			expectedConstants: []interface{}{-3, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 + 0.5; 1.5 * 2",
+			expectedConstants: []interface{}{1.5, 3.0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key" + "!"`,
+			expectedConstants: []interface{}{"monkey!"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 < 2; 2.5 >= 3; "a" == "b"; true != false; !true; !5; true and 0`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "var a << 1; a + 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+// TestConstantFoldingKeepsRuntimeErrors checks operations the VM rejects
+// are left for it to reject.
+func TestConstantFoldingKeepsRuntimeErrors(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 / 0",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1.5",
+			expectedConstants: []interface{}{1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" != "b"`,
+			expectedConstants: []interface{}{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" * 2`,
+			expectedConstants: []interface{}{"a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; 1.0; "1"; 1; 1.0; "1"`,
+			expectedConstants: []interface{}{1, 1.0, "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `var d << {"hour": 1}; d.hour; d.hour; fct() { d.hour }`,
+			expectedConstants: []interface{}{
+				"hour",
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetAttr),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDict, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetAttr),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetAttr),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+// TestConstantInterningAcrossCompilers checks a compiler given the
+// constants of an earlier one, as the REPL does, reuses them.
+func TestConstantInterningAcrossCompilers(t *testing.T) {
+	first := New()
+	if err := first.Compile(parse(`var s << "hour"; 2;`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	second := NewWithState(first.symbolTable, first.Bytecode().Constants)
+	if err := second.Compile(parse(`"hour"; 2; 3;`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := testConstants(t, []interface{}{"hour", 2, 3}, second.Bytecode().Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input string
+		tail  bool
+	}{
+		{`fct f(n) { return f(n - 1); }`, true},
+		{`fct f(n) { if (n > 0) { f(n - 1) } else { 0 } }`, true},
+		{`fct f(n) { f(n - 1) + 1 }`, false},
+		{`fct f(n) { try { return f(n - 1); } catch (e) { 0 } }`, false},
+		{`fct f(n) { try { n } catch (e) { return f(n - 1); } }`, true},
+		{`fct f(n) { try { n } finally { show(n) } return f(n - 1); }`, true},
+		{`fct f(n) { yield 1; return f(n - 1); }`, false},
+	}
+
+	for _, tt := range tests {
+		bytecode := compileLarge(t, tt.input)
+		fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+
+		tail := false
+		for _, op := range opcodes(fn.Instructions) {
+			tail = tail || op == code.OpTailCall
+		}
+		if tail != tt.tail {
+			t.Errorf("wrong tail call for %q. want=%t, got=%t\n%s", tt.input, tt.tail, tail, fn.Instructions)
+		}
+	}
+}
+
+func TestSandboxPolicy(t *testing.T) {
+	tests := []struct {
+		input    string
+		policy   string
+		expected string
+	}{
+		{`input("?")`, "playground", "input is not allowed by the sandbox, it uses stdin"},
+		{`fct() { sendEmail("a", "b", "c") }`, "playground", "sendEmail is not allowed by the sandbox, it uses network"},
+		{`import "std:math" as math; randomFloat()`, "strict", "randomFloat is not allowed by the sandbox, it uses random"},
+		{`date()`, "playground", ""},
+		{`var input << fct(x) { x }; input("?")`, "strict", ""},
+		{`nope`, "strict", "undefined variable nope"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.SetPolicy(builtins.Policies[tt.policy])
+
+		err := compiler.Compile(parse(tt.input))
+		if tt.expected == "" && err != nil {
+			t.Errorf("compiler error for %q: %s", tt.input, err)
+		}
+		if tt.expected != "" && (err == nil || err.Error() != tt.expected) {
+			t.Errorf("wrong compiler error for %q: want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
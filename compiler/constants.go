@@ -0,0 +1,239 @@
+package compiler
+
+import (
+	"math"
+	"strconv"
+	"zumbra/ast"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+// constantKey identifies an integer, float or string constant by value, so
+// equal literals share one entry of the constant pool.
+type constantKey struct {
+	kind  object.ObjectType
+	value string
+}
+
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.Float:
+		// The bits tell 0.0 from -0.0.
+		return constantKey{obj.Type(), strconv.FormatUint(math.Float64bits(obj.Value), 16)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	default:
+		return constantKey{}, false
+	}
+}
+
+// addConstant returns the index of obj in the constant pool, reusing the
+// entry of an equal integer, float or string.
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, interned := keyOf(obj)
+
+	if interned {
+		if c.constantIndex == nil {
+			// The pool may come from an earlier compiler, as in the REPL.
+			c.constantIndex = map[constantKey]int{}
+			for i, constant := range c.constants {
+				if k, ok := keyOf(constant); ok {
+					if _, seen := c.constantIndex[k]; !seen {
+						c.constantIndex[k] = i
+					}
+				}
+			}
+		}
+
+		if i, ok := c.constantIndex[key]; ok {
+			return i
+		}
+	}
+
+	c.constants = append(c.constants, obj)
+	if interned {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// emitConstant emits the instruction that pushes a folded value.
+func (c *Compiler) emitConstant(obj object.Object) {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// constantValue returns the value of e when it is made of literals only,
+// or nil. Operations the VM rejects, like a division by zero or `-` on a
+// float, are not folded so that they still fail when the program runs.
+func constantValue(e ast.Expression) object.Object {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: e.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: e.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}
+	case *ast.Boolean:
+		return &object.Boolean{Value: e.Value}
+
+	case *ast.PrefixExpression:
+		right := constantValue(e.Right)
+		if right == nil {
+			return nil
+		}
+		return foldPrefix(e.Operator, right)
+
+	case *ast.InfixExpression:
+		left := constantValue(e.Left)
+		if left == nil {
+			return nil
+		}
+		right := constantValue(e.Right)
+		if right == nil {
+			return nil
+		}
+		return foldInfix(e.Operator, left, right)
+	}
+
+	return nil
+}
+
+func foldPrefix(operator string, right object.Object) object.Object {
+	switch operator {
+	case "!":
+		if b, ok := right.(*object.Boolean); ok {
+			return &object.Boolean{Value: !b.Value}
+		}
+		return &object.Boolean{Value: false}
+	case "-":
+		if i, ok := right.(*object.Integer); ok {
+			return &object.Integer{Value: -i.Value}
+		}
+	}
+	return nil
+}
+
+func foldInfix(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "and":
+		return &object.Boolean{Value: truthy(left) && truthy(right)}
+	case "or":
+		return &object.Boolean{Value: truthy(left) || truthy(right)}
+	}
+
+	switch left := left.(type) {
+	case *object.Integer:
+		switch right := right.(type) {
+		case *object.Integer:
+			return foldIntegers(operator, left.Value, right.Value)
+		case *object.Float:
+			return foldFloats(operator, float64(left.Value), right.Value)
+		}
+
+	case *object.Float:
+		switch right := right.(type) {
+		case *object.Integer:
+			return foldFloats(operator, left.Value, float64(right.Value))
+		case *object.Float:
+			return foldFloats(operator, left.Value, right.Value)
+		}
+
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			switch operator {
+			case "+":
+				return &object.String{Value: left.Value + right.Value}
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}
+			}
+		}
+
+	case *object.Boolean:
+		if right, ok := right.(*object.Boolean); ok {
+			switch operator {
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}
+			}
+		}
+	}
+
+	return nil
+}
+
+func foldIntegers(operator string, left, right int64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Integer{Value: left + right}
+	case "-":
+		return &object.Integer{Value: left - right}
+	case "*":
+		return &object.Integer{Value: left * right}
+	case "/":
+		if right != 0 {
+			return &object.Integer{Value: left / right}
+		}
+	case "%":
+		if right != 0 {
+			return &object.Integer{Value: left % right}
+		}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	}
+	return nil
+}
+
+func foldFloats(operator string, left, right float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}
+	case "-":
+		return &object.Float{Value: left - right}
+	case "*":
+		return &object.Float{Value: left * right}
+	case "/":
+		return &object.Float{Value: left / right}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	}
+	return nil
+}
+
+func truthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"zumbra/code"
+	"zumbra/object"
+	"zumbra/object/builtins"
+)
+
+// Disassemble lists the main program and every compiled function in the
+// constant pool. Functions follow the code that creates them, so nested
+// closures come right after their parent, and each instruction that refers
+// to a constant, a builtin or a function is annotated with it. When the
+// functions have line tables, the file, line and column each source line
+// starts at is shown above its instructions.
+func Disassemble(bytecode *Bytecode) string {
+	d := &disassembler{constants: bytecode.Constants, printed: map[int]bool{}}
+
+	d.function("main program", "main program", &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		Positions:    bytecode.Positions,
+	})
+
+	// Functions no instruction creates, such as the ones left behind by
+	// the optimizer.
+	for i := range bytecode.Constants {
+		d.nested(i, "")
+	}
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	out       bytes.Buffer
+	constants []object.Object
+	printed   map[int]bool
+}
+
+// nested prints the function at index in the constant pool, created by
+// the code in parent, unless it was printed already.
+func (d *disassembler) nested(index int, parent string) {
+	fn, ok := d.constant(index).(*object.CompiledFunction)
+	if !ok || d.printed[index] {
+		return
+	}
+	d.printed[index] = true
+
+	details := []string{
+		count(fn.NumParameters, "parameter"),
+		count(fn.NumLocals, "local"),
+	}
+	if fn.IsGenerator {
+		details = append(details, "generator")
+	}
+	if parent != "" {
+		details = append(details, "in "+parent)
+	}
+
+	d.out.WriteString("\n")
+	label := d.label(index)
+	d.function(label, label+": "+strings.Join(details, ", "), fn)
+}
+
+// function prints the instructions and handlers of fn, then the functions
+// its closures are made of.
+func (d *disassembler) function(name, header string, fn *object.CompiledFunction) {
+	fmt.Fprintf(&d.out, "== %s ==\n", header)
+
+	ins := fn.Instructions
+	children := []int{}
+	positions := fn.Positions
+	var shown object.SourcePosition
+
+	for pos := 0; pos < len(ins); {
+		// Show where each source line starts.
+		for len(positions) > 0 && positions[0].Offset <= pos {
+			if p := positions[0]; p.File != shown.File || p.Line != shown.Line {
+				fmt.Fprintf(&d.out, "     ; %s\n", p)
+				shown = p
+			}
+			positions = positions[1:]
+		}
+
+		in, size, err := readInstruction(ins, pos)
+		if err != nil {
+			fmt.Fprintf(&d.out, "%04d ERROR: %s\n", pos, err)
+			if _, lookupErr := code.Lookup(ins[pos]); lookupErr == nil {
+				// A known opcode that is cut short ends the instructions.
+				break
+			}
+			pos++
+			continue
+		}
+
+		def, _ := code.Lookup(byte(in.op))
+		line := fmt.Sprintf("%04d %s", pos, ins.FmtInstruction(def, in.operands))
+		if comment := d.comment(in); comment != "" {
+			line += " ; " + comment
+		}
+		d.out.WriteString(line + "\n")
+
+		switch in.op {
+		case code.OpClosure, code.OpClosureWide:
+			children = append(children, in.operands[0])
+		case code.OpImport:
+			children = append(children, in.operands[1])
+		}
+		pos += size
+	}
+
+	for _, h := range fn.Handlers {
+		fmt.Fprintf(&d.out, "handler %04d-%04d -> %04d\n", h.Start, h.End, h.Target)
+	}
+
+	for _, child := range children {
+		d.nested(child, name)
+	}
+}
+
+// comment describes what the operands of in refer to.
+func (d *disassembler) comment(in instruction) string {
+	switch in.op {
+	case code.OpConstant, code.OpConstantWide:
+		return d.value(in.operands[0])
+
+	case code.OpClosure, code.OpClosureWide:
+		return d.label(in.operands[0])
+
+	case code.OpGetBuiltin, code.OpGetBuiltinWide:
+		if in.operands[0] < len(builtins.Builtins) {
+			return builtins.Builtins[in.operands[0]].Name
+		}
+		return "?"
+
+	case code.OpModule, code.OpModuleWide:
+		return "module " + d.value(in.operands[0])
+
+	case code.OpImport:
+		return d.label(in.operands[1])
+
+	case code.OpAddLocalConstant:
+		return d.value(in.operands[1])
+
+	case code.OpCompareLocalJump:
+		return opName(code.Opcode(in.operands[2])) + " " + d.value(in.operands[1])
+	}
+
+	return ""
+}
+
+func (d *disassembler) constant(index int) object.Object {
+	if index < 0 || index >= len(d.constants) {
+		return nil
+	}
+	return d.constants[index]
+}
+
+// value shows a constant the way it is written in a program.
+func (d *disassembler) value(index int) string {
+	switch constant := d.constant(index).(type) {
+	case nil:
+		return "?"
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return d.label(index)
+	default:
+		return constant.Inspect()
+	}
+}
+
+// label names the function at index in the constant pool.
+func (d *disassembler) label(index int) string {
+	fn, ok := d.constant(index).(*object.CompiledFunction)
+	if !ok {
+		return "?"
+	}
+	if fn.Name == "" {
+		return fmt.Sprintf("function %d", index)
+	}
+	return fmt.Sprintf("function %d (%s)", index, fn.Name)
+}
+
+func count(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
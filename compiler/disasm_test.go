@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := "fct soma(a, b) {\n  var c << a + b;\n  fct() { c + \"!\" }\n}\nsoma(1, 2)\n"
+
+	compiler := New()
+	compiler.SetFile("d.zum")
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== main program ==
+     ; d.zum:1:1
+0000 OpClosure 2 0 ; function 2 (soma)
+0004 OpSetGlobal 0
+     ; d.zum:5:1
+0007 OpGetGlobal 0
+0010 OpConstant 3 ; 1
+0013 OpConstant 4 ; 2
+0016 OpCall 2
+0018 OpPop
+
+== function 2 (soma): 2 parameters, 3 locals, in main program ==
+     ; d.zum:2:12
+0000 OpGetLocal 0
+0002 OpGetLocal 1
+0004 OpAdd
+0005 OpSetLocal 2
+     ; d.zum:3:3
+0007 OpGetLocalCell 2
+0009 OpClosure 1 1 ; function 1
+0013 OpReturnValue
+
+== function 1: 0 parameters, 0 locals, in function 2 (soma) ==
+     ; d.zum:3:11
+0000 OpGetFree 0
+0002 OpConstant 0 ; "!"
+0005 OpAdd
+0006 OpReturnValue
+`
+	if got := Disassemble(compiler.Bytecode()); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%s\ngot=%s", expected, got)
+	}
+}
+
+func TestDisassembleBadBytecode(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			{255},
+			code.Make(code.OpConstant, 7),
+			code.Make(code.OpGetBuiltin, 255),
+			code.Make(code.OpConstant, 0)[:2],
+		}),
+		Handlers: []object.ExceptionHandler{{Start: 0, End: 1, Target: 4}},
+		Constants: []object.Object{
+			&object.CompiledFunction{Name: "unused", Instructions: code.Make(code.OpReturn)},
+		},
+	}
+
+	expected := `== main program ==
+0000 ERROR: unknown opcode 255
+0001 OpConstant 7 ; ?
+0004 OpGetBuiltin 255 ; ?
+0006 ERROR: OpConstant is cut short
+handler 0000-0001 -> 0004
+
+== function 0 (unused): 0 parameters, 0 locals ==
+0000 OpReturn
+`
+	if got := Disassemble(bytecode); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%s\ngot=%s", expected, got)
+	}
+
+	if !strings.Contains(Disassemble(&Bytecode{Instructions: code.Instructions{255, 255}}), "0001 ERROR") {
+		t.Errorf("every unknown opcode should be reported")
+	}
+}
@@ -0,0 +1,133 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"zumbra/ast"
+)
+
+// checkEnumExhaustive warns when an if/else chain compares the same subject
+// against members of an enum without covering all of them and without a
+// final else branch.
+func (c *Compiler) checkEnumExhaustive(ie *ast.IfExpression) {
+	var subject, enumName string
+	covered := map[string]bool{}
+	branches := 0
+
+	for current := ie; current != nil; {
+		subj, name, members, ok := c.enumComparison(current.Condition)
+		if !ok {
+			return
+		}
+
+		if subject == "" {
+			subject, enumName = subj, name
+		} else if subj != subject || name != enumName {
+			return
+		}
+
+		for _, m := range members {
+			covered[m] = true
+		}
+		branches++
+
+		if current.Alternative == nil {
+			break
+		}
+
+		next := chainedIf(current.Alternative)
+		if next == nil {
+			return
+		}
+
+		c.chainedIfs[next] = true
+		current = next
+	}
+
+	if branches < 2 {
+		return
+	}
+
+	missing := []string{}
+	for _, m := range c.enums[enumName].Members {
+		if !covered[m.Name] {
+			missing = append(missing, enumName+"."+m.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		c.warnings = append(c.warnings, fmt.Sprintf(
+			"non-exhaustive match on %s: missing %s", subject, strings.Join(missing, ", ")))
+	}
+}
+
+// enumComparison recognises `subject == Enum.Member`, in either order, and
+// `or` combinations of such comparisons over the same subject.
+func (c *Compiler) enumComparison(exp ast.Expression) (string, string, []string, bool) {
+	infix, ok := exp.(*ast.InfixExpression)
+	if !ok {
+		return "", "", nil, false
+	}
+
+	switch infix.Operator {
+	case "or":
+		lSubject, lEnum, lMembers, ok := c.enumComparison(infix.Left)
+		if !ok {
+			return "", "", nil, false
+		}
+		rSubject, rEnum, rMembers, ok := c.enumComparison(infix.Right)
+		if !ok || lSubject != rSubject || lEnum != rEnum {
+			return "", "", nil, false
+		}
+		return lSubject, lEnum, append(lMembers, rMembers...), true
+
+	case "==":
+		if name, member, ok := c.enumMember(infix.Right); ok {
+			return infix.Left.String(), name, []string{member}, true
+		}
+		if name, member, ok := c.enumMember(infix.Left); ok {
+			return infix.Right.String(), name, []string{member}, true
+		}
+	}
+
+	return "", "", nil, false
+}
+
+func (c *Compiler) enumMember(exp ast.Expression) (string, string, bool) {
+	access, ok := exp.(*ast.AttributeAccess)
+	if !ok {
+		return "", "", false
+	}
+
+	ident, ok := access.Object.(*ast.Identifier)
+	if !ok {
+		return "", "", false
+	}
+
+	enum, ok := c.enums[ident.Value]
+	if !ok {
+		return "", "", false
+	}
+
+	if _, ok := enum.Member(access.Property.Value); !ok {
+		return "", "", false
+	}
+
+	return enum.Name, access.Property.Value, true
+}
+
+// chainedIf returns the if expression when an else block holds nothing but
+// another if, which is how `else if` is written in Zumbra.
+func chainedIf(block *ast.BlockStatement) *ast.IfExpression {
+	if len(block.Statements) != 1 {
+		return nil
+	}
+
+	stmt, ok := block.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil
+	}
+
+	ie, _ := stmt.Expression.(*ast.IfExpression)
+	return ie
+}
@@ -0,0 +1,113 @@
+package compiler
+
+import (
+	"fmt"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+// StackSize is the number of values the VM's stack holds. The locals of a
+// function, with the callee below them, must fit in it.
+const StackSize = 2048
+
+// checkLocals keeps the error for a function with more locals than fit on
+// the VM's stack, which would overflow it on every call. Each declaration
+// counts, even of a name declared before.
+func (c *Compiler) checkLocals(numLocals int) {
+	if max := StackSize - 2; numLocals > max && c.err == nil {
+		c.err = fmt.Errorf("too many local variables in a function, the limit is %d", max)
+	}
+}
+
+// fit returns op, or its wide variant when the operands do not fit op. When
+// neither holds them the program is over a limit of the VM, and the error
+// is kept to be returned by Compile.
+func (c *Compiler) fit(op code.Opcode, operands []int) code.Opcode {
+	if code.Fits(op, operands...) {
+		return op
+	}
+
+	if wide, ok := code.Widen(op); ok && code.Fits(wide, operands...) {
+		return wide
+	}
+
+	if c.err == nil {
+		c.err = limitError(op, operands)
+	}
+	return op
+}
+
+func limitError(op code.Opcode, operands []int) error {
+	widest := op
+	if wide, ok := code.Widen(op); ok {
+		widest = wide
+	}
+	def, _ := code.Lookup(byte(widest))
+	max := code.MaxOperand(def.OperandWidths[0])
+
+	switch op {
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return fmt.Errorf("too many global variables, the limit is %d", max+1)
+	case code.OpGetLocal, code.OpSetLocal, code.OpAssignLocal, code.OpGetLocalCell:
+		return fmt.Errorf("too many local variables in a function, the limit is %d", max+1)
+	case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell:
+		return fmt.Errorf("too many free variables in a function, the limit is %d", max+1)
+	case code.OpClosure:
+		return fmt.Errorf("a function captures too many variables, the limit is %d", code.MaxOperand(def.OperandWidths[1]))
+	case code.OpGetBuiltin:
+		return fmt.Errorf("too many builtins, the limit is %d", max+1)
+	case code.OpCall:
+		return fmt.Errorf("too many arguments in a call, the limit is %d", max)
+	case code.OpArray:
+		return fmt.Errorf("too many elements in an array literal, the limit is %d", max)
+	case code.OpDict:
+		return fmt.Errorf("too many pairs in a dict literal, the limit is %d", max/2)
+	}
+
+	return fmt.Errorf("operands %v do not fit in %s", operands, def.Name)
+}
+
+// farJump records the target of the jump at pos when it is too far for the
+// jump's operand. The scope's jumps are widened once it is complete, since
+// widening moves the code after them.
+func (c *Compiler) farJump(pos, target int) {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.farJumps == nil {
+		scope.farJumps = map[int]int{}
+	}
+	scope.farJumps[pos] = target
+}
+
+// widenJumps turns every jump of the current scope into its wide variant
+// when one of them is too far for its operand, moving jump targets and
+// handlers to the new positions.
+func (c *Compiler) widenJumps() {
+	scope := &c.scopes[c.scopeIndex]
+	if len(scope.farJumps) == 0 {
+		return
+	}
+
+	p := &peephole{moved: map[int]int{}}
+	for _, in := range decode(scope.instructions) {
+		if t, ok := target(in.op); ok {
+			if far, ok := scope.farJumps[in.pos]; ok {
+				in.operands[t] = far
+			}
+			if wide, ok := code.Widen(in.op); ok {
+				in.op = wide
+			}
+		}
+		p.emit(in)
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions: scope.instructions,
+		Handlers:     scope.handlers,
+		Positions:    scope.positions,
+	}
+	p.encode(fn)
+	scope.instructions, scope.handlers, scope.positions = fn.Instructions, fn.Handlers, fn.Positions
+	scope.farJumps = nil
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+}
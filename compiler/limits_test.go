@@ -0,0 +1,194 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+// repeat joins the n strings f returns for 0 to n-1.
+func repeat(n int, sep string, f func(i int) string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = f(i)
+	}
+	return strings.Join(parts, sep)
+}
+
+// name returns a variable name made of letters, as names cannot hold
+// digits. The underscore keeps it from spelling a keyword.
+func name(i int) string {
+	letters := ""
+	for ; i >= 0; i = i/26 - 1 {
+		letters = string(rune('a'+i%26)) + letters
+	}
+	return "v_" + letters
+}
+
+func declare(i int) string {
+	return "var " + name(i) + " << 1;"
+}
+
+func compileLarge(t *testing.T, input string) *Bytecode {
+	t.Helper()
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+// opcodes lists the opcodes of ins in order.
+func opcodes(ins code.Instructions) []code.Opcode {
+	ops := []code.Opcode{}
+	for _, in := range decode(ins) {
+		ops = append(ops, in.op)
+	}
+	return ops
+}
+
+func TestWideLocals(t *testing.T) {
+	input := "fct() { " + repeat(300, " ", declare) + " " + name(299) + " }"
+	bytecode := compileLarge(t, input)
+
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	ins := fn.Instructions
+
+	last := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetLocalWide, 299),
+		code.Make(code.OpGetLocalWide, 299),
+		code.Make(code.OpReturnValue),
+	})
+	if got := ins[len(ins)-len(last):]; got.String() != last.String() {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", last, got)
+	}
+
+	if !strings.HasPrefix(ins.String(), "0000 OpConstant 0\n0003 OpSetLocal 0\n") {
+		t.Errorf("the first locals should stay narrow.\ngot=%s", ins[:8])
+	}
+}
+
+func TestWideCall(t *testing.T) {
+	input := "var f << fct() { 1 }; f(" + repeat(256, ", ", strconv.Itoa) + ")"
+	bytecode := compileLarge(t, input)
+
+	ops := opcodes(bytecode.Instructions)
+	if call := ops[len(ops)-2]; call != code.OpCallWide {
+		t.Errorf("wrong call opcode. want=%d, got=%d", code.OpCallWide, call)
+	}
+}
+
+func TestWideConstants(t *testing.T) {
+	bytecode := compileLarge(t, repeat(65537, ";", strconv.Itoa)+";")
+
+	if len(bytecode.Constants) != 65537 {
+		t.Fatalf("wrong number of constants. got=%d", len(bytecode.Constants))
+	}
+
+	ins := bytecode.Instructions
+	last := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstantWide, 65536),
+		code.Make(code.OpPop),
+	})
+	if got := ins[len(ins)-len(last):]; got.String() != last.String() {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", last, got)
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	// Each statement of the body takes 4 bytes, so the jumps around it go
+	// past 65535.
+	body := strings.Repeat("x; ", 17000)
+	input := "var x << 1; while (x) { " + body + " x << false; } if (x) { " + body + " } else { 2 }"
+	bytecode := compileLarge(t, input)
+
+	instructions := decode(bytecode.Instructions)
+	at := map[int]instruction{}
+	for _, in := range instructions {
+		at[in.pos] = in
+	}
+
+	jumps := 0
+	for _, in := range instructions {
+		switch in.op {
+		case code.OpJump, code.OpJumpNotTruthy:
+			t.Errorf("narrow jump left at %d", in.pos)
+		case code.OpJumpWide, code.OpJumpNotTruthyWide:
+			jumps++
+			if _, ok := at[in.operands[0]]; !ok && in.operands[0] != len(bytecode.Instructions) {
+				t.Errorf("jump at %d lands inside an instruction, at %d", in.pos, in.operands[0])
+			}
+		}
+	}
+	if jumps != 4 {
+		t.Errorf("wrong number of wide jumps. want=4, got=%d", jumps)
+	}
+
+	// The loop jumps back to its condition, which follows OpSetGlobal.
+	loopEnd := instructions[0]
+	for _, in := range instructions {
+		if in.op == code.OpJumpWide {
+			loopEnd = in
+			break
+		}
+	}
+	if loopEnd.operands[0] != 6 {
+		t.Errorf("loop jumps back to %d, want=6", loopEnd.operands[0])
+	}
+}
+
+func TestWideJumpsMoveHandlers(t *testing.T) {
+	input := "fct() { try { throw 1 } catch (e) { " + strings.Repeat("e; ", 25000) + " } }"
+	bytecode := compileLarge(t, input)
+
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	expected := []object.ExceptionHandler{{Start: 0, End: 4, Target: 9}}
+	if fmt.Sprint(fn.Handlers) != fmt.Sprint(expected) {
+		t.Errorf("wrong handlers. want=%+v, got=%+v", expected, fn.Handlers)
+	}
+
+	if op := code.Opcode(fn.Instructions[4]); op != code.OpJumpWide {
+		t.Errorf("wrong opcode after the try body. want=%d, got=%d", code.OpJumpWide, op)
+	}
+}
+
+func TestLimitErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			repeat(65537, " ", declare),
+			"too many global variables, the limit is 65536",
+		},
+		{
+			"fct() { " + repeat(65537, " ", declare) + " }",
+			"too many local variables in a function, the limit is 65536",
+		},
+		{
+			"fct() { " + repeat(2100, " ", declare) + " }",
+			"too many local variables in a function, the limit is 2046",
+		},
+		{
+			"fct(a, b) { " + strings.Repeat("var x << 1; ", 2045) + " }",
+			"too many local variables in a function, the limit is 2046",
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected an error for %.40q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
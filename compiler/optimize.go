@@ -0,0 +1,380 @@
+package compiler
+
+import (
+	"fmt"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+// Optimization selects the rewrites done by Optimize.
+type Optimization int
+
+const (
+	// ThreadJumps sends jumps that land on another jump straight to its
+	// target, and drops jumps to the next instruction.
+	ThreadJumps Optimization = 1 << iota
+	// DropPushPop removes values pushed only to be popped.
+	DropPushPop
+	// FuseAddLocal turns `x << x + k` on a local into OpAddLocalConstant.
+	FuseAddLocal
+	// FuseCompareJump turns the comparison of a local with a constant
+	// followed by a conditional jump into OpCompareLocalJump.
+	FuseCompareJump
+	// FuseCallGlobal turns the call of a function held in a global into
+	// OpCallGlobal.
+	FuseCallGlobal
+
+	NoOptimizations  Optimization = 0
+	AllOptimizations              = ThreadJumps | DropPushPop | FuseAddLocal | FuseCompareJump | FuseCallGlobal
+)
+
+// Optimize rewrites the instructions of the program and of the functions
+// in its constant pool with the enabled peephole optimizations. It runs
+// on compiled bytecode, and running it again on its own output is
+// harmless, so the REPL can optimize its growing constant pool each time.
+func Optimize(bytecode *Bytecode, enabled Optimization) {
+	// The REPL shows the last value the program pops, so the main program
+	// keeps its pops.
+	main := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		Positions:    bytecode.Positions,
+	}
+	optimize(main, enabled&^DropPushPop)
+	bytecode.Instructions, bytecode.Handlers, bytecode.Positions = main.Instructions, main.Handlers, main.Positions
+
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			optimize(fn, enabled)
+		}
+	}
+}
+
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	pos      int
+}
+
+// target returns the index of the operand of op holding a jump target.
+func target(op code.Opcode) (int, bool) {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext, code.OpWhile,
+		code.OpJumpWide, code.OpJumpNotTruthyWide, code.OpIterNextWide:
+		return 0, true
+	case code.OpCompareLocalJump:
+		return 3, true
+	}
+	return 0, false
+}
+
+func decode(ins code.Instructions) []instruction {
+	decoded := []instruction{}
+
+	for pos := 0; pos < len(ins); {
+		in, size, err := readInstruction(ins, pos)
+		if err != nil {
+			// Leave bytecode the optimizer does not understand alone.
+			return nil
+		}
+
+		decoded = append(decoded, in)
+		pos += size
+	}
+
+	return decoded
+}
+
+// readInstruction reads the instruction at pos and returns it with its
+// size in bytes.
+func readInstruction(ins code.Instructions, pos int) (instruction, int, error) {
+	def, err := code.Lookup(ins[pos])
+	if err != nil {
+		return instruction{}, 0, fmt.Errorf("unknown opcode %d", ins[pos])
+	}
+
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	if pos+1+width > len(ins) {
+		return instruction{}, 0, fmt.Errorf("%s is cut short", def.Name)
+	}
+
+	operands, read := code.ReadOperands(def, ins[pos+1:])
+	return instruction{code.Opcode(ins[pos]), operands, pos}, 1 + read, nil
+}
+
+// optimize rewrites the instructions of fn, moving its handlers and line
+// table along.
+func optimize(fn *object.CompiledFunction, enabled Optimization) {
+	instructions := decode(fn.Instructions)
+	if instructions == nil || enabled == NoOptimizations {
+		return
+	}
+
+	at := map[int]int{}
+	for i, in := range instructions {
+		at[in.pos] = i
+	}
+
+	if enabled&ThreadJumps != 0 {
+		threadJumps(instructions, at)
+	}
+
+	// Instructions other code jumps to cannot be fused with the ones
+	// before them.
+	labels := map[int]bool{}
+	for _, in := range instructions {
+		if t, ok := target(in.op); ok {
+			labels[in.operands[t]] = true
+		}
+	}
+	for _, h := range fn.Handlers {
+		labels[h.Start], labels[h.End], labels[h.Target] = true, true, true
+	}
+
+	p := &peephole{instructions: instructions, labels: labels, enabled: enabled, moved: map[int]int{}, calls: map[int]int{}}
+	p.run()
+	p.encode(fn)
+}
+
+// threadJumps points jumps landing on an unconditional jump at its final
+// target.
+func threadJumps(instructions []instruction, at map[int]int) {
+	for i := range instructions {
+		t, ok := target(instructions[i].op)
+		if !ok {
+			continue
+		}
+
+		dest := instructions[i].operands[t]
+		for hops := 0; hops < len(instructions); hops++ {
+			j, ok := at[dest]
+			if !ok || instructions[j].op != code.OpJump || j == i {
+				break
+			}
+			dest = instructions[j].operands[0]
+		}
+		instructions[i].operands[t] = dest
+	}
+}
+
+// peephole rewrites instructions into out. moved maps the position of
+// each instruction read to the index in out of the instruction that
+// replaces it, or of the next one when it is dropped.
+type peephole struct {
+	instructions []instruction
+	labels       map[int]bool
+	enabled      Optimization
+	out          []instruction
+	moved        map[int]int
+	// calls maps the index of an OpCall to the global holding its callee.
+	calls map[int]int
+}
+
+func (p *peephole) run() {
+	for i := 0; i < len(p.instructions); {
+		i += p.rewrite(i)
+	}
+}
+
+// rewrite handles the instructions starting at i and returns how many it
+// consumed.
+func (p *peephole) rewrite(i int) int {
+	in := p.instructions[i]
+
+	if global, ok := p.calls[i]; ok {
+		p.emit(instruction{code.OpCallGlobal, []int{global, in.operands[0]}, in.pos})
+		return 1
+	}
+
+	if p.enabled&ThreadJumps != 0 && in.op == code.OpJump && i+1 < len(p.instructions) &&
+		in.operands[0] == p.instructions[i+1].pos {
+		p.drop(i)
+		return 1
+	}
+
+	if p.enabled&DropPushPop != 0 && p.matches(i, 2) && pure(in.op) && p.instructions[i+1].op == code.OpPop {
+		p.drop(i)
+		p.drop(i + 1)
+		return 2
+	}
+
+	if p.enabled&FuseAddLocal != 0 && p.matches(i, 4) {
+		get, constant, add, set := in, p.instructions[i+1], p.instructions[i+2], p.instructions[i+3]
+		if get.op == code.OpGetLocal && constant.op == code.OpConstant && add.op == code.OpAdd &&
+			set.op == code.OpAssignLocal && set.operands[0] == get.operands[0] {
+			p.fuse(i, 4, code.OpAddLocalConstant, get.operands[0], constant.operands[0])
+			return 4
+		}
+	}
+
+	if p.enabled&FuseCompareJump != 0 && p.matches(i, 4) {
+		get, constant, compare, jump := in, p.instructions[i+1], p.instructions[i+2], p.instructions[i+3]
+		if get.op == code.OpGetLocal && constant.op == code.OpConstant && comparison(compare.op) &&
+			jump.op == code.OpJumpNotTruthy {
+			p.fuse(i, 4, code.OpCompareLocalJump, get.operands[0], constant.operands[0], int(compare.op), jump.operands[0])
+			return 4
+		}
+	}
+
+	if p.enabled&FuseCallGlobal != 0 && in.op == code.OpGetGlobal {
+		if call, ok := p.callOf(i); ok {
+			p.calls[call] = in.operands[0]
+			p.drop(i)
+			return 1
+		}
+	}
+
+	p.emit(in)
+	return 1
+}
+
+// matches reports whether the n instructions from i exist and nothing
+// jumps between them.
+func (p *peephole) matches(i, n int) bool {
+	if i+n > len(p.instructions) {
+		return false
+	}
+	for _, in := range p.instructions[i+1 : i+n] {
+		if p.labels[in.pos] {
+			return false
+		}
+	}
+	return true
+}
+
+// callOf finds the OpCall whose callee the OpGetGlobal at i pushes, when
+// only side-effect free instructions compute the arguments in between, so
+// that loading the callee at the call is the same as loading it first.
+func (p *peephole) callOf(i int) (int, bool) {
+	depth := 0
+
+	for j := i + 1; j < len(p.instructions); j++ {
+		in := p.instructions[j]
+		if p.labels[in.pos] {
+			return 0, false
+		}
+
+		if in.op == code.OpCall {
+			return j, in.operands[0] == depth
+		}
+
+		pops, pushes, ok := stackEffect(in)
+		if !ok || pops > depth {
+			return 0, false
+		}
+		depth += pushes - pops
+	}
+
+	return 0, false
+}
+
+func (p *peephole) emit(in instruction) {
+	p.moved[in.pos] = len(p.out)
+	p.out = append(p.out, in)
+}
+
+func (p *peephole) drop(i int) {
+	p.moved[p.instructions[i].pos] = len(p.out)
+}
+
+func (p *peephole) fuse(i, n int, op code.Opcode, operands ...int) {
+	for _, in := range p.instructions[i : i+n] {
+		p.moved[in.pos] = len(p.out)
+	}
+	p.out = append(p.out, instruction{op, operands, p.instructions[i].pos})
+}
+
+// encode replaces the instructions of fn with the rewritten ones, moving
+// jump targets, handlers and the line table to the new positions.
+func (p *peephole) encode(fn *object.CompiledFunction) {
+	end := len(fn.Instructions)
+	positions := make([]int, len(p.out)+1)
+	for i, in := range p.out {
+		positions[i+1] = positions[i] + len(code.Make(in.op, in.operands...))
+	}
+
+	newPos := func(old int) int {
+		if old >= end {
+			return positions[len(p.out)]
+		}
+		return positions[p.moved[old]]
+	}
+
+	ins := code.Instructions{}
+	for _, in := range p.out {
+		operands := append([]int{}, in.operands...)
+		if t, ok := target(in.op); ok {
+			operands[t] = newPos(operands[t])
+		}
+		ins = append(ins, code.Make(in.op, operands...)...)
+	}
+
+	var moved []object.ExceptionHandler
+	for _, h := range fn.Handlers {
+		moved = append(moved, object.ExceptionHandler{
+			Start:  newPos(h.Start),
+			End:    newPos(h.End),
+			Target: newPos(h.Target),
+		})
+	}
+
+	// The entries of dropped instructions fall on the instruction after
+	// them, whose own entry wins.
+	var lines []object.SourcePosition
+	for _, entry := range fn.Positions {
+		entry.Offset = newPos(entry.Offset)
+		if entry.Offset >= len(ins) {
+			break
+		}
+		if n := len(lines); n > 0 && lines[n-1].Offset == entry.Offset {
+			lines = lines[:n-1]
+		}
+		lines = append(lines, entry)
+	}
+
+	fn.Instructions, fn.Handlers, fn.Positions = ins, moved, lines
+}
+
+// pure reports whether op only pushes a value.
+func pure(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetLocal,
+		code.OpGetGlobal, code.OpGetFree, code.OpGetBuiltin, code.OpCurrentClosure:
+		return true
+	}
+	return false
+}
+
+func comparison(op code.Opcode) bool {
+	switch op {
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+		code.OpGreaterThanOrEqual, code.OpLessThanOrEqual:
+		return true
+	}
+	return false
+}
+
+// stackEffect returns how many values in pops and pushes, for the
+// instructions that cannot run other code or change variables.
+func stackEffect(in instruction) (pops, pushes int, ok bool) {
+	switch {
+	case pure(in.op):
+		return 0, 1, true
+	case comparison(in.op):
+		return 2, 1, true
+	}
+
+	switch in.op {
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpAnd, code.OpOr, code.OpIndex, code.OpGetAttr:
+		return 2, 1, true
+	case code.OpMinus, code.OpBang:
+		return 1, 1, true
+	case code.OpArray, code.OpDict:
+		return in.operands[0], 1, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,233 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+func runOptimizerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		Optimize(bytecode, AllOptimizations)
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func TestThreadJumps(t *testing.T) {
+	bytecode := &Bytecode{Instructions: concatInstructions([]code.Instructions{
+		code.Make(code.OpTrue),             // 0000
+		code.Make(code.OpJumpNotTruthy, 8), // 0001
+		code.Make(code.OpNull),             // 0004
+		code.Make(code.OpJump, 8),          // 0005
+		code.Make(code.OpJump, 12),         // 0008
+		code.Make(code.OpNull),             // 0011
+		code.Make(code.OpPop),              // 0012
+		code.Make(code.OpJump, 16),         // 0013
+		code.Make(code.OpNull),             // 0016
+	})}
+	Optimize(bytecode, ThreadJumps)
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 12),
+		code.Make(code.OpNull),
+		code.Make(code.OpJump, 12),
+		code.Make(code.OpJump, 12),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+	}
+	if err := testInstructions(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestDropPushPop(t *testing.T) {
+	runOptimizerTests(t, []compilerTestCase{
+		{
+			// The main program keeps its pops for the REPL.
+			input: "1; fct() { 1; true; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	})
+}
+
+func TestSuperinstructions(t *testing.T) {
+	runOptimizerTests(t, []compilerTestCase{
+		{
+			input: "fct(x) { x << x + 1; x }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpAddLocalConstant, 0, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fct(x) { if (x < 10) { 1 } else { 2 } }",
+			expectedConstants: []interface{}{
+				10,
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpCompareLocalJump, 0, 0, int(code.OpLessThan), 13),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "var g << fct(x) { x }; fct(x) { g(x - 1) + 1 }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSub),
+					code.Make(code.OpCallGlobal, 0, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The outer callee must be loaded before the inner call runs,
+			// and the outer call stays a tail call.
+			input: "var g << fct(x) { x }; fct(x) { g(g(x)) }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCallGlobal, 0, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The global read by the attribute access is not the callee.
+			input:             `var d << {}; d.f(1)`,
+			expectedConstants: []interface{}{"f", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpDict, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetAttr),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	})
+}
+
+func TestOptimizeMovesHandlers(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("fct(x) { try { x << x + 1; x } catch (e) { 0 } }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	Optimize(bytecode, AllOptimizations)
+
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	err = testInstructions([]code.Instructions{
+		// 0000
+		code.Make(code.OpAddLocalConstant, 0, 0),
+		// 0004
+		code.Make(code.OpJump, 9),
+		// 0007
+		code.Make(code.OpSetLocal, 1),
+		// 0009
+		code.Make(code.OpReturn),
+	}, fn.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	expected := []object.ExceptionHandler{{Start: 0, End: 4, Target: 7}}
+	if !reflect.DeepEqual(fn.Handlers, expected) {
+		t.Errorf("wrong handlers. want=%+v, got=%+v", expected, fn.Handlers)
+	}
+}
+
+func TestOptimizeTwice(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("var g << fct(x) { x }; fct(x) { while (x > 0) { x << x + -1; g(x); } }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	Optimize(bytecode, AllOptimizations)
+
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	once := fn.Instructions.String()
+
+	Optimize(bytecode, AllOptimizations)
+	if twice := fn.Instructions.String(); twice != once {
+		t.Errorf("optimizing twice changed the code.\nonce:\n%s\ntwice:\n%s", once, twice)
+	}
+}
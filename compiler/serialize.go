@@ -0,0 +1,437 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"zumbra/code"
+	"zumbra/object"
+	"zumbra/object/builtins"
+)
+
+// Magic starts every file written by Encode.
+const Magic = "ZUMC"
+
+// FormatVersion is the version of the encoding written by Encode. It is
+// raised whenever the encoding or the meaning of the instructions changes,
+// so that files built by another version are rejected instead of run.
+const FormatVersion = 5
+
+// The tags that precede each encoded object.
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagBoolean
+	tagNull
+	tagArray
+	tagDict
+	tagEnum
+	tagEnumValue
+	tagCompiledFunction
+)
+
+// Encode writes the bytecode in the format read by Decode:
+//
+//	magic, version, builtin names, instructions, handlers, line table,
+//	constants
+//
+// The builtin names are kept since OpGetBuiltin refers to builtins by
+// their position. Closures, builtins and the other objects that only
+// exist while a program runs cannot be encoded.
+func (b *Bytecode) Encode(w io.Writer) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+
+	e.w.WriteString(Magic)
+	e.uint(FormatVersion)
+
+	e.uint(uint64(len(builtins.Builtins)))
+	for _, def := range builtins.Builtins {
+		e.string(def.Name)
+	}
+
+	e.bytes(b.Instructions)
+	e.handlers(b.Handlers)
+	e.positions(b.Positions)
+
+	e.uint(uint64(len(b.Constants)))
+	for _, constant := range b.Constants {
+		e.object(constant)
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) uint(v uint64) {
+	e.w.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) int(v int64) {
+	e.w.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.w.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.w.WriteByte(1)
+	} else {
+		e.w.WriteByte(0)
+	}
+}
+
+func (e *encoder) handlers(handlers []object.ExceptionHandler) {
+	e.uint(uint64(len(handlers)))
+	for _, h := range handlers {
+		e.uint(uint64(h.Start))
+		e.uint(uint64(h.End))
+		e.uint(uint64(h.Target))
+	}
+}
+
+func (e *encoder) positions(positions []object.SourcePosition) {
+	e.uint(uint64(len(positions)))
+	for _, p := range positions {
+		e.uint(uint64(p.Offset))
+		e.string(p.File)
+		e.uint(uint64(p.Line))
+		e.uint(uint64(p.Column))
+	}
+}
+
+func (e *encoder) object(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.w.WriteByte(tagInteger)
+		e.int(obj.Value)
+
+	case *object.Float:
+		e.w.WriteByte(tagFloat)
+		e.uint(math.Float64bits(obj.Value))
+
+	case *object.String:
+		e.w.WriteByte(tagString)
+		e.string(obj.Value)
+
+	case *object.Boolean:
+		e.w.WriteByte(tagBoolean)
+		e.bool(obj.Value)
+
+	case *object.Null:
+		e.w.WriteByte(tagNull)
+
+	case *object.Array:
+		e.w.WriteByte(tagArray)
+		e.uint(uint64(len(obj.Elements)))
+		for _, el := range obj.Elements {
+			e.object(el)
+		}
+
+	case *object.Dict:
+		// Sorted, so that building the same program gives the same file.
+		pairs := []object.DictPair{}
+		for _, pair := range obj.Pairs {
+			pairs = append(pairs, pair)
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+		})
+
+		e.w.WriteByte(tagDict)
+		e.uint(uint64(len(pairs)))
+		for _, pair := range pairs {
+			e.object(pair.Key)
+			e.object(pair.Value)
+		}
+
+	case *object.Enum:
+		e.w.WriteByte(tagEnum)
+		e.string(obj.Name)
+		e.uint(uint64(len(obj.Members)))
+		for _, m := range obj.Members {
+			e.string(m.Name)
+		}
+
+	case *object.EnumValue:
+		e.w.WriteByte(tagEnumValue)
+		e.string(obj.Enum)
+		e.string(obj.Name)
+		e.uint(uint64(obj.Ordinal))
+
+	case *object.CompiledFunction:
+		e.w.WriteByte(tagCompiledFunction)
+		e.string(obj.Name)
+		e.bytes(obj.Instructions)
+		e.uint(uint64(obj.NumLocals))
+		e.uint(uint64(obj.NumParameters))
+		e.handlers(obj.Handlers)
+		e.bool(obj.IsGenerator)
+		e.positions(obj.Positions)
+
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot encode a constant of type %s", obj.Type())
+		}
+	}
+}
+
+// Decode reads bytecode written by Encode. It fails on files that are not
+// bytecode, were written by another version of the format or for another
+// set of builtins, or are cut short.
+func Decode(r io.Reader) (*Bytecode, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("not a zumbra bytecode file")
+	}
+
+	version := d.uint()
+	if d.err == nil && version != FormatVersion {
+		return nil, fmt.Errorf("bytecode format version %d is not supported, this zumbra reads version %d; build the file again", version, FormatVersion)
+	}
+
+	numBuiltins := d.count()
+	for i := 0; i < numBuiltins && d.err == nil; i++ {
+		name := d.string()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if i >= len(builtins.Builtins) || builtins.Builtins[i].Name != name {
+			return nil, fmt.Errorf("bytecode was built with other builtins (%s at %d); build the file again", name, i)
+		}
+	}
+	if d.err == nil && numBuiltins != len(builtins.Builtins) {
+		return nil, fmt.Errorf("bytecode was built with %d builtins, this zumbra has %d; build the file again", numBuiltins, len(builtins.Builtins))
+	}
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions = d.bytes()
+	bytecode.Handlers = d.handlers()
+	bytecode.Positions = d.positions()
+
+	numConstants := d.count()
+	bytecode.Constants = []object.Object{}
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.object(0))
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bytecode, nil
+}
+
+// maxDepth bounds how deeply arrays and dicts nest in a file, so a damaged
+// file cannot exhaust the Go stack.
+const maxDepth = 1000
+
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = fmt.Errorf("damaged bytecode file: %w", err)
+	}
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+// count reads a length or a position. Nothing is allocated up front from
+// a length, so a damaged one fails when the file runs out instead.
+func (d *decoder) count() int {
+	v := d.uint()
+	if v > math.MaxInt32 {
+		d.fail(fmt.Errorf("length %d out of range", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		d.fail(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return b == 1
+}
+
+func (d *decoder) handlers() []object.ExceptionHandler {
+	n := d.count()
+
+	var handlers []object.ExceptionHandler
+	for i := 0; i < n && d.err == nil; i++ {
+		handlers = append(handlers, object.ExceptionHandler{
+			Start:  d.count(),
+			End:    d.count(),
+			Target: d.count(),
+		})
+	}
+	return handlers
+}
+
+func (d *decoder) positions() []object.SourcePosition {
+	n := d.count()
+
+	var positions []object.SourcePosition
+	for i := 0; i < n && d.err == nil; i++ {
+		positions = append(positions, object.SourcePosition{
+			Offset: d.count(),
+			File:   d.string(),
+			Line:   d.count(),
+			Column: d.count(),
+		})
+	}
+	return positions
+}
+
+func (d *decoder) object(depth int) object.Object {
+	if d.err != nil {
+		return nil
+	}
+	if depth > maxDepth {
+		d.fail(errors.New("constants nested too deeply"))
+		return nil
+	}
+
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+		return nil
+	}
+
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.int()}
+
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint())}
+
+	case tagString:
+		return &object.String{Value: d.string()}
+
+	case tagBoolean:
+		return &object.Boolean{Value: d.bool()}
+
+	case tagNull:
+		return &object.Null{}
+
+	case tagArray:
+		n := d.count()
+		elements := []object.Object{}
+		for i := 0; i < n && d.err == nil; i++ {
+			elements = append(elements, d.object(depth+1))
+		}
+		return &object.Array{Elements: elements}
+
+	case tagDict:
+		n := d.count()
+		pairs := map[object.DictKey]object.DictPair{}
+		for i := 0; i < n && d.err == nil; i++ {
+			key, value := d.object(depth+1), d.object(depth+1)
+			if d.err != nil {
+				break
+			}
+			dictable, ok := key.(object.Dictable)
+			if !ok {
+				d.fail(fmt.Errorf("unusable as dict key: %s", key.Type()))
+				break
+			}
+			pairs[dictable.DictKey()] = object.DictPair{Key: key, Value: value}
+		}
+		return &object.Dict{Pairs: pairs}
+
+	case tagEnum:
+		name := d.string()
+		n := d.count()
+		members := []string{}
+		for i := 0; i < n && d.err == nil; i++ {
+			members = append(members, d.string())
+		}
+		return object.NewEnum(name, members)
+
+	case tagEnumValue:
+		return &object.EnumValue{Enum: d.string(), Name: d.string(), Ordinal: d.count()}
+
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.Name = d.string()
+		fn.Instructions = code.Instructions(d.bytes())
+		fn.NumLocals = d.count()
+		fn.NumParameters = d.count()
+		fn.Handlers = d.handlers()
+		fn.IsGenerator = d.bool()
+		fn.Positions = d.positions()
+		return fn
+	}
+
+	d.fail(fmt.Errorf("unknown constant tag %d", tag))
+	return nil
+}
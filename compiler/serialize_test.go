@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	key := &object.String{Value: "k"}
+	enum := object.NewEnum("Color", []string{"Red", "Green"})
+
+	bytecode := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpPop),
+		}),
+		Handlers:  []object.ExceptionHandler{{Start: 0, End: 3, Target: 4}},
+		Positions: []object.SourcePosition{{Offset: 0, File: "main.zum", Line: 1, Column: 1}, {Offset: 3, File: "main.zum", Line: 2, Column: 5}},
+		Constants: []object.Object{
+			&object.Integer{Value: -42},
+			&object.Float{Value: 2.5},
+			&object.String{Value: "olá"},
+			&object.Boolean{Value: true},
+			&object.Null{},
+			&object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Array{Elements: []object.Object{}}}},
+			&object.Dict{Pairs: map[object.DictKey]object.DictPair{
+				key.DictKey(): {Key: key, Value: &object.Integer{Value: 1}},
+			}},
+			enum,
+			enum.Members[1],
+			&object.CompiledFunction{
+				Name:          "f",
+				Instructions:  code.Make(code.OpReturn),
+				NumLocals:     2,
+				NumParameters: 1,
+				Handlers:      []object.ExceptionHandler{{Start: 0, End: 1, Target: 1}},
+				IsGenerator:   true,
+				Positions:     []object.SourcePosition{{Offset: 0, File: "lib/math.zum", Line: 4, Column: 12}},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := bytecode.Encode(&out); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	decoded, err := Decode(&out)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("wrong bytecode.\nwant=%#v\ngot=%#v", bytecode, decoded)
+	}
+}
+
+func TestEncodeIsStable(t *testing.T) {
+	input := `var d << {"b": 1, "a": 2, "c": fct(x) { x }}; d["a"]`
+
+	encodings := []string{}
+	for i := 0; i < 2; i++ {
+		compiler := New()
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		var out bytes.Buffer
+		if err := compiler.Bytecode().Encode(&out); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		encodings = append(encodings, out.String())
+	}
+
+	if encodings[0] != encodings[1] {
+		t.Errorf("compiling the same program twice gave different files")
+	}
+}
+
+func TestEncodeRuntimeObject(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Closure{Fn: &object.CompiledFunction{}}}}
+
+	err := bytecode.Encode(&bytes.Buffer{})
+	if err == nil || err.Error() != "cannot encode a constant of type CLOSURE_OBJ" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := (&Bytecode{Constants: []object.Object{&object.String{Value: "text"}}}).Encode(&valid); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	file := valid.Bytes()
+
+	otherVersion := append([]byte(Magic), binary.AppendUvarint(nil, FormatVersion+1)...)
+	otherBuiltins := append([]byte(Magic), binary.AppendUvarint(nil, FormatVersion)...)
+	otherBuiltins = append(otherBuiltins, 1, 3, 'f', 'o', 'o')
+	unknownTag := append(append([]byte{}, file[:len(file)-6]...), 99)
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte("show(1);"), "not a zumbra bytecode file"},
+		{otherVersion, fmt.Sprintf("bytecode format version %d is not supported, this zumbra reads version %d; build the file again", FormatVersion+1, FormatVersion)},
+		{otherBuiltins, "bytecode was built with other builtins (foo at 0); build the file again"},
+		{file[:len(file)-2], "damaged bytecode file: unexpected EOF"},
+		{unknownTag, "damaged bytecode file: unknown constant tag 99"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
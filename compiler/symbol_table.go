@@ -1,5 +1,7 @@
 package compiler
 
+import "zumbra/object/builtins"
+
 type SymbolScope string
 
 const (
@@ -77,6 +79,21 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineBuiltins defines the builtins policy allows and hides the ones it
+// denies, so that using them is a compile error. Names the program defined
+// itself are kept.
+func (s *SymbolTable) DefineBuiltins(policy builtins.Policy) {
+	for i, v := range builtins.Builtins {
+		symbol, defined := s.store[v.Name]
+		switch {
+		case policy.Allows(v.Capabilities) && !defined:
+			s.DefineBuiltin(i, v.Name)
+		case !policy.Allows(v.Capabilities) && defined && symbol.Scope == BuiltinScope:
+			delete(s.store, v.Name)
+		}
+	}
+}
+
 func (s *SymbolTable) DefineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -0,0 +1,58 @@
+package compiler
+
+import "zumbra/code"
+
+// markTailCalls turns the calls of the current function that are in tail
+// position, the ones whose result is returned right away, into
+// OpTailCall. That covers `return f(x)`, a call ending the body and a call
+// ending a branch of an if expression that ends the body. OpTailCall
+// returns by itself, so the instructions after it are never run.
+//
+// Calls inside a try statement are left alone, since the frame they would
+// reuse holds the handlers that catch their errors, and so are the calls
+// made by generators, which are resumed through their frame.
+func (c *Compiler) markTailCalls(isGenerator bool) {
+	if isGenerator {
+		return
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	instructions := decode(scope.instructions)
+	at := map[int]int{}
+	for i, in := range instructions {
+		at[in.pos] = i
+	}
+
+	// returns reports whether the instruction at i returns the value on
+	// top of the stack, directly or after jumps.
+	returns := func(i int) bool {
+		for seen := 0; i < len(instructions) && seen < len(instructions); seen++ {
+			switch in := instructions[i]; in.op {
+			case code.OpReturnValue:
+				return true
+			case code.OpJump, code.OpJumpWide:
+				i = at[in.operands[0]]
+			default:
+				return false
+			}
+		}
+		return false
+	}
+
+	for i, in := range instructions {
+		if in.op != code.OpCall || !returns(i+1) {
+			continue
+		}
+
+		guarded := false
+		for _, h := range scope.handlers {
+			if in.pos >= h.Start && in.pos < h.End {
+				guarded = true
+			}
+		}
+
+		if !guarded {
+			scope.instructions[in.pos] = byte(code.OpTailCall)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package compiler
+
+import (
+	"fmt"
+	"zumbra/code"
+	"zumbra/object"
+	"zumbra/object/builtins"
+)
+
+// Verify checks that bytecode is safe to hand to the VM. It checks the
+// main program and every compiled function in the constant pool:
+//
+//   - opcodes are known and no instruction is cut short
+//   - constant, local, builtin and free variable operands are in range
+//   - jumps, exception handlers and line table entries land on
+//     instructions
+//   - the stack holds the same number of values whichever path reaches
+//     an instruction, and never fewer than an instruction pops
+//   - the superinstructions that work on a local only run once every
+//     path has set it
+//   - functions return instead of running off their end, and only
+//     functions make tail calls
+//
+// The checks need no knowledge of the values, so they run once before the
+// program starts. Bytecode from the compiler always passes; Verify is for
+// bytecode read from files.
+func Verify(bytecode *Bytecode) error {
+	v := &verifier{constants: bytecode.Constants, freeUsed: map[int]int{}}
+
+	main := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		Positions:    bytecode.Positions,
+	}
+	if err := v.function("main program", -1, main); err != nil {
+		return err
+	}
+
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := v.function(fmt.Sprintf("function %d", i), i, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, c := range v.closures {
+		if used := v.freeUsed[c.function]; c.numFree < used {
+			return fmt.Errorf("%s at %04d: closure of function %d gets %d free variables, it uses %d",
+				c.where, c.pos, c.function, c.numFree, used)
+		}
+	}
+
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	// freeUsed maps the index of each function to the number of free
+	// variables its instructions read, and closures lists the closures
+	// created, to check they are given that many.
+	freeUsed map[int]int
+	closures []closureSite
+}
+
+type closureSite struct {
+	where    string
+	pos      int
+	function int
+	numFree  int
+}
+
+// edge is a path into the instruction at pos with depth values on the
+// stack.
+type edge struct {
+	pos, depth int
+}
+
+func (v *verifier) function(where string, index int, fn *object.CompiledFunction) error {
+	fail := func(pos int, format string, args ...interface{}) error {
+		return fmt.Errorf("%s at %04d: %s", where, pos, fmt.Sprintf(format, args...))
+	}
+
+	if fn.NumParameters > fn.NumLocals {
+		return fmt.Errorf("%s: %d parameters but only %d locals", where, fn.NumParameters, fn.NumLocals)
+	}
+
+	ins := fn.Instructions
+	instructions := []instruction{}
+	at := map[int]int{}
+
+	for pos := 0; pos < len(ins); {
+		in, size, err := readInstruction(ins, pos)
+		if err != nil {
+			return fail(pos, "%s", err)
+		}
+
+		at[pos] = len(instructions)
+		instructions = append(instructions, in)
+		pos += size
+	}
+
+	// A jump may also leave the main program by landing on its end.
+	lands := func(pos int) bool {
+		_, ok := at[pos]
+		return ok || (index < 0 && pos == len(ins))
+	}
+
+	for _, in := range instructions {
+		if err := v.operands(in, index, fn, where); err != nil {
+			return fail(in.pos, "%s", err)
+		}
+
+		if in.op == code.OpTailCall && index < 0 {
+			return fail(in.pos, "the main program has no frame for a tail call to reuse")
+		}
+
+		if t, ok := target(in.op); ok && !lands(in.operands[t]) {
+			return fail(in.pos, "jump target %d is not an instruction", in.operands[t])
+		}
+	}
+
+	bound := func(pos int) bool {
+		_, ok := at[pos]
+		return ok || pos == len(ins)
+	}
+	for _, h := range fn.Handlers {
+		_, target := at[h.Target]
+		if h.Start > h.End || !bound(h.Start) || !bound(h.End) || !target {
+			return fmt.Errorf("%s: handler %+v does not cover instructions", where, h)
+		}
+	}
+
+	for i, p := range fn.Positions {
+		if _, ok := at[p.Offset]; !ok || (i > 0 && p.Offset <= fn.Positions[i-1].Offset) {
+			return fmt.Errorf("%s: line table entry %d at %d is out of order or not on an instruction", where, i, p.Offset)
+		}
+	}
+
+	// Follow every path through the code, recording the stack depth at
+	// each instruction. A caught error leaves just the error on the stack.
+	depths := map[int]int{}
+	work := []edge{}
+	reach := func(from int, e edge) error {
+		if e.pos == len(ins) {
+			if index >= 0 {
+				return fail(from, "the function runs off its end")
+			}
+			return nil
+		}
+		if depth, seen := depths[e.pos]; seen {
+			if depth != e.depth {
+				return fail(e.pos, "the stack holds %d values on one path and %d on another", depth, e.depth)
+			}
+			return nil
+		}
+		depths[e.pos] = e.depth
+		work = append(work, e)
+		return nil
+	}
+
+	if len(instructions) > 0 {
+		work = append(work, edge{0, 0})
+		depths[0] = 0
+	} else if index >= 0 {
+		return fmt.Errorf("%s: the function has no instructions", where)
+	}
+	for _, h := range fn.Handlers {
+		if err := reach(h.Target, edge{h.Target, 1}); err != nil {
+			return err
+		}
+	}
+
+	for len(work) > 0 {
+		e := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		i := at[e.pos]
+		in := instructions[i]
+		next := len(ins)
+		if i+1 < len(instructions) {
+			next = instructions[i+1].pos
+		}
+
+		pops, pushes := stackUse(in)
+		if pops > e.depth {
+			return fail(in.pos, "%s pops %d values from a stack of %d", opName(in.op), pops, e.depth)
+		}
+		depth := e.depth - pops + pushes
+
+		var edges []edge
+		switch in.op {
+		case code.OpJump, code.OpJumpWide, code.OpWhile:
+			edges = []edge{{in.operands[0], depth}}
+		case code.OpJumpNotTruthy, code.OpJumpNotTruthyWide:
+			edges = []edge{{in.operands[0], depth}, {next, depth}}
+		case code.OpIterNext, code.OpIterNextWide:
+			// The iterator is replaced by its next value, or dropped once
+			// it is done.
+			edges = []edge{{in.operands[0], depth - 1}, {next, depth}}
+		case code.OpCompareLocalJump:
+			edges = []edge{{in.operands[3], depth}, {next, depth}}
+		case code.OpReturnValue, code.OpReturn, code.OpThrow, code.OpTailCall:
+		default:
+			edges = []edge{{next, depth}}
+		}
+
+		for _, to := range edges {
+			if err := reach(in.pos, to); err != nil {
+				return err
+			}
+		}
+	}
+
+	return v.locals(fn, instructions, at, fail)
+}
+
+// locals follows every path through fn, recording the locals each path
+// has set by every instruction, to check OpAddLocalConstant and
+// OpCompareLocalJump only read locals every path has set. Parameters are
+// set on entry, and an error reaching a handler keeps the locals set
+// before the instruction that raised it.
+func (v *verifier) locals(fn *object.CompiledFunction, instructions []instruction, at map[int]int,
+	fail func(int, string, ...interface{}) error) error {
+	if len(instructions) == 0 {
+		return nil
+	}
+
+	set := map[int][]bool{}
+	work := []int{}
+	meet := func(pos int, state []bool) {
+		if _, ok := at[pos]; !ok {
+			// The end of the main program.
+			return
+		}
+
+		current, seen := set[pos]
+		if !seen {
+			set[pos] = append([]bool(nil), state...)
+			work = append(work, pos)
+			return
+		}
+
+		changed := false
+		for i := range current {
+			if current[i] && !state[i] {
+				current[i] = false
+				changed = true
+			}
+		}
+		if changed {
+			work = append(work, pos)
+		}
+	}
+
+	entry := make([]bool, fn.NumLocals)
+	for i := 0; i < fn.NumParameters; i++ {
+		entry[i] = true
+	}
+	meet(0, entry)
+
+	for len(work) > 0 {
+		pos := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		i := at[pos]
+		in := instructions[i]
+		state := set[pos]
+
+		for _, h := range fn.Handlers {
+			if pos >= h.Start && pos < h.End {
+				meet(h.Target, state)
+			}
+		}
+
+		switch in.op {
+		case code.OpAddLocalConstant, code.OpCompareLocalJump:
+			if !state[in.operands[0]] {
+				return fail(pos, "%s reads local %d before it is set", opName(in.op), in.operands[0])
+			}
+		case code.OpSetLocal, code.OpSetLocalWide, code.OpAssignLocal, code.OpAssignLocalWide:
+			state = append([]bool(nil), state...)
+			state[in.operands[0]] = true
+		}
+
+		next := -1
+		if i+1 < len(instructions) {
+			next = instructions[i+1].pos
+		}
+		for _, to := range successors(in, next) {
+			meet(to, state)
+		}
+	}
+
+	return nil
+}
+
+// successors returns the positions the instruction in may continue at,
+// next being the position of the instruction after it.
+func successors(in instruction, next int) []int {
+	switch in.op {
+	case code.OpJump, code.OpJumpWide, code.OpWhile:
+		return []int{in.operands[0]}
+	case code.OpJumpNotTruthy, code.OpJumpNotTruthyWide, code.OpIterNext, code.OpIterNextWide:
+		return []int{in.operands[0], next}
+	case code.OpCompareLocalJump:
+		return []int{in.operands[3], next}
+	case code.OpReturnValue, code.OpReturn, code.OpThrow, code.OpTailCall:
+		return nil
+	}
+	return []int{next}
+}
+
+// operands checks the operands of in, an instruction of the function at
+// index in the constant pool, or of the main program when index is -1.
+func (v *verifier) operands(in instruction, index int, fn *object.CompiledFunction, where string) error {
+	constant := func(i int) error {
+		if i >= len(v.constants) {
+			return fmt.Errorf("constant %d out of range, the pool has %d", i, len(v.constants))
+		}
+		return nil
+	}
+	local := func(i int) error {
+		if i >= fn.NumLocals {
+			return fmt.Errorf("local %d out of range, the function has %d", i, fn.NumLocals)
+		}
+		return nil
+	}
+	free := func(i int) error {
+		if index < 0 {
+			return fmt.Errorf("the main program has no free variables")
+		}
+		v.freeUsed[index] = max(v.freeUsed[index], i+1)
+		return nil
+	}
+
+	switch in.op {
+	case code.OpConstant, code.OpConstantWide:
+		return constant(in.operands[0])
+
+	case code.OpGetLocal, code.OpSetLocal, code.OpAssignLocal, code.OpGetLocalCell,
+		code.OpGetLocalWide, code.OpSetLocalWide, code.OpAssignLocalWide, code.OpGetLocalCellWide:
+		return local(in.operands[0])
+
+	case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell,
+		code.OpGetFreeWide, code.OpSetFreeWide, code.OpGetFreeCellWide:
+		return free(in.operands[0])
+
+	case code.OpGetBuiltin, code.OpGetBuiltinWide:
+		if in.operands[0] >= len(builtins.Builtins) {
+			return fmt.Errorf("builtin %d out of range, there are %d", in.operands[0], len(builtins.Builtins))
+		}
+
+	case code.OpClosure, code.OpClosureWide:
+		if err := constant(in.operands[0]); err != nil {
+			return err
+		}
+		if _, ok := v.constants[in.operands[0]].(*object.CompiledFunction); !ok {
+			return fmt.Errorf("constant %d is not a function", in.operands[0])
+		}
+		v.closures = append(v.closures, closureSite{where, in.pos, in.operands[0], in.operands[1]})
+
+	case code.OpImport:
+		if err := constant(in.operands[1]); err != nil {
+			return err
+		}
+		if fn, ok := v.constants[in.operands[1]].(*object.CompiledFunction); !ok || fn.NumParameters != 0 || fn.IsGenerator {
+			return fmt.Errorf("constant %d is not a module loader", in.operands[1])
+		}
+		v.closures = append(v.closures, closureSite{where, in.pos, in.operands[1], 0})
+
+	case code.OpModule, code.OpModuleWide:
+		if err := constant(in.operands[0]); err != nil {
+			return err
+		}
+		if _, ok := v.constants[in.operands[0]].(*object.String); !ok {
+			return fmt.Errorf("constant %d is not a module name", in.operands[0])
+		}
+		if in.operands[1]%2 != 0 {
+			return fmt.Errorf("odd number of module members %d", in.operands[1])
+		}
+
+	case code.OpDict:
+		if in.operands[0]%2 != 0 {
+			return fmt.Errorf("odd number of dict elements %d", in.operands[0])
+		}
+
+	case code.OpAddLocalConstant:
+		if err := local(in.operands[0]); err != nil {
+			return err
+		}
+		return constant(in.operands[1])
+
+	case code.OpCompareLocalJump:
+		if err := local(in.operands[0]); err != nil {
+			return err
+		}
+		if err := constant(in.operands[1]); err != nil {
+			return err
+		}
+		if !comparison(code.Opcode(in.operands[2])) {
+			return fmt.Errorf("%d is not a comparison", in.operands[2])
+		}
+	}
+
+	return nil
+}
+
+// stackUse returns how many values in pops and then pushes. Jumps that
+// depend on the values are followed by Verify itself.
+func stackUse(in instruction) (pops, pushes int) {
+	if pops, pushes, ok := stackEffect(in); ok {
+		return pops, pushes
+	}
+
+	switch in.op {
+	case code.OpPop, code.OpJumpNotTruthy, code.OpJumpNotTruthyWide, code.OpWhile,
+		code.OpSetGlobal, code.OpSetLocal, code.OpAssignLocal, code.OpSetFree,
+		code.OpSetLocalWide, code.OpAssignLocalWide, code.OpSetFreeWide,
+		code.OpReturnValue, code.OpThrow, code.OpYield:
+		return 1, 0
+	case code.OpConstantWide, code.OpGetLocalWide, code.OpGetBuiltinWide, code.OpGetFreeWide,
+		code.OpGetLocalCell, code.OpGetLocalCellWide, code.OpGetFreeCell, code.OpGetFreeCellWide,
+		code.OpImport:
+		return 0, 1
+	case code.OpCall, code.OpCallWide, code.OpTailCall:
+		return in.operands[0] + 1, 1
+	case code.OpCallGlobal:
+		return in.operands[1], 1
+	case code.OpClosure, code.OpClosureWide, code.OpModule, code.OpModuleWide:
+		return in.operands[1], 1
+	case code.OpIter, code.OpIterNext, code.OpIterNextWide:
+		return 1, 1
+	}
+
+	// OpJump, OpReturn and the superinstructions that work on locals.
+	return 0, 0
+}
+
+func opName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("opcode %d", op)
+	}
+	return def.Name
+}
@@ -0,0 +1,177 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		`var x << 0; while (x < 3) { x << x + 1; } if (x == 3) { "yes" } else { "no" }`,
+		`fct f(a) { var b << a; fct() { b << b + a; b } } f(1)()`,
+		`var g << fct() { yield 1; }; for (v in g()) { show(v); }`,
+		`fct f() { try { throw "x" } catch (e) { return e } finally { show(1) } } f()`,
+		`enum Color { Red }; var d << {"a": [Color.Red]}; d["a"][0]`,
+	}
+
+	for _, input := range inputs {
+		for _, optimizations := range []Optimization{NoOptimizations, AllOptimizations} {
+			compiler := New()
+			if err := compiler.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			bytecode := compiler.Bytecode()
+			Optimize(bytecode, optimizations)
+
+			if err := Verify(bytecode); err != nil {
+				t.Errorf("verify error for %q: %s", input, err)
+			}
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	function := func(numLocals int, ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{
+			&Bytecode{Instructions: code.Instructions{255}},
+			"main program at 0000: unknown opcode 255",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]},
+			"main program at 0000: OpConstant is cut short",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpConstant, 1), Constants: []object.Object{&object.Integer{}}},
+			"main program at 0000: constant 1 out of range, the pool has 1",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpGetLocal, 0)},
+			"main program at 0000: local 0 out of range, the function has 0",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpGetBuiltin, 255)},
+			"main program at 0000: builtin 255 out of range",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpJump, 2),
+				code.Make(code.OpNull),
+			})},
+			"main program at 0000: jump target 2 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpPop)},
+			"main program at 0000: OpPop pops 1 values from a stack of 0",
+		},
+		{
+			// The true path pushes null before joining the other.
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpTrue),             // 0000
+				code.Make(code.OpJumpNotTruthy, 5), // 0001
+				code.Make(code.OpNull),             // 0004
+				code.Make(code.OpNull),             // 0005
+			})},
+			"main program at 0005: the stack holds 0 values on one path and 1 on another",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions([]code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpPop),
+				}),
+				Constants: []object.Object{function(0, code.Make(code.OpNull))},
+			},
+			"function 0 at 0000: the function runs off its end",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions([]code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpPop),
+				}),
+				Constants: []object.Object{&object.String{Value: "f"}},
+			},
+			"main program at 0000: constant 0 is not a function",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions([]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpPop),
+				}),
+				Constants: []object.Object{function(0,
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpReturnValue),
+				)},
+			},
+			"main program at 0001: closure of function 0 gets 1 free variables, it uses 2",
+		},
+		{
+			&Bytecode{
+				Instructions: code.Make(code.OpNull),
+				Handlers:     []object.ExceptionHandler{{Start: 0, End: 1, Target: 5}},
+			},
+			"main program: handler {Start:0 End:1 Target:5} does not cover instructions",
+		},
+		{
+			&Bytecode{
+				Instructions: code.Make(code.OpConstant, 0),
+				Constants:    []object.Object{&object.Integer{}},
+				Positions:    []object.SourcePosition{{Offset: 0, Line: 1, Column: 1}, {Offset: 2, Line: 1, Column: 3}},
+			},
+			"main program: line table entry 1 at 2 is out of order or not on an instruction",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions([]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpTailCall, 0),
+				}),
+			},
+			"main program at 0003: the main program has no frame for a tail call to reuse",
+		},
+		{
+			// Local 1 is only set when the condition holds.
+			&Bytecode{
+				Instructions: concatInstructions([]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				}),
+				Constants: []object.Object{
+					&object.Integer{Value: 1},
+					&object.CompiledFunction{NumParameters: 1, NumLocals: 2, Instructions: concatInstructions([]code.Instructions{
+						code.Make(code.OpGetLocal, 0),                                     // 0000
+						code.Make(code.OpJumpNotTruthy, 9),                                // 0002
+						code.Make(code.OpGetLocal, 0),                                     // 0005
+						code.Make(code.OpSetLocal, 1),                                     // 0007
+						code.Make(code.OpAddLocalConstant, 0, 0),                          // 0009
+						code.Make(code.OpCompareLocalJump, 1, 0, int(code.OpLessThan), 9), // 0013
+						code.Make(code.OpReturn),                                          // 0020
+					})},
+				},
+			},
+			"function 1 at 0013: OpCompareLocalJump reads local 1 before it is set",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil {
+			t.Errorf("expected an error like %q", tt.expected)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong error.\nwant=%q\ngot=%q", tt.expected, err)
+		}
+	}
+}
@@ -15,7 +15,8 @@ func init() {
 		"indexOf", "addToDict", "deleteFromDict",
 		"toString", "toInt", "toFloat", "toBool", "date", "organize", "toUppercase", "toLowercase", "capitalize",
 		"removeWhiteSpaces", "sum", "bhaskara", "getFromDict", "sendEmail", "randomInteger", "randomFloat", "sendWhatsapp",
-		"dictKeys", "dictValues", "replace",
+		"dictKeys", "dictValues", "replace", "values", "next",
+		"spawn", "channel", "send", "receive", "close", "waitAll",
 	}
 
 	for _, name := range names {
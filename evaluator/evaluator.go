@@ -3,11 +3,11 @@ package evaluator
 import (
 	"fmt"
 	"math"
-	"os"
 	"zumbra/ast"
 	"zumbra/lexer"
 	"zumbra/object"
 	"zumbra/parser"
+	"zumbra/resolver"
 )
 
 var (
@@ -46,6 +46,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		env.Set(node.Name.Value, value)
 
+	case *ast.AssignStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		if !env.Assign(node.Name.Value, value) {
+			return newError("undefined variable %s", node.Name.Value)
+		}
+
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
@@ -102,7 +111,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body, IsGenerator: node.IsGenerator}
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
@@ -115,7 +124,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		locate(env.Call(), node)
+		return applyFunction(function, args, env)
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -143,6 +153,52 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	case *ast.ImportStatement:
 		return evalImportStatement(node, env)
+
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
+
+	case *ast.FunctionStatement:
+		// Bound by hoistFunctions when the enclosing block started.
+		return nil
+
+	case *ast.EnumStatement:
+		members := []string{}
+		for _, m := range node.Members {
+			members = append(members, m.Value)
+		}
+		env.Set(node.Name.Value, object.NewEnum(node.Name.Value, members))
+
+	case *ast.ThrowStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		if errObj, ok := value.(*object.Error); ok {
+			return &object.Error{Message: errObj.Message, Stack: errObj.Stack}
+		}
+		return &object.Error{Message: value.Inspect(), Stack: env.Call().Trace()}
+
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+
+	case *ast.YieldStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		if !env.Yield(value) {
+			return newError("yield outside of generator")
+		}
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.AttributeAccess:
+		obj := Eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalAttributeAccess(obj, node.Property.Value)
 	}
 
 	return nil
@@ -152,14 +208,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoistFunctions(program.Statements, env)
+	call := env.Call()
+
 	for _, statement := range program.Statements {
+		locate(call, statement)
 		result = Eval(statement, env)
 
-		switch result := result.(type) {
-		case *object.ReturnValue:
-			return result.Value
-		case *object.Error:
-			return result
+		if returnValue, ok := result.(*object.ReturnValue); ok {
+			return returnValue.Value
+		}
+		if isError(result) {
+			return withStack(result, call)
 		}
 	}
 
@@ -169,20 +229,36 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoistFunctions(block.Statements, env)
+	call := env.Call()
+
 	for _, statement := range block.Statements {
+		locate(call, statement)
 		result = Eval(statement, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
-			}
+		if result != nil && result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
+			return result
 		}
 	}
 
 	return result
 }
 
+// hoistFunctions binds the functions declared in statements before any of
+// them runs, so they can refer to each other and be called from anywhere
+// in the block.
+func hoistFunctions(statements []ast.Statement, env *object.Environment) {
+	for _, statement := range statements {
+		if es, ok := statement.(*ast.ExportStatement); ok {
+			statement = es.Statement
+		}
+
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			env.Set(fs.Name.Value, Eval(fs.Function, env))
+		}
+	}
+}
+
 func nativeBoolToBooleanObject(input bool) object.Object {
 	if input {
 		return TRUE
@@ -425,9 +501,29 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// withStack gives an error raised in call the stack of functions running
+// when it was raised. Errors are made without one, since most of them are
+// made away from the environment, and get it on leaving the call they
+// were raised in, whose position is still that of the failing statement.
+func withStack(obj object.Object, call *object.Call) object.Object {
+	if isError(obj) {
+		if errObj := obj.(*object.Error); errObj.Stack == nil {
+			errObj.Stack = call.Trace()
+		}
+	}
+	return obj
+}
+
+// locate records node as the source call is at.
+func locate(call *object.Call, node ast.Node) {
+	if tok := ast.Start(node); tok.Line > 0 {
+		call.Position = object.SourcePosition{Line: tok.Line, Column: tok.Column}
+	}
+}
+
 func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+	if errObj, ok := obj.(*object.Error); ok {
+		return !errObj.Caught
 	}
 	return false
 }
@@ -464,15 +560,17 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fct object.Object, args []object.Object) object.Object {
+func applyFunction(fct object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fct := fct.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fct, args)
-		evaluated := Eval(fct.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		return callFunction(fct, args, env.Call())
 
 	case *object.Builtin:
-		if result := fct.Fn(args...); result != nil {
+		result := fct.Call(&runtime{env: env}, args...)
+		if isError(result) {
+			return newError("%s: %s", fct.Name, result.(*object.Error).Message)
+		}
+		if result != nil {
 			return result
 		}
 
@@ -484,6 +582,29 @@ func applyFunction(fct object.Object, args []object.Object) object.Object {
 
 }
 
+// callFunction runs fct on behalf of caller, which is nil for functions
+// started as tasks.
+func callFunction(fct *object.Function, args []object.Object, caller *object.Call) object.Object {
+	if fct.IsGenerator {
+		return newGenerator(fct, args)
+	}
+
+	call := &object.Call{Function: functionName(fct), Caller: caller}
+	extendedEnv := extendFunctionEnv(fct, args)
+	extendedEnv.SetCall(call)
+
+	evaluated := Eval(fct.Body, extendedEnv)
+	return unwrapReturnValue(withStack(evaluated, call))
+}
+
+// functionName is the name fct is shown with in stack traces.
+func functionName(fct *object.Function) string {
+	if fct.Name != "" {
+		return fct.Name
+	}
+	return "fct"
+}
+
 func extendFunctionEnv(fct *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fct.Env)
 
@@ -600,27 +721,192 @@ func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.
 	return result
 }
 
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iterator, ok := object.Iterate(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for {
+		value, err := iterator.Next()
+		if err != nil {
+			return newError("%s", err.Error())
+		}
+		if value == nil {
+			return nil
+		}
+
+		// Each iteration gets its own binding, so closures created in the
+		// body keep the value they were created with.
+		iterationEnv := object.NewEnclosedEnvironment(env)
+		iterationEnv.Set(fs.Name.Value, value)
+
+		result := Eval(fs.Body, iterationEnv)
+		if result != nil && result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
+			return result
+		}
+	}
+}
+
 func evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
 	path := node.Path.Value
 
-	if env.IsImported(path) {
+	r := env.Resolver()
+	src, err := r.Resolve(path, env.Dir())
+	if err != nil {
+		return newError("%s", err.Error())
+	}
+
+	if err := r.Enter(src.Name); err != nil {
+		return newError("%s", err.Error())
+	}
+	defer r.Leave()
+
+	if node.Alias != nil {
+		module := evalModule(src, path, env)
+		if isError(module) {
+			return module
+		}
+		env.Set(node.Alias.Value, module)
+		return nil
+	}
+
+	if env.IsImported(src.Name) {
 		return nil
 	}
 
-	env.MarkImported(path)
+	env.MarkImported(src.Name)
 
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return newError("Could not read imported file: %s", path)
+	program, errObj := parseImport(src, path)
+	if errObj != nil {
+		return errObj
+	}
+
+	oldDir := env.Dir()
+	env.SetDir(src.Dir)
+	defer env.SetDir(oldDir)
+
+	return Eval(program, env)
+}
+
+// evalModule runs an imported file in an environment of its own, once per
+// program, and collects its exported names into a module object.
+func evalModule(src *resolver.Source, path string, env *object.Environment) object.Object {
+	if module, ok := env.Module(src.Name); ok {
+		return module
+	}
+
+	program, errObj := parseImport(src, path)
+	if errObj != nil {
+		return errObj
+	}
+
+	moduleEnv := object.NewModuleEnvironment(env)
+	moduleEnv.SetDir(src.Dir)
+	moduleEnv.SetCall(&object.Call{Function: "module " + src.Name, Caller: env.Call()})
+	result := Eval(program, moduleEnv)
+	if isError(result) {
+		return result
+	}
+
+	module := &object.Module{Name: path, Members: map[string]object.Object{}}
+	for _, name := range program.Exports() {
+		if value, ok := moduleEnv.Get(name); ok {
+			module.Members[name] = value
+		}
 	}
 
-	l := lexer.New(string(content))
+	env.SetModule(src.Name, module)
+	return module
+}
+
+func parseImport(src *resolver.Source, path string) (*ast.Program, *object.Error) {
+	l := lexer.New(src.Content)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		return newError("Could not parse imported file: %s", path)
+		return nil, newError("Could not parse imported file: %s", path)
 	}
 
-	return Eval(program, env)
+	return program, nil
+}
+
+func evalAttributeAccess(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Date:
+		switch name {
+		case "hour":
+			return &object.Integer{Value: int64(obj.Hour)}
+		case "minute":
+			return &object.Integer{Value: int64(obj.Minute)}
+		case "day":
+			return &object.Integer{Value: int64(obj.Day)}
+		case "second":
+			return &object.Integer{Value: int64(obj.Second)}
+		case "month":
+			return &object.Integer{Value: int64(obj.Month)}
+		case "year":
+			return &object.Integer{Value: int64(obj.Year)}
+		case "fullDate":
+			return &object.String{Value: obj.FullDate.String()}
+		default:
+			return newError("unknown attribute %s for Date", name)
+		}
+	case *object.Enum:
+		member, ok := obj.Member(name)
+		if !ok {
+			return newError("enum %s has no member %s", obj.Name, name)
+		}
+		return member
+	case *object.Module:
+		member, ok := obj.Members[name]
+		if !ok {
+			return newError("module %s has no member %s", obj.Name, name)
+		}
+		return member
+	case *object.Error:
+		switch name {
+		case "message":
+			return &object.String{Value: obj.Message}
+		case "stack":
+			elements := make([]object.Object, len(obj.Stack))
+			for i, line := range obj.Stack {
+				elements[i] = &object.String{Value: line}
+			}
+			return &object.Array{Elements: elements}
+		default:
+			return newError("unknown attribute %s for Error", name)
+		}
+	default:
+		return newError("object type %s has no attributes", obj.Type())
+	}
+}
+
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Block, env)
+
+	if isError(result) && ts.Catch != nil {
+		errObj := withStack(result, env.Call()).(*object.Error)
+		errObj.Caught = true
+		env.Set(ts.Param.Value, errObj)
+		result = Eval(ts.Catch, env)
+	}
+
+	if ts.Finally != nil {
+		finallyResult := Eval(ts.Finally, env)
+		if isError(finallyResult) {
+			return finallyResult
+		}
+		if finallyResult != nil && finallyResult.Type() == object.RETURN_VALUE_OBJ {
+			return finallyResult
+		}
+	}
+
+	return result
 }
@@ -3,10 +3,13 @@ package evaluator
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"zumbra/lexer"
 	"zumbra/object"
 	"zumbra/parser"
+	"zumbra/resolver"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -358,8 +361,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`sizeOf("")`, 0},
 		{`sizeOf("four")`, 4},
 		{`sizeOf("hello world")`, 11},
-		{`sizeOf(1)`, "argument to `sizeOf` not supported, got INTEGER"},
-		{`sizeOf("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`sizeOf(1)`, "sizeOf: argument to `sizeOf` not supported, got INTEGER"},
+		{`sizeOf("one", "two")`, "sizeOf: wrong number of arguments. got=2, want=1"},
 		{`indexOf([1, 2, 3], 3)`, 2},
 	}
 
@@ -732,3 +735,430 @@ func TestStringComparison(t *testing.T) {
 		}
 	}
 }
+
+func TestEnums(t *testing.T) {
+	evaluated := testEval(`enum Status { Pending, Paid }; Status.Paid == Status.Paid`)
+	testBooleanObject(t, evaluated, true)
+
+	evaluated = testEval(`enum Status { Pending, Paid }; Status.Paid == Status.Pending`)
+	testBooleanObject(t, evaluated, false)
+
+	evaluated = testEval(`enum Status { Pending, Paid }; var d << {Status.Paid: 1, Status.Pending: 2}; d[Status.Pending]`)
+	testIntegerObject(t, evaluated, 2)
+
+	evaluated = testEval(`enum Status { Pending, Paid }; Status.Missing`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "enum Status has no member Missing" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestTryCatchFinally(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`var r << 0; try { throw "boom"; } catch (e) { r << 2; }; r`, 2},
+		{`var r << ""; try { throw "boom"; } catch (e) { r << e.message; }; r`, "boom"},
+		{`var r << ""; try { toInt(1, 2); } catch (e) { r << e.message; }; r`, "toInt: wrong number of arguments. got=2, want=1"},
+		{`var r << 0; try { r << 1; } finally { r << r + 10; }; r`, 11},
+		{`var f << fct() { try { return 1; } finally { 2; } }; f()`, 1},
+		{`var f << fct() { try { throw "x"; } catch (e) { return 3; } }; f()`, 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fct f() {\n  throw \"x\";\n}\nvar s << [];\ntry { f(); } catch (e) { s << e.stack; }\ns",
+			[]string{"at f (2:3)", "at main (5:7)"}},
+		{"fct inner(x) {\n  show(x);\n  x + \"a\";\n}\nvar outer << fct() { inner(1); 2 };\nvar s << [];\ntry { outer(); } catch (e) { s << e.stack; }\ns",
+			[]string{"at inner (3:3)", "at outer (5:22)", "at main (7:7)"}},
+		{"var fs << [fct() { throw 1; }];\nvar s << [];\ntry { fs[0](); } catch (e) { s << e.stack; }\ns",
+			[]string{"at fct (1:20)", "at main (3:7)"}},
+		{"fct r(n) { if (n == 0) { throw \"deep\"; } r(n - 1); 0 }\nvar s << [];\ntry { r(3); } catch (e) { s << e.stack; }\ns",
+			[]string{"at r (1:26)", "at r (1:42)", "... repeated 2 more times", "at main (3:7)"}},
+		{"var s << [];\ntry { throw \"x\"; } catch (e) { s << e.stack; }\ns",
+			[]string{"at main (2:7)"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		stack, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Errorf("%q: object is not Array. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		got := []string{}
+		for _, line := range stack.Elements {
+			got = append(got, line.(*object.String).Value)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: wrong stack.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+		fct isEven(n) { if (n == 0) { return true; } isOdd(n - 1) }
+		fct isOdd(n) { if (n == 0) { return false; } isEven(n - 1) }
+		isEven(10)
+		`, true},
+		{`
+		fct outer(x) {
+			fct a(n) { if (n == 0) { return x; } b(n - 1) }
+			fct b(n) { if (n == 0) { return 0 - x; } a(n - 1) }
+			a(3) + a(4) * 10
+		}
+		outer(7)
+		`, 63},
+		{`
+		fct f() {
+			fct twice() { fct() { once() * 2 }() }
+			fct once() { 21 }
+			twice()
+		}
+		f()
+		`, 42},
+		{`
+		fct fact(n) { if (n < 2) { return 1; } n * fact(n - 1) }
+		var nested << fct() { fct fib(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) } fib(10) };
+		fact(5) + nested()
+		`, 175},
+		{`var x << early(); fct early() { 5 } x`, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestMutableCapturedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+		fct makeCounter() {
+			var count << 0;
+			fct() { count << count + 1; count }
+		}
+		var c << makeCounter();
+		c(); c();
+		var d << makeCounter();
+		c() * 10 + d()
+		`, 31},
+		{`
+		fct pair() {
+			var value << 0;
+			var get << fct() { value };
+			var set << fct(v) { value << v };
+			value << 5;
+			var before << get();
+			set(10);
+			[before, get(), value]
+		}
+		pair()
+		`, []int{5, 10, 10}},
+		{`
+		fct deep() {
+			var total << 0;
+			var add << fct(n) { fct() { total << total + n } };
+			add(2)();
+			add(3)();
+			total
+		}
+		deep()
+		`, 5},
+		{`
+		fct loop() {
+			var fs << [];
+			for (x in [1, 2, 3]) { addToArrayEnd(fs, fct() { x }) }
+			[fs[0](), fs[1](), fs[2]()]
+		}
+		loop()
+		`, []int{1, 2, 3}},
+		{`
+		fct count() {
+			var seen << 0;
+			var g << fct() { yield 1; seen << seen + 1; yield 2; seen << seen + 1; };
+			for (v in g()) { }
+			seen
+		}
+		count()
+		`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong number of elements. want=%d, got=%d", len(expected), len(array.Elements))
+				continue
+			}
+			for i, el := range expected {
+				testIntegerObject(t, array.Elements[i], int64(el))
+			}
+		}
+	}
+}
+
+func TestTypeAnnotationsAreIgnored(t *testing.T) {
+	input := `
+	var total: int << 0;
+	var scores: array<int> << [1, 2, 3];
+	fct add(a: int, b: int): int { a + b }
+	var f << fct(xs: array<int>): int { sum(xs) };
+	total << add(f(scores), 4);
+	total
+	`
+
+	testIntegerObject(t, testEval(input), 10)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`var g << fct() { yield 1; yield 2; }; var it << g(); next(it); next(it)`, 2},
+		{`var g << fct() { yield 1; }; var it << g(); next(it); next(it)`, nil},
+		{`var count << fct(n) { var i << 0; while (i < n) { yield i; i << i + 1; } }; var s << 0; for (x in count(5)) { s << s + x; }; s`, 10},
+		{`var s << 0; for (x in [1, 2, 3]) { s << s + x; }; s`, 6},
+		{`var f << fct() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()`, 20},
+		{`var nat << fct() { var i << 0; while (true) { i << i + 1; yield i; } }; var it << nat(); next(it); next(it); next(it)`, 3},
+		{`var g << fct() { yield 1; throw "boom"; }; var r << ""; try { for (x in g()) { r << r + toString(x); } } catch (e) { r << r + e.message; }; r`, "1boom"},
+		{`for (x in 1) { x; }`, "cannot iterate over INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTasks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`var t << spawn(fct(a, b) { a + b }, 1, 2); first(waitAll(t))`, 3},
+		{`var f << fct(n) { n * 2 }; sum(waitAll([spawn(f, 1), spawn(f, 2), spawn(f, 3)]))`, 12},
+		{`var ch << channel(); spawn(fct() { send(ch, 42); }); receive(ch)`, 42},
+		{`var ch << channel(); var s << 0; spawn(fct() { var i << 1; while (i <= 4) { send(ch, i); i << i + 1; } close(ch); }); for (x in ch) { s << s + x; }; s`, 10},
+		{`receive(channel())`, "receive: all tasks are asleep - deadlock"},
+		{`send(channel("INTEGER", 1), "one")`, "send: cannot send STRING on channel of INTEGER"},
+		{`waitAll(spawn(fct() { throw "boom"; }))`, "waitAll: boom"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	utils := filepath.Join(dir, "utils.zum")
+	content := `
+var factor << 2;
+var calls << [];
+export var twoTimes << fct(x) { addToArrayEnd(calls, x); x * factor };
+export var callCount << fct() { sizeOf(calls) };
+`
+	if err := os.WriteFile(utils, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{fmt.Sprintf(`import "%s" as utils; utils.twoTimes(8)`, utils), 16},
+		{fmt.Sprintf(`var factor << 10; import "%s" as utils; utils.twoTimes(8) + factor`, utils), 26},
+		{fmt.Sprintf(`import "%s" as a; import "%s" as b; a.twoTimes(1); b.twoTimes(2); a.callCount()`, utils, utils), 2},
+		{fmt.Sprintf(`import "%s" as utils; utils.factor`, utils), "module " + utils + " has no member factor"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestStdLibrary(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "std:math" as math; math.power(2, 10)`, 1024},
+		{`import "std:math" as math; math.clamp(15, 0, 10) + math.abs(-3)`, 13},
+		{`import "std:arrays" as arrays; arrays.reduce(arrays.map([1, 2, 3], fct(x) { x * x }), fct(a, b) { a + b }, 0)`, 14},
+		{`import "std:strings" as strings; strings.join([1, 2, 3], ", ")`, "1, 2, 3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestImportCycles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.zum":     `import "lib/b.zum"`,
+		"lib/b.zum": `import "../a.zum"`,
+		"m1.zum":    `import "m2.zum" as m2; export var one << 1;`,
+		"m2.zum":    `import "m1.zum" as m1; export var two << 2;`,
+		"self.zum":  `import "self.zum" as me;`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "a.zum"`, "import cycle: a.zum -> " + filepath.Join("lib", "b.zum") + " -> a.zum"},
+		{`import "m1.zum" as m1`, "import cycle: m1.zum -> m2.zum -> m1.zum"},
+		{`import "self.zum" as me`, "import cycle: self.zum -> self.zum"},
+		{`import "missing.zum"`, "could not find imported file: missing.zum"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		env := object.NewEnvironment()
+		env.SetResolver(resolver.New(dir))
+		env.SetDir(dir)
+
+		evaluated := Eval(p.ParseProgram(), env)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestImportCyclesThroughTheEntryFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.zum": `import "back.zum"`,
+		"back.zum": `import "main.zum" as main`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+
+	env := object.NewEnvironment()
+	env.SetResolver(resolver.New(dir))
+	env.SetFile(filepath.Join(dir, "main.zum"))
+
+	p := parser.New(lexer.New(files["main.zum"]))
+	evaluated := Eval(p.ParseProgram(), env)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "import cycle: main.zum -> back.zum -> main.zum"
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+}
@@ -0,0 +1,52 @@
+package evaluator
+
+import (
+	"errors"
+	"zumbra/object"
+)
+
+// newGenerator runs the body of fct on a goroutine of its own, which is
+// started by the first resume and hands control back at every yield. A
+// generator that is dropped before finishing leaves its goroutine parked.
+func newGenerator(fct *object.Function, args []object.Object) *object.Generator {
+	values := make(chan object.Object)
+	resume := make(chan struct{})
+	started := false
+
+	yield := func(val object.Object) {
+		values <- val
+		<-resume
+	}
+
+	run := func() {
+		env := object.NewGeneratorEnvironment(fct.Env, yield)
+		env.SetCall(&object.Call{Function: functionName(fct)})
+		for paramIdx, param := range fct.Parameters {
+			env.Set(param.Value, args[paramIdx])
+		}
+
+		result := Eval(fct.Body, env)
+		if isError(result) {
+			values <- result
+		}
+		close(values)
+	}
+
+	return &object.Generator{Resume: func() (object.Object, error) {
+		if started {
+			resume <- struct{}{}
+		} else {
+			started = true
+			go run()
+		}
+
+		value, ok := <-values
+		if !ok {
+			return nil, nil
+		}
+		if isError(value) {
+			return nil, errors.New(value.(*object.Error).Message)
+		}
+		return value, nil
+	}}
+}
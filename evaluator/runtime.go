@@ -0,0 +1,44 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"zumbra/object"
+)
+
+// runtime gives builtins called from env access to the evaluator.
+type runtime struct {
+	env *object.Environment
+}
+
+func (rt *runtime) Scheduler() *object.Scheduler {
+	return rt.env.Scheduler()
+}
+
+func (rt *runtime) Start(fn object.Object, args []object.Object) (func() (object.Object, error), error) {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return nil, fmt.Errorf("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+	case *object.Builtin:
+	default:
+		return nil, fmt.Errorf("cannot spawn %s", fn.Type())
+	}
+
+	return func() (object.Object, error) {
+		var result object.Object
+		if fn, ok := fn.(*object.Function); ok {
+			result = callFunction(fn, args, nil)
+		} else {
+			result = applyFunction(fn, args, rt.env)
+		}
+		if isError(result) {
+			return nil, errors.New(result.(*object.Error).Message)
+		}
+		if result == nil {
+			return NULL, nil
+		}
+		return result, nil
+	}, nil
+}
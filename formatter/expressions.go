@@ -0,0 +1,265 @@
+package formatter
+
+import (
+	"strings"
+	"zumbra/ast"
+	"zumbra/parser"
+)
+
+func (p *printer) expression(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+
+	case *ast.IntegerLiteral:
+		p.write(e.Token.Literal)
+
+	case *ast.FloatLiteral:
+		p.write(e.Token.Literal)
+
+	case *ast.StringLiteral:
+		p.write("\"" + e.Value + "\"")
+
+	case *ast.Boolean:
+		p.write(e.Token.Literal)
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		// Two minus signs in a row would read as `--`.
+		if right, ok := e.Right.(*ast.PrefixExpression); ok && right.Operator == "-" && e.Operator == "-" {
+			p.write("(")
+			p.expression(right)
+			p.write(")")
+			return
+		}
+		p.operand(e.Right, parser.PREFIX)
+
+	case *ast.InfixExpression:
+		precedence := parser.Precedence(e.Token.Type)
+		p.operand(e.Left, precedence)
+		p.write(" " + e.Operator + " ")
+		// Operators group to the left, so an operand on the right that
+		// binds as loosely needs parentheses.
+		p.operand(e.Right, precedence+1)
+
+	case *ast.CallExpression:
+		p.operand(e.Function, parser.CALL)
+		p.write("(")
+		for i, arg := range e.Arguments {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(arg)
+		}
+		p.write(")")
+
+	case *ast.IndexExpression:
+		p.operand(e.Left, parser.INDEX)
+		p.write("[")
+		p.expression(e.Index)
+		p.write("]")
+
+	case *ast.AttributeAccess:
+		p.operand(e.Object, parser.INDEX)
+		p.write("." + e.Property.Value)
+
+	case *ast.ArrayLiteral:
+		elements := []element{}
+		for _, el := range e.Elements {
+			elements = append(elements, element{
+				line:  ast.Start(el).Line,
+				end:   endLine(el),
+				write: func(q *printer) { q.expression(el) },
+			})
+		}
+		p.list("[", "]", e.Token.Line, elements, false)
+
+	case *ast.DictLiteral:
+		p.list("{", "}", e.Token.Line, pairs(e), false)
+
+	case *ast.FunctionLiteral:
+		p.functionLiteral(e, true)
+
+	case *ast.IfExpression:
+		p.ifExpression(e, true)
+	}
+}
+
+// operand writes e, in parentheses if it binds less tightly than
+// precedence.
+func (p *printer) operand(e ast.Expression, precedence int) {
+	if bindingOf(e) >= precedence {
+		p.expression(e)
+		return
+	}
+
+	p.write("(")
+	p.expression(e)
+	p.write(")")
+}
+
+func bindingOf(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	default:
+		return parser.INDEX
+	}
+}
+
+// pairs returns the pairs of a dict in the order they were written.
+func pairs(dict *ast.DictLiteral) []element {
+	elements := []element{}
+	for _, k := range dict.Keys() {
+		key, value := k, dict.Pairs[k]
+		elements = append(elements, element{
+			line: ast.Start(key).Line,
+			end:  max(endLine(key), endLine(value)),
+			write: func(q *printer) {
+				q.expression(key)
+				q.write(": ")
+				q.expression(value)
+			},
+		})
+	}
+	return elements
+}
+
+// element is an item of an array, dict or enum, written by write, whose
+// source starts at line and ends at end.
+type element struct {
+	line, end int
+	write     func(q *printer)
+}
+
+// list writes elements between open and close, the opening bracket being
+// at openLine in the source. They go one per line when the source has a
+// line break after the opening bracket or a comment among them, or when
+// they do not fit on the line. padded lists have spaces inside their
+// brackets when inline.
+func (p *printer) list(open, close string, openLine int, elements []element, padded bool) {
+	if len(elements) == 0 {
+		p.write(open + close)
+		return
+	}
+
+	inline := func(q *printer) {
+		q.write(open)
+		if padded {
+			q.write(" ")
+		}
+		for i, el := range elements {
+			if i > 0 {
+				q.write(", ")
+			}
+			el.write(q)
+		}
+		if padded {
+			q.write(" ")
+		}
+		q.write(close)
+	}
+
+	last := elements[len(elements)-1]
+	broken := elements[0].line > openLine ||
+		(p.hasCommentsBefore(last.end) && p.comments[p.next].Line >= openLine)
+	if !broken {
+		rendered := p.render(inline)
+		if i := strings.Index(rendered, "\n"); i >= 0 {
+			rendered = rendered[:i]
+		}
+		broken = p.column()+len(rendered) > lineWidth
+	}
+
+	if !broken {
+		inline(p)
+		return
+	}
+
+	p.write(open)
+	p.newline()
+	p.indent++
+
+	for i, el := range elements {
+		p.commentsBefore(el.line, false)
+		el.write(p)
+		if i < len(elements)-1 {
+			p.write(",")
+		}
+		p.trailingComments(el.end)
+		p.newline()
+	}
+
+	p.indent--
+	p.write(close)
+}
+
+// signature writes the parameters and return type of fn.
+func (p *printer) signature(fn *ast.FunctionLiteral) {
+	params := []string{}
+	for i, param := range fn.Parameters {
+		if i < len(fn.ParameterTypes) && fn.ParameterTypes[i] != nil {
+			params = append(params, param.Value+": "+fn.ParameterTypes[i].String())
+		} else {
+			params = append(params, param.Value)
+		}
+	}
+
+	p.write("(" + strings.Join(params, ", ") + ")")
+	if fn.ReturnType != nil {
+		p.write(": " + fn.ReturnType.String())
+	}
+}
+
+// functionLiteral writes fn, keeping a body written on one line there
+// when inline is set.
+func (p *printer) functionLiteral(fn *ast.FunctionLiteral, inline bool) {
+	p.write("fct")
+	p.signature(fn)
+	p.write(" ")
+
+	if inline && p.inlineBlock(fn.Body) {
+		return
+	}
+	p.block(fn.Body)
+}
+
+// ifExpression writes e, keeping it on one line when inline is set and it
+// was written on one line with a single expression in each branch.
+func (p *printer) ifExpression(e *ast.IfExpression, inline bool) {
+	head := func(q *printer) {
+		q.write("if (")
+		q.expression(e.Condition)
+		q.write(") ")
+	}
+
+	if inline {
+		oneLine := func(q *printer) bool {
+			head(q)
+			if !q.inlineBlock(e.Consequence) {
+				return false
+			}
+			if e.Alternative != nil {
+				q.write(" else ")
+				return q.inlineBlock(e.Alternative)
+			}
+			return true
+		}
+
+		fits := false
+		rendered := p.render(func(q *printer) { fits = oneLine(q) })
+		if fits && !strings.Contains(rendered, "\n") {
+			oneLine(p)
+			return
+		}
+	}
+
+	head(p)
+	p.block(e.Consequence)
+	if e.Alternative != nil {
+		p.write(" else ")
+		p.block(e.Alternative)
+	}
+}
@@ -0,0 +1,422 @@
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"zumbra/ast"
+	"zumbra/lexer"
+	"zumbra/parser"
+	"zumbra/token"
+)
+
+const (
+	indentation = "    "
+	// lineWidth is the column past which arrays, dicts and enums are
+	// broken one element per line.
+	lineWidth = 80
+)
+
+// Format returns src laid out in the canonical style: four-space
+// indentation, one statement per line, spaces around operators and `<<`,
+// and arrays, dicts and enums broken one element per line when they were
+// written that way or do not fit in lineWidth columns. Comments are kept,
+// and formatting formatted code changes nothing.
+func Format(src string) (string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{
+		lines:       strings.Split(src, "\n"),
+		comments:    l.Comments(),
+		atLineStart: true,
+		fresh:       true,
+	}
+	pr.statements(program.Statements)
+	pr.commentsBefore(math.MaxInt32, true)
+
+	out := strings.TrimRight(pr.buf.String(), "\n")
+	if out != "" {
+		out += "\n"
+	}
+
+	if line, ok := dropsCode(src, out); ok {
+		return "", fmt.Errorf("line %d: code skipped by the parser would be lost, is a semicolon missing?", line)
+	}
+
+	return out, nil
+}
+
+// dropsCode compares the tokens of src and out, apart from the
+// punctuation the formatter adds and removes, and returns the line of the
+// first source token missing from out. The parser skips the tokens after
+// a var statement up to the next semicolon, and those never reach out.
+func dropsCode(src, out string) (int, bool) {
+	want, got := significantTokens(src), significantTokens(out)
+
+	for i, tok := range want {
+		if i >= len(got) || got[i].Type != tok.Type || got[i].Literal != tok.Literal {
+			return tok.Line, true
+		}
+	}
+	return 0, false
+}
+
+func significantTokens(src string) []token.Token {
+	l := lexer.New(src)
+
+	tokens := []token.Token{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.SEMICOLON, token.LPAREN, token.RPAREN, token.COMMA:
+		default:
+			tokens = append(tokens, tok)
+		}
+	}
+	return tokens
+}
+
+// printer writes the canonical form of a program, placing each source
+// comment before the first statement or list element that follows it, or
+// after the one it trails.
+type printer struct {
+	buf         strings.Builder
+	indent      int
+	atLineStart bool
+
+	lines    []string // of the source
+	comments []lexer.Comment
+	next     int // index of the first comment not written yet
+
+	// lastLine is the source line of the last statement or comment
+	// written, and fresh is set at the start of a block, where no blank
+	// line is kept.
+	lastLine int
+	fresh    bool
+	// closing is the line of the brace closing the block being written,
+	// whose comments go after the brace rather than inside the block.
+	closing int
+
+	// A measuring printer lays out code only to see how it looks, and
+	// writes no comments. start is the column it starts writing at.
+	measuring bool
+	start     int
+}
+
+func (p *printer) write(s string) {
+	if s == "" {
+		return
+	}
+	if p.atLineStart {
+		p.buf.WriteString(strings.Repeat(indentation, p.indent))
+		p.atLineStart = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteString("\n")
+	p.atLineStart = true
+}
+
+// column is where the next write starts on the current line.
+func (p *printer) column() int {
+	if p.atLineStart {
+		return p.indent * len(indentation)
+	}
+
+	s := p.buf.String()
+	i := strings.LastIndex(s, "\n")
+	if i < 0 {
+		return p.start + len(s)
+	}
+	return len(s) - i - 1
+}
+
+// render returns what f writes when laying out code from the current
+// position, without writing anything.
+func (p *printer) render(f func(q *printer)) string {
+	q := &printer{
+		indent:    p.indent,
+		lines:     p.lines,
+		comments:  p.comments,
+		next:      p.next,
+		measuring: true,
+		start:     p.column(),
+	}
+	f(q)
+	return q.buf.String()
+}
+
+// commentsBefore writes the comments above line, each on a line of its
+// own, keeping blank lines between them when blank is set.
+func (p *printer) commentsBefore(line int, blank bool) {
+	if p.measuring {
+		return
+	}
+
+	for ; p.next < len(p.comments) && p.comments[p.next].Line < line; p.next++ {
+		c := p.comments[p.next]
+		if blank && p.blankBefore(c.Line) {
+			p.newline()
+		}
+		p.write(c.Text)
+		p.newline()
+
+		p.lastLine = c.Line
+		p.fresh = false
+	}
+}
+
+// trailingComments writes the comments left up to line. The first one
+// stays on the current line if it trailed code in the source.
+func (p *printer) trailingComments(line int) {
+	if p.measuring {
+		return
+	}
+
+	for first := true; p.next < len(p.comments) && p.comments[p.next].Line <= line; p.next++ {
+		c := p.comments[p.next]
+		if first && c.Trailing {
+			p.write(" ")
+		} else {
+			p.newline()
+		}
+		p.write(c.Text)
+
+		first = false
+		p.lastLine = c.Line
+	}
+}
+
+func (p *printer) hasCommentsBefore(line int) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Line < line
+}
+
+// blankBefore reports whether the source has a blank line between the
+// last thing written and line.
+func (p *printer) blankBefore(line int) bool {
+	if p.fresh {
+		return false
+	}
+
+	for l := p.lastLine + 1; l < line && l <= len(p.lines); l++ {
+		if l >= 1 && strings.TrimSpace(p.lines[l-1]) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) statements(statements []ast.Statement) {
+	for i, s := range statements {
+		line := ast.Start(s).Line
+		p.commentsBefore(line, true)
+		if p.blankBefore(line) {
+			p.newline()
+		}
+
+		var next ast.Statement
+		if i+1 < len(statements) {
+			next = statements[i+1]
+		}
+		p.statement(s, next)
+
+		end := endLine(s)
+		p.lastLine = end
+		if p.closing > 0 && end >= p.closing {
+			p.trailingComments(p.closing - 1)
+		} else {
+			p.trailingComments(end)
+		}
+		p.newline()
+		p.fresh = false
+	}
+}
+
+// statement writes s. next is the statement after it, if any.
+func (p *printer) statement(s ast.Statement, next ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		p.expressionStatement(s, next)
+
+	case *ast.VarStatement:
+		p.write("var " + s.Name.Value)
+		if s.Type != nil {
+			p.write(": " + s.Type.String())
+		}
+		p.write(" << ")
+		p.expression(s.Value)
+		p.write(";")
+
+	case *ast.AssignStatement:
+		p.write(s.Name.Value + " << ")
+		p.expression(s.Value)
+		p.write(";")
+
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expression(s.ReturnValue)
+		p.write(";")
+
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value)
+		p.write(";")
+
+	case *ast.YieldStatement:
+		p.write("yield ")
+		p.expression(s.Value)
+		p.write(";")
+
+	case *ast.FunctionStatement:
+		p.write("fct " + s.Name.Value)
+		p.signature(s.Function)
+		p.write(" ")
+		p.block(s.Function.Body)
+
+	case *ast.ExportStatement:
+		p.write("export ")
+		p.statement(s.Statement, next)
+
+	case *ast.EnumStatement:
+		p.write("enum " + s.Name.Value + " ")
+		elements := []element{}
+		for _, m := range s.Members {
+			name := m.Value
+			elements = append(elements, element{
+				line:  m.Token.Line,
+				end:   m.Token.Line,
+				write: func(q *printer) { q.write(name) },
+			})
+		}
+		p.list("{", "}", s.Name.Token.Line, elements, true)
+
+	case *ast.ImportStatement:
+		p.write("import \"" + s.Path.Value + "\"")
+		if s.Alias != nil {
+			p.write(" as " + s.Alias.Value)
+		}
+		p.write(";")
+
+	case *ast.BlockStatement:
+		p.block(s)
+
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(s.Condition)
+		p.write(") ")
+		p.block(s.Body)
+
+	case *ast.ForStatement:
+		p.write("for (" + s.Name.Value + " in ")
+		p.expression(s.Iterable)
+		p.write(") ")
+		p.block(s.Body)
+
+	case *ast.TryStatement:
+		p.write("try ")
+		p.block(s.Block)
+		if s.Catch != nil {
+			p.write(" catch (" + s.Param.Value + ") ")
+			p.block(s.Catch)
+		}
+		if s.Finally != nil {
+			p.write(" finally ")
+			p.block(s.Finally)
+		}
+	}
+}
+
+// expressionStatement writes an expression on a line of its own. Ifs and
+// functions are laid out over several lines and need no semicolon, unless
+// the next statement would otherwise be read as continuing them, as in
+// calling or indexing them.
+func (p *printer) expressionStatement(s *ast.ExpressionStatement, next ast.Statement) {
+	switch e := s.Expression.(type) {
+	case *ast.IfExpression:
+		p.ifExpression(e, false)
+	case *ast.FunctionLiteral:
+		p.functionLiteral(e, false)
+	default:
+		p.expression(e)
+		p.write(";")
+		return
+	}
+
+	if next == nil {
+		return
+	}
+
+	continuation := p.render(func(q *printer) { q.statement(next, nil) })
+	if continuation != "" && strings.ContainsAny(continuation[:1], "([-") {
+		p.write(";")
+	}
+}
+
+// block writes b with its statements indented on lines of their own.
+func (p *printer) block(b *ast.BlockStatement) {
+	if len(b.Statements) == 0 && !p.hasCommentsBefore(b.End.Line) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.newline()
+	p.indent++
+
+	p.fresh = true
+	p.lastLine = b.Token.Line
+	closing := p.closing
+	p.closing = b.End.Line
+	p.statements(b.Statements)
+	p.commentsBefore(b.End.Line, true)
+	p.closing = closing
+
+	p.indent--
+	p.write("}")
+	p.lastLine = b.End.Line
+}
+
+// inlineBlock writes b on the current line if it was written on a single
+// line and holds at most one expression, reporting whether it did.
+func (p *printer) inlineBlock(b *ast.BlockStatement) bool {
+	if b.Token.Line != b.End.Line || len(b.Statements) > 1 {
+		return false
+	}
+
+	if len(b.Statements) == 0 {
+		p.write("{}")
+		return true
+	}
+
+	var write func(q *printer)
+	switch s := b.Statements[0].(type) {
+	case *ast.ExpressionStatement:
+		write = func(q *printer) { q.expression(s.Expression) }
+	case *ast.ReturnStatement:
+		write = func(q *printer) {
+			q.write("return ")
+			q.expression(s.ReturnValue)
+		}
+	default:
+		return false
+	}
+
+	inline := func(q *printer) {
+		q.write("{ ")
+		write(q)
+		q.write(" }")
+	}
+	if strings.Contains(p.render(inline), "\n") {
+		return false
+	}
+
+	inline(p)
+	return true
+}
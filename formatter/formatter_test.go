@@ -0,0 +1,139 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"zumbra/lexer"
+	"zumbra/token"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x<<1", "var x << 1;\n"},
+		{"var total: int<<0;total<<total+1;", "var total: int << 0;\ntotal << total + 1;\n"},
+		{"show((1 + 2) * 3); show(1 + (2 * 3)); show(1 - (2 - 3)); show((1 - 2) - 3);",
+			"show((1 + 2) * 3);\nshow(1 + 2 * 3);\nshow(1 - (2 - 3));\nshow(1 - 2 - 3);\n"},
+		{"show(-(-x)); show(!(a == b)); show((-a).b); show(-a.b);",
+			"show(-(-x));\nshow(!(a == b));\nshow((-a).b);\nshow(-a.b);\n"},
+		{"show(a and (b or c)); show((a <= b) + 1);", "show(a and (b or c));\nshow((a <= b) + 1);\n"},
+		{`var d << {"b": 2, "a": 1};`, "var d << {\"b\": 2, \"a\": 1};\n"},
+		{"var xs << [\n1, 2];", "var xs << [\n    1,\n    2\n];\n"},
+		{"var xs << [1111111111, 2222222222, 3333333333, 4444444444, 5555555555, 6666666666, 77777];",
+			"var xs << [\n    1111111111,\n    2222222222,\n    3333333333,\n    4444444444,\n    5555555555,\n    6666666666,\n    77777\n];\n"},
+		{"enum Color {Red,Green};", "enum Color { Red, Green }\n"},
+		{"fct add(a:int,b:int):int{return a+b;}", "fct add(a: int, b: int): int {\n    return a + b;\n}\n"},
+		{"var f << fct(x) { x * 2 };", "var f << fct(x) { x * 2 };\n"},
+		{"var f << fct(x) {\nx * 2 };", "var f << fct(x) {\n    x * 2;\n};\n"},
+		{"var y << if (x) { 1 } else { 2 };", "var y << if (x) { 1 } else { 2 };\n"},
+		{"if (x) { 1 } else { 2 }", "if (x) {\n    1;\n} else {\n    2;\n}\n"},
+		{"while (i < 3) { i << i + 1; }", "while (i < 3) {\n    i << i + 1;\n}\n"},
+		{"for (x in xs) { show(x) }", "for (x in xs) {\n    show(x);\n}\n"},
+		{"try { f() } catch (e) { show(e) } finally { g() }",
+			"try {\n    f();\n} catch (e) {\n    show(e);\n} finally {\n    g();\n}\n"},
+		{`import "lib.zum" as lib; export var x << lib.x;`, "import \"lib.zum\" as lib;\nexport var x << lib.x;\n"},
+		{"while (x) {}", "while (x) {}\n"},
+		{"if (x) { 1 }; (a + b) * 2;", "if (x) {\n    1;\n};\n(a + b) * 2;\n"},
+		{"if (x) { 1 } show(a);", "if (x) {\n    1;\n}\nshow(a);\n"},
+
+		{"// header\n\n\nvar x << 1;   // one\n\n// two\nshow(x);\n// end\n",
+			"// header\n\nvar x << 1; // one\n\n// two\nshow(x);\n// end\n"},
+		{"if (x) { // why\n  show(1);\n  // done\n}", "if (x) {\n    // why\n    show(1);\n    // done\n}\n"},
+		{"if (x) { show(1); } // c\nshow(2);", "if (x) {\n    show(1);\n} // c\nshow(2);\n"},
+		{"while (x) {\n  show(1);\n} // w", "while (x) {\n    show(1);\n} // w\n"},
+		{"for (x in xs) { show(x) } // f", "for (x in xs) {\n    show(x);\n} // f\n"},
+		{"var d << {\n\"a\": 1, // one\n// two\n\"b\": 2\n};", "var d << {\n    \"a\": 1, // one\n    // two\n    \"b\": 2\n};\n"},
+		{"fct f() {\n\n  show(1);\n\n\n  show(2);\n\n}", "fct f() {\n    show(1);\n\n    show(2);\n}\n"},
+		{"", ""},
+		{"// only a comment", "// only a comment\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := Format(tt.input)
+		if err != nil {
+			t.Errorf("Format(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("wrong format for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+
+		again, _ := Format(got)
+		if again != got {
+			t.Errorf("format of %q is not idempotent.\nfirst=%q\nsecond=%q", tt.input, got, again)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	if _, err := Format("var << 1;"); err == nil {
+		t.Error("expected an error for a program that does not parse")
+	}
+
+	_, err := Format("var x << 1\nshow(x);")
+	expected := "line 2: code skipped by the parser would be lost, is a semicolon missing?"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected an error for code the parser skips. got=%v", err)
+	}
+}
+
+// TestFormatExamples formats every example program and checks the result
+// formats to itself and keeps every comment. Format itself fails if a
+// token is lost.
+func TestFormatExamples(t *testing.T) {
+	files, err := filepath.Glob("../code_examples/*/*.zum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"../code_examples/*.zum", "../resolver/std/*.zum"} {
+		more, _ := filepath.Glob(pattern)
+		files = append(files, more...)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := string(data)
+
+		formatted, err := Format(src)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+
+		again, err := Format(formatted)
+		if err != nil {
+			t.Errorf("%s: formatted code does not parse: %s\n%s", file, err, formatted)
+			continue
+		}
+		if again != formatted {
+			t.Errorf("%s: format is not idempotent.\nfirst:\n%s\nsecond:\n%s", file, formatted, again)
+		}
+
+		if want, got := comments(src), comments(formatted); strings.Join(want, "\n") != strings.Join(got, "\n") {
+			t.Errorf("%s: comments changed.\nwant=%q\ngot=%q", file, want, got)
+		}
+	}
+}
+
+func comments(src string) []string {
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	texts := []string{}
+	for _, c := range l.Comments() {
+		texts = append(texts, c.Text)
+	}
+	return texts
+}
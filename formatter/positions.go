@@ -0,0 +1,25 @@
+package formatter
+
+import (
+	"zumbra/ast"
+)
+
+// endLine returns the last source line holding a token of node. Closing
+// parentheses and brackets are not kept in the tree, so a node ending
+// with one on a line of its own is taken to end on the line before.
+func endLine(node ast.Node) int {
+	line := 0
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		line = max(line, ast.Start(n).Line)
+		if b, ok := n.(*ast.BlockStatement); ok {
+			line = max(line, b.End.Line)
+		}
+		return true
+	})
+
+	return line
+}
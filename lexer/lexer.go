@@ -10,15 +10,35 @@ type Lexer struct {
 	position     int
 	readPosition int
 	ch           byte
+
+	line, column       int
+	tokLine, tokColumn int
+	lastTokenLine      int
+
+	comments []Comment
+}
+
+// Comment is a `//` comment skipped over by the lexer.
+type Comment struct {
+	Text         string
+	Line, Column int
+	// Trailing is set when the comment follows a token on the same line.
+	Trailing bool
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -29,9 +49,22 @@ func (l *Lexer) readChar() {
 }
 
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	tok.Line, tok.Column = l.tokLine, l.tokColumn
+	l.lastTokenLine = tok.Line
+	return tok
+}
+
+// Comments returns the comments read so far, in order.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	l.tokLine, l.tokColumn = l.line, l.column
 
 	switch l.ch {
 	case '.':
@@ -83,11 +116,18 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '/':
 		if l.peekChar() == '/' {
+			start := l.position
 			l.readChar()
 			for l.ch != '\n' && l.ch != 0 {
 				l.readChar()
 			}
-			return l.NextToken()
+			l.comments = append(l.comments, Comment{
+				Text:     strings.TrimRight(l.input[start:l.position], " \t\r"),
+				Line:     l.tokLine,
+				Column:   l.tokColumn,
+				Trailing: l.tokLine == l.lastTokenLine,
+			})
+			return l.nextToken()
 		} else {
 			tok = newToken(token.SLASH, l.ch)
 		}
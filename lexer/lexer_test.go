@@ -125,3 +125,69 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `var x << 10;
+// comment
+  show(x);`
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"var", 1, 1},
+		{"x", 1, 5},
+		{"<<", 1, 7},
+		{"10", 1, 10},
+		{";", 1, 12},
+		{"show", 3, 3},
+		{"(", 3, 7},
+		{"x", 3, 8},
+		{")", 3, 9},
+		{";", 3, 10},
+		{"", 3, 11},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - position of %q wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLiteral, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+var x << 1; // one
+show(x);//two   `
+
+	l := New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	expected := []Comment{
+		{Text: "// header", Line: 1, Column: 1, Trailing: false},
+		{Text: "// one", Line: 2, Column: 13, Trailing: true},
+		{Text: "//two", Line: 3, Column: 9, Trailing: true},
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expected) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(expected), len(comments))
+	}
+
+	for i, c := range comments {
+		if c != expected[i] {
+			t.Errorf("comments[%d] wrong. expected=%+v, got=%+v", i, expected[i], c)
+		}
+	}
+}
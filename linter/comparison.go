@@ -0,0 +1,109 @@
+package linter
+
+import (
+	"zumbra/ast"
+)
+
+var comparisons = map[string]bool{
+	"==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true,
+}
+
+// lintComparison reports comparisons whose result is known without
+// running the program: those between two literals, and those of a
+// variable with itself.
+func (l *Linter) lintComparison(e *ast.InfixExpression) {
+	if !comparisons[e.Operator] {
+		return
+	}
+
+	result, ok := constantComparison(e)
+	if !ok {
+		return
+	}
+
+	l.report(ConstantComparison, e.Token, "comparison %s is always %t", e.String(), result)
+}
+
+func constantComparison(e *ast.InfixExpression) (bool, bool) {
+	if left, ok := e.Left.(*ast.Identifier); ok {
+		if right, ok := e.Right.(*ast.Identifier); ok && left.Value == right.Value {
+			return compare(e.Operator, 0), true
+		}
+		return false, false
+	}
+
+	switch left := e.Left.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral:
+		l, _ := number(left)
+		r, ok := number(e.Right)
+		if !ok {
+			return false, false
+		}
+		return compare(e.Operator, order(l < r, l > r)), true
+
+	case *ast.StringLiteral:
+		right, ok := e.Right.(*ast.StringLiteral)
+		if !ok || !equality(e.Operator) {
+			return false, false
+		}
+		return compare(e.Operator, order(left.Value < right.Value, left.Value > right.Value)), true
+
+	case *ast.Boolean:
+		right, ok := e.Right.(*ast.Boolean)
+		if !ok || !equality(e.Operator) {
+			return false, false
+		}
+		if left.Value == right.Value {
+			return compare(e.Operator, 0), true
+		}
+		return compare(e.Operator, 1), true
+	}
+
+	return false, false
+}
+
+// equality reports whether operator is == or !=, the only comparisons
+// defined on strings and booleans.
+func equality(operator string) bool {
+	return operator == "==" || operator == "!="
+}
+
+func number(e ast.Expression) (float64, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return float64(e.Value), true
+	case *ast.FloatLiteral:
+		return e.Value, true
+	default:
+		return 0, false
+	}
+}
+
+func order(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compare applies operator to two values whose ordering is cmp.
+func compare(operator string, cmp int) bool {
+	switch operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp >= 0
+	}
+}
@@ -0,0 +1,375 @@
+package linter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"zumbra/ast"
+	"zumbra/compiler"
+	"zumbra/lexer"
+	"zumbra/object/builtins"
+	"zumbra/parser"
+	"zumbra/resolver"
+	"zumbra/token"
+)
+
+// Linter walks a program resolving names the way the compiler does, with
+// a compiler.SymbolTable per function, and reports code that is legal but
+// most likely wrong.
+type Linter struct {
+	config      Config
+	diagnostics []Diagnostic
+	table       *compiler.SymbolTable
+	// declarations holds, for each symbol table, the declaration each of
+	// its names currently refers to.
+	declarations map[*compiler.SymbolTable]map[string]*declaration
+	// scopes holds every declaration made in each symbol table, in order,
+	// including the ones redeclared later.
+	scopes   map[*compiler.SymbolTable][]*declaration
+	exported map[string]bool // names of the top-level exports
+	resolver *resolver.Resolver
+	dir      string
+	imported map[string]bool
+}
+
+type declaration struct {
+	name *ast.Identifier
+	kind string
+	used bool
+	// silent declarations, like loop variables and imported names, are
+	// never reported as unused or redeclared.
+	silent bool
+}
+
+const (
+	variableKind  = "variable"
+	parameterKind = "parameter"
+	functionKind  = "function"
+)
+
+// Lint returns the diagnostics of the rules enabled in config, sorted by
+// position. Flat imports are resolved from dir so the names they declare
+// are known.
+func Lint(program *ast.Program, dir string, config Config) []Diagnostic {
+	table := compiler.NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		table.DefineBuiltin(i, v.Name)
+	}
+
+	l := &Linter{
+		config:       config,
+		table:        table,
+		declarations: map[*compiler.SymbolTable]map[string]*declaration{},
+		scopes:       map[*compiler.SymbolTable][]*declaration{},
+		exported:     map[string]bool{},
+		resolver:     resolver.New(dir),
+		dir:          dir,
+		imported:     map[string]bool{},
+	}
+
+	// Names marked with export are used by the importers. Without markers
+	// the program is taken to be a script, and all its names are checked.
+	for _, s := range program.Statements {
+		if _, ok := s.(*ast.ExportStatement); ok {
+			for _, name := range program.Exports() {
+				l.exported[name] = true
+			}
+			break
+		}
+	}
+
+	l.lintStatements(program.Statements)
+	l.reportUnused(table, true)
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return l.diagnostics
+}
+
+func (l *Linter) report(rule string, tok token.Token, format string, a ...interface{}) {
+	if !l.config.enabled(rule) {
+		return
+	}
+
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, a...),
+		Line:    tok.Line,
+		Column:  tok.Column,
+	})
+}
+
+// declare defines name in the current symbol table, reporting the
+// builtin it hides and any earlier declaration of it in the same scope.
+func (l *Linter) declare(name *ast.Identifier, kind string, silent bool) {
+	if symbol, ok := l.table.Resolve(name.Value); ok && symbol.Scope == compiler.BuiltinScope {
+		l.report(ShadowedBuiltin, name.Token, "%s %s shadows the builtin %s", kind, name.Value, name.Value)
+	}
+
+	names := l.declarations[l.table]
+	if names == nil {
+		names = map[string]*declaration{}
+		l.declarations[l.table] = names
+	}
+
+	if previous, ok := names[name.Value]; ok && !previous.silent && !silent {
+		l.report(Redeclaration, name.Token, "%s is already declared in this scope", name.Value)
+	}
+
+	d := &declaration{name: name, kind: kind, silent: silent}
+	names[name.Value] = d
+	l.scopes[l.table] = append(l.scopes[l.table], d)
+	l.table.Define(name.Value)
+}
+
+// use marks the declaration name resolves to as used. Free symbols are
+// followed out to the function that declares them.
+func (l *Linter) use(name string) {
+	symbol, ok := l.table.Resolve(name)
+	if !ok {
+		return
+	}
+
+	table := l.table
+	for symbol.Scope == compiler.FreeScope {
+		symbol = table.FreeSymbols[symbol.Index]
+		table = table.Outer
+	}
+
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		for table.Outer != nil {
+			table = table.Outer
+		}
+	case compiler.LocalScope:
+	default:
+		return
+	}
+
+	if d, ok := l.declarations[table][name]; ok {
+		d.used = true
+	}
+}
+
+func (l *Linter) reportUnused(table *compiler.SymbolTable, global bool) {
+	for _, d := range l.scopes[table] {
+		if d.used || d.silent || strings.HasPrefix(d.name.Value, "_") {
+			continue
+		}
+		if global && l.exported[d.name.Value] {
+			continue
+		}
+
+		if d.kind == parameterKind {
+			l.report(UnusedParameter, d.name.Token, "parameter %s is never used", d.name.Value)
+		} else {
+			l.report(UnusedVariable, d.name.Token, "%s %s is declared but never used", d.kind, d.name.Value)
+		}
+	}
+}
+
+func (l *Linter) lintStatements(statements []ast.Statement) {
+	// Function declarations are hoisted, as they are when compiling.
+	for _, s := range statements {
+		if es, ok := s.(*ast.ExportStatement); ok {
+			s = es.Statement
+		}
+		if fs, ok := s.(*ast.FunctionStatement); ok {
+			l.declare(fs.Name, functionKind, false)
+		}
+	}
+
+	reachable := true
+	for _, s := range statements {
+		if !reachable {
+			l.report(UnreachableCode, ast.Start(s), "unreachable code")
+			reachable = true
+		}
+
+		l.lintStatement(s)
+
+		switch s.(type) {
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			reachable = false
+		}
+	}
+}
+
+func (l *Linter) lintStatement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		l.lintExpression(s.Expression)
+
+	case *ast.VarStatement:
+		// A function can call itself through the variable it is assigned
+		// to, so the name is declared first.
+		if _, ok := s.Value.(*ast.FunctionLiteral); ok {
+			l.declare(s.Name, variableKind, false)
+			l.lintExpression(s.Value)
+			return
+		}
+		l.lintExpression(s.Value)
+		l.declare(s.Name, variableKind, false)
+
+	case *ast.FunctionStatement:
+		l.lintFunction(s.Function)
+
+	case *ast.AssignStatement:
+		l.lintExpression(s.Value)
+
+		symbol, ok := l.table.Resolve(s.Name.Value)
+		switch {
+		case !ok:
+			l.report(UndeclaredAssignment, s.Name.Token, "assignment to undeclared variable %s", s.Name.Value)
+		case symbol.Scope == compiler.BuiltinScope:
+			l.report(UndeclaredAssignment, s.Name.Token, "assignment to builtin %s", s.Name.Value)
+		}
+
+	case *ast.ReturnStatement:
+		l.lintExpression(s.ReturnValue)
+
+	case *ast.ThrowStatement:
+		l.lintExpression(s.Value)
+
+	case *ast.YieldStatement:
+		l.lintExpression(s.Value)
+
+	case *ast.ExportStatement:
+		l.lintStatement(s.Statement)
+
+	case *ast.EnumStatement:
+		l.declare(s.Name, variableKind, false)
+
+	case *ast.ImportStatement:
+		if s.Alias != nil {
+			l.declare(s.Alias, variableKind, false)
+		} else {
+			l.declareImported(s.Path.Value, l.dir)
+		}
+
+	case *ast.BlockStatement:
+		l.lintStatements(s.Statements)
+
+	case *ast.WhileStatement:
+		l.lintExpression(s.Condition)
+		l.lintStatements(s.Body.Statements)
+
+	case *ast.ForStatement:
+		l.lintExpression(s.Iterable)
+		l.declare(s.Name, variableKind, true)
+		l.lintStatements(s.Body.Statements)
+
+	case *ast.TryStatement:
+		l.lintStatements(s.Block.Statements)
+		if s.Catch != nil {
+			l.declare(s.Param, variableKind, true)
+			l.lintStatements(s.Catch.Statements)
+		}
+		if s.Finally != nil {
+			l.lintStatements(s.Finally.Statements)
+		}
+	}
+}
+
+func (l *Linter) lintExpression(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		l.use(e.Value)
+
+	case *ast.FunctionLiteral:
+		l.lintFunction(e)
+
+	case *ast.InfixExpression:
+		l.lintExpression(e.Left)
+		l.lintExpression(e.Right)
+		l.lintComparison(e)
+
+	case *ast.PrefixExpression:
+		l.lintExpression(e.Right)
+
+	case *ast.IfExpression:
+		l.lintExpression(e.Condition)
+		l.lintStatements(e.Consequence.Statements)
+		if e.Alternative != nil {
+			l.lintStatements(e.Alternative.Statements)
+		}
+
+	case *ast.CallExpression:
+		l.lintExpression(e.Function)
+		for _, arg := range e.Arguments {
+			l.lintExpression(arg)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			l.lintExpression(el)
+		}
+
+	case *ast.DictLiteral:
+		for k, v := range e.Pairs {
+			l.lintExpression(k)
+			l.lintExpression(v)
+		}
+
+	case *ast.IndexExpression:
+		l.lintExpression(e.Left)
+		l.lintExpression(e.Index)
+
+	case *ast.AttributeAccess:
+		l.lintExpression(e.Object)
+	}
+}
+
+func (l *Linter) lintFunction(fn *ast.FunctionLiteral) {
+	l.table = compiler.NewEnclosedSymbolTable(l.table)
+
+	if fn.Name != "" {
+		l.table.DefineFunctionName(fn.Name)
+	}
+	for _, p := range fn.Parameters {
+		l.declare(p, parameterKind, false)
+	}
+
+	l.lintStatements(fn.Body.Statements)
+	l.reportUnused(l.table, false)
+
+	l.table = l.table.Outer
+}
+
+// declareImported declares the names a flatly imported file, and the
+// files it imports in turn, add to the program. The files themselves are
+// linted on their own.
+func (l *Linter) declareImported(path, dir string) {
+	src, err := l.resolver.Resolve(path, dir)
+	if err != nil || l.imported[src.Name] {
+		return
+	}
+	l.imported[src.Name] = true
+
+	program := parser.New(lexer.New(src.Content)).ParseProgram()
+
+	for _, s := range program.Statements {
+		if es, ok := s.(*ast.ExportStatement); ok {
+			s = es.Statement
+		}
+
+		switch s := s.(type) {
+		case *ast.VarStatement:
+			l.declare(s.Name, variableKind, true)
+		case *ast.FunctionStatement:
+			l.declare(s.Name, functionKind, true)
+		case *ast.EnumStatement:
+			l.declare(s.Name, variableKind, true)
+		case *ast.ImportStatement:
+			if s.Alias == nil {
+				l.declareImported(s.Path.Value, src.Dir)
+			}
+		}
+	}
+}
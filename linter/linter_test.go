@@ -0,0 +1,168 @@
+package linter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"zumbra/lexer"
+	"zumbra/parser"
+)
+
+func lint(t *testing.T, input, dir string, disabled ...string) []Diagnostic {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	config, err := NewConfig(disabled...)
+	if err != nil {
+		t.Fatalf("NewConfig: %s", err)
+	}
+
+	return Lint(program, dir, config)
+}
+
+func messages(diagnostics []Diagnostic) []string {
+	result := []string{}
+	for _, d := range diagnostics {
+		result = append(result, d.Rule+": "+d.Message)
+	}
+	return result
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`var x << 1; show(x);`, nil},
+		{`var x << 1;`, []string{"unused-variable: variable x is declared but never used"}},
+		{`var _x << 1;`, nil},
+		{`var x << 1; x << 2;`, []string{"unused-variable: variable x is declared but never used"}},
+		{`var x << 1; x << x + 1;`, nil},
+		{`fct helper() { 1 }`, []string{"unused-variable: function helper is declared but never used"}},
+		{`fct countdown(n) { countdown(n - 1) }`, []string{"unused-variable: function countdown is declared but never used"}},
+		{`show(square(2)); fct square(x) { x * x }`, nil},
+		{`export var x << 1; var y << 2;`, []string{"unused-variable: variable y is declared but never used"}},
+		{`enum Color { Red } show(Color.Red);`, nil},
+
+		{`var f << fct(a, b) { a }; f(1, 2);`, []string{"unused-parameter: parameter b is never used"}},
+		{`var f << fct(a, _b) { a }; f(1, 2);`, nil},
+		{`var f << fct() { var y << 1; }; f();`, []string{"unused-variable: variable y is declared but never used"}},
+		{`var counter << fct() { var n << 0; fct() { n << n + 1; n } }; counter();`, nil},
+		{`var outer << fct(x) { fct() { fct() { x } } }; outer(1);`, nil},
+		{`for (item in [1, 2]) { show(1); }`, nil},
+		{`try { throw "x"; } catch (err) { show(1); }`, nil},
+
+		{`var sum << 0; show(sum);`, []string{"shadowed-builtin: variable sum shadows the builtin sum"}},
+		{`var f << fct(first) { first }; f(1);`, []string{"shadowed-builtin: parameter first shadows the builtin first"}},
+		{`fct max(a) { a } max(1);`, []string{"shadowed-builtin: function max shadows the builtin max"}},
+		{`var f << fct() { return 1; show(2); }; f();`, []string{"unreachable-code: unreachable code"}},
+		{`var f << fct() { throw "x"; show(1); show(2); }; f();`, []string{"unreachable-code: unreachable code"}},
+		{`var f << fct(x) { if (x) { return 1; } return 2; }; f(1);`, nil},
+
+		{`total << 1;`, []string{"undeclared-assignment: assignment to undeclared variable total"}},
+		{`sum << 1;`, []string{"undeclared-assignment: assignment to builtin sum"}},
+		{`var f << fct() { total << 1; }; f(); var total << 0; show(total);`, []string{"undeclared-assignment: assignment to undeclared variable total"}},
+
+		{`if (1 == 1) { show(1); }`, []string{"constant-comparison: comparison (1 == 1) is always true"}},
+		{`if (2 < 1.5) { show(1); }`, []string{"constant-comparison: comparison (2 < 1.5) is always false"}},
+		{`show("a" != "b");`, []string{"constant-comparison: comparison (a != b) is always true"}},
+		{`show(true == false);`, []string{"constant-comparison: comparison (true == false) is always false"}},
+		{`var x << 1; show(x == x);`, []string{"constant-comparison: comparison (x == x) is always true"}},
+		{`var x << 1; show(x >= 1);`, nil},
+		{`show(1 + 1);`, nil},
+
+		{`var x << 1; var x << 2; show(x);`, []string{
+			"unused-variable: variable x is declared but never used",
+			"redeclaration: x is already declared in this scope",
+		}},
+		{`var x << 1; var f << fct() { var x << 2; x }; show(x); f();`, nil},
+		{`var f << fct(a) { var a << 1; a }; f(1);`, []string{
+			"unused-parameter: parameter a is never used",
+			"redeclaration: a is already declared in this scope",
+		}},
+		{`for (i in [1]) { show(i); } for (i in [2]) { show(i); }`, nil},
+	}
+
+	for _, tt := range tests {
+		got := messages(lint(t, tt.input, "."))
+		if len(got) != len(tt.expected) {
+			t.Errorf("wrong diagnostics for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("wrong diagnostic %d for %q.\nwant=%q\ngot=%q", i, tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestLintPositions(t *testing.T) {
+	input := `var f << fct(a) {
+  return 1;
+  show(2);
+};
+f(1);`
+
+	diagnostics := lint(t, input, ".")
+
+	expected := []Diagnostic{
+		{Rule: UnusedParameter, Message: "parameter a is never used", Line: 1, Column: 14},
+		{Rule: UnreachableCode, Message: "unreachable code", Line: 3, Column: 3},
+	}
+
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%v, got=%v", expected, diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d != expected[i] {
+			t.Errorf("wrong diagnostic %d. want=%v, got=%v", i, expected[i], d)
+		}
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	input := `var sum << 0; total << 1;`
+
+	got := messages(lint(t, input, ".", ShadowedBuiltin, UnusedVariable))
+	if len(got) != 1 || got[0] != "undeclared-assignment: assignment to undeclared variable total" {
+		t.Errorf("wrong diagnostics with rules disabled. got=%q", got)
+	}
+
+	if _, err := NewConfig("no-such-rule"); err == nil || err.Error() != "unknown lint rule no-such-rule" {
+		t.Errorf("expected an error for an unknown rule. got=%v", err)
+	}
+}
+
+func TestLintFlatImports(t *testing.T) {
+	dir := t.TempDir()
+	lib := `var factor << 2; fct twice(x) { x * factor }`
+	if err := os.WriteFile(filepath.Join(dir, "lib.zum"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := messages(lint(t, `import "lib.zum"; factor << 3; show(twice(1));`, dir))
+	if len(got) != 0 {
+		t.Errorf("expected no diagnostics for imported names. got=%q", got)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	d := Diagnostic{Rule: UnusedVariable, Message: "variable x is declared but never used", Line: 1, Column: 5}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"rule":"unused-variable","message":"variable x is declared but never used","line":1,"column":5}`
+	if string(data) != expected {
+		t.Errorf("wrong JSON. want=%s, got=%s", expected, data)
+	}
+}
@@ -0,0 +1,77 @@
+package linter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	UnusedVariable       = "unused-variable"
+	UnusedParameter      = "unused-parameter"
+	ShadowedBuiltin      = "shadowed-builtin"
+	UnreachableCode      = "unreachable-code"
+	UndeclaredAssignment = "undeclared-assignment"
+	ConstantComparison   = "constant-comparison"
+	Redeclaration        = "redeclaration"
+)
+
+// Rules lists every rule the linter knows, all enabled by default.
+var Rules = []string{
+	UnusedVariable,
+	UnusedParameter,
+	ShadowedBuiltin,
+	UnreachableCode,
+	UndeclaredAssignment,
+	ConstantComparison,
+	Redeclaration,
+}
+
+// Diagnostic is a problem found by a rule. Line and Column locate the
+// token it was found at, and are zero when the position is unknown.
+type Diagnostic struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", d.Line, d.Column, d.Message, d.Rule)
+}
+
+// Config selects the rules that are reported.
+type Config struct {
+	Disabled map[string]bool
+}
+
+// NewConfig returns a Config with every rule enabled except the disabled
+// ones.
+func NewConfig(disabled ...string) (Config, error) {
+	config := Config{Disabled: map[string]bool{}}
+
+	for _, rule := range disabled {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if !known(rule) {
+			return config, fmt.Errorf("unknown lint rule %s", rule)
+		}
+		config.Disabled[rule] = true
+	}
+
+	return config, nil
+}
+
+func (c Config) enabled(rule string) bool {
+	return !c.Disabled[rule]
+}
+
+func known(rule string) bool {
+	for _, r := range Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
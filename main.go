@@ -1,14 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"zumbra/ast"
+	"zumbra/checker"
 	"zumbra/compiler"
+	"zumbra/formatter"
 	"zumbra/lexer"
+	"zumbra/linter"
 	"zumbra/object"
 	"zumbra/object/builtins"
 	"zumbra/parser"
@@ -22,32 +33,442 @@ func main() {
 		panic(err)
 	}
 
-	if len(os.Args) > 1 {
-		runFile(os.Args[1])
+	opts := runOptions{}
+	flag.BoolVar(&opts.deterministic, "deterministic", false, "executa as tarefas sempre na mesma ordem")
+	flag.BoolVar(&opts.optimize, "optimize", true, "otimiza o bytecode antes de executar")
+	flag.StringVar(&opts.importRoot, "import-root", "", "só permite importar arquivos dentro deste diretório")
+	allowImports := flag.String("allow-imports", "", "diretórios extras permitidos com --import-root, separados por "+string(os.PathListSeparator))
+	flag.DurationVar(&opts.timeout, "timeout", 0, "interrompe a execução depois deste tempo (ex.: 2s)")
+	maxSteps := flag.Float64("max-steps", 0, "número máximo de instruções executadas (ex.: 1e7)")
+	flag.IntVar(&opts.limits.MaxDepth, "max-depth", 0, "número máximo de chamadas aninhadas")
+	maxAllocation := flag.Float64("max-alloc", 0, "limite aproximado de bytes alocados (ex.: 1e8)")
+	sandbox := flag.String("sandbox", "off", "restringe os builtins que o programa usa: off, playground ou strict")
+	flag.Parse()
+
+	policy, ok := builtins.Policies[*sandbox]
+	if !ok {
+		fmt.Printf("Sandbox desconhecido: %s (use off, playground ou strict)\n", *sandbox)
+		os.Exit(2)
+	}
+	opts.policy = policy
+
+	if *allowImports != "" {
+		opts.allowImports = filepath.SplitList(*allowImports)
+	}
+	opts.limits.MaxSteps = int64(*maxSteps)
+	opts.limits.MaxAllocation = int64(*maxAllocation)
+
+	if flag.Arg(0) == "check" && flag.NArg() == 2 {
+		checkFile(flag.Arg(1))
+		return
+	}
+
+	if flag.Arg(0) == "lint" {
+		lintFile(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "ast" {
+		printTree(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "fmt" {
+		formatFiles(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "build" {
+		buildFile(flag.Args()[1:], opts)
+		return
+	}
+
+	if flag.Arg(0) == "run" {
+		if flag.NArg() != 2 {
+			fmt.Println("Uso: zumbra run arquivo.zum|arquivo.zumc")
+			os.Exit(2)
+		}
+		runFile(flag.Arg(1), opts)
+		return
+	}
+
+	if flag.Arg(0) == "disasm" {
+		if flag.NArg() != 2 {
+			fmt.Println("Uso: zumbra disasm arquivo.zum|arquivo.zumc")
+			os.Exit(2)
+		}
+		disassembleFile(flag.Arg(1), opts)
+		return
+	}
+
+	if flag.NArg() > 0 {
+		runFile(flag.Arg(0), opts)
 		return
 	}
 
 	fmt.Printf("Hello %s! This is the ZUMBRA programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, repl.Options{
+		Policy:  opts.policy,
+		Limits:  opts.limits,
+		Timeout: opts.timeout,
+	})
 }
 
-func runFile(filename string) {
+// checkFile reports the type errors in a file without running it.
+func checkFile(filename string) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		fmt.Printf("Erro ao ler o arquivo: %s\n", err)
 		os.Exit(1)
 	}
 
-	source := string(data)
-	constants := []object.Object{}
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		fmt.Println("Erros de parsing:")
+		for _, msg := range p.Errors() {
+			fmt.Println("\t" + msg)
+		}
+		os.Exit(1)
+	}
+
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		fmt.Printf("Erro ao resolver caminho absoluto: %s\n", err)
+		os.Exit(1)
+	}
+
+	errors := checker.Check(program, filepath.Dir(absPath))
+	if len(errors) == 0 {
+		fmt.Println("Nenhum erro de tipo encontrado")
+		return
+	}
+
+	fmt.Println("Erros de tipo:")
+	for _, msg := range errors {
+		fmt.Printf("\t%s:%s\n", filename, msg)
+	}
+	os.Exit(1)
+}
+
+// lintFile reports the lint diagnostics of a file, as text or as a JSON
+// array for editors.
+func lintFile(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "mostra os avisos em JSON")
+	disable := flags.String("disable", "", "regras desativadas, separadas por vírgula")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("Uso: zumbra lint [--json] [--disable=regra,...] arquivo.zum")
+		os.Exit(2)
+	}
+	filename := flags.Arg(0)
+
+	config, err := linter.NewConfig(strings.Split(*disable, ",")...)
+	if err != nil {
+		fmt.Printf("Erro na configuração: %s\n", err)
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+		os.Exit(1)
+	}
+
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		fmt.Println("Erros de parsing:")
+		for _, msg := range p.Errors() {
+			fmt.Println("\t" + msg)
+		}
+		os.Exit(1)
+	}
+
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		fmt.Printf("Erro ao resolver caminho absoluto: %s\n", err)
+		os.Exit(1)
+	}
+
+	diagnostics := linter.Lint(program, filepath.Dir(absPath), config)
+
+	if *asJSON {
+		if diagnostics == nil {
+			diagnostics = []linter.Diagnostic{}
+		}
+		out, _ := json.MarshalIndent(diagnostics, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, d := range diagnostics {
+			fmt.Printf("%s:%s\n", filename, d)
+		}
+	}
+
+	if len(diagnostics) != 0 {
+		os.Exit(1)
+	}
+}
+
+// printTree prints the syntax tree of a file, indented one node per line
+// or as JSON for editors and the website.
+func printTree(args []string) {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "mostra a árvore em JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("Uso: zumbra ast [--json] arquivo.zum")
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+		os.Exit(1)
+	}
+
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		fmt.Println("Erros de parsing:")
+		for _, msg := range p.Errors() {
+			fmt.Println("\t" + msg)
+		}
+		os.Exit(1)
+	}
+
+	tree := ast.Tree(program)
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(tree, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	var show func(t *ast.TreeNode, depth int)
+	show = func(t *ast.TreeNode, depth int) {
+		line := strings.Repeat("  ", depth)
+		if t.Field != "" {
+			line += t.Field + ": "
+		}
+		line += fmt.Sprintf("%s %d:%d", t.Kind, t.Line, t.Column)
+		if t.Value != "" {
+			line += fmt.Sprintf(" %q", t.Value)
+		}
+		fmt.Println(line)
+
+		for _, child := range t.Children {
+			show(child, depth+1)
+		}
+	}
+	show(tree, 0)
+}
+
+// formatFiles prints the canonical form of the given files, and of the
+// .zum files under the given directories, or rewrites them with -w. With
+// --check it only lists the files that are not formatted.
+func formatFiles(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "reescreve os arquivos com o resultado")
+	check := flags.Bool("check", false, "lista os arquivos que não estão formatados")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Uso: zumbra fmt [-w] [--check] arquivo.zum|diretório ...")
+		os.Exit(2)
+	}
+
+	files := []string{}
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+			os.Exit(1)
+		}
+
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && filepath.Ext(path) == ".zum" {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+
+	failed := false
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+			failed = true
+			continue
+		}
+
+		formatted, err := formatter.Format(string(data))
+		if err != nil {
+			fmt.Printf("Erro ao formatar %s:\n\t%s\n", file, strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+			failed = true
+			continue
+		}
+
+		switch {
+		case *check:
+			if formatted != string(data) {
+				fmt.Println(file)
+				failed = true
+			}
+		case *write:
+			if formatted == string(data) {
+				continue
+			}
+			info, _ := os.Stat(file)
+			if err := ioutil.WriteFile(file, []byte(formatted), info.Mode().Perm()); err != nil {
+				fmt.Printf("Erro ao escrever o arquivo: %s\n", err)
+				failed = true
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type runOptions struct {
+	deterministic bool
+	optimize      bool
+	importRoot    string
+	allowImports  []string
+	timeout       time.Duration
+	limits        vm.Limits
+	policy        builtins.Policy
+}
+
+// runFile runs a script, or the bytecode zumbra build wrote.
+func runFile(filename string, opts runOptions) {
+	code, ok := loadFile(filename, opts)
+	if !ok {
+		os.Exit(1)
+	}
+
 	globals := make([]object.Object, vm.GlobalSize)
-	symbolTable := compiler.NewSymbolTable()
+	machine := vm.NewWithGlobalsStore(code, globals)
+	machine.Scheduler().Deterministic = opts.deterministic
+	machine.SetPolicy(opts.policy)
+	if opts.limits != (vm.Limits{}) {
+		machine.SetLimits(opts.limits)
+	}
+
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
 
-	for i, v := range builtins.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	err := machine.RunContext(ctx)
+	if err != nil {
+		var limitErr *vm.LimitError
+		if errors.As(err, &limitErr) {
+			fmt.Printf("Execução interrompida pelo limite de %s: %s\n", limitName(limitErr.Limit), err)
+		} else {
+			fmt.Printf("Erro na execução da VM: %s\n", err)
+		}
+		for _, line := range machine.StackTrace() {
+			fmt.Printf("\t%s\n", line)
+		}
+
+		if limitErr != nil {
+			os.Exit(exitLimit)
+		}
+		os.Exit(1)
 	}
 
+	machine.LastPoppedStackElem()
+}
+
+// exitLimit is the exit status of a program stopped by --timeout,
+// --max-steps, --max-depth or --max-alloc, so that whoever runs it can
+// tell a program that was stopped from one that failed.
+const exitLimit = 3
+
+func limitName(limit string) string {
+	switch limit {
+	case "steps":
+		return "instruções"
+	case "depth":
+		return "chamadas aninhadas"
+	case "allocation":
+		return "memória"
+	}
+	return "tempo"
+}
+
+// loadFile reads the bytecode zumbra build wrote, or compiles a script,
+// and optimizes it unless told not to. Files that cannot be read or
+// bytecode that fails to verify end the program.
+func loadFile(filename string, opts runOptions) (*compiler.Bytecode, bool) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+		os.Exit(1)
+	}
+
+	var code *compiler.Bytecode
+	if bytes.HasPrefix(data, []byte(compiler.Magic)) {
+		code, err = compiler.Decode(bytes.NewReader(data))
+		if err == nil {
+			err = compiler.Verify(code)
+		}
+		if err != nil {
+			fmt.Printf("Erro ao carregar %s: %s\n", filename, err)
+			os.Exit(1)
+		}
+	} else {
+		var ok bool
+		code, ok = compileSource(filename, string(data), opts)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	if opts.optimize {
+		compiler.Optimize(code, compiler.AllOptimizations)
+	}
+
+	return code, true
+}
+
+// disassembleFile prints the instructions of a script or bytecode file.
+func disassembleFile(filename string, opts runOptions) {
+	code, ok := loadFile(filename, opts)
+	if !ok {
+		os.Exit(1)
+	}
+
+	fmt.Print(compiler.Disassemble(code))
+}
+
+// compileSource compiles the script in filename, printing the errors and
+// warnings found.
+func compileSource(filename, source string, opts runOptions) (*compiler.Bytecode, bool) {
+	constants := []object.Object{}
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.DefineBuiltins(opts.policy)
+
 	l := lexer.New(source)
 	p := parser.New(l)
 	program := p.ParseProgram()
@@ -57,33 +478,78 @@ func runFile(filename string) {
 		for _, msg := range p.Errors() {
 			fmt.Println("\t" + msg)
 		}
-		return
+		return nil, false
 	}
 
-	// Novo trecho:
 	absPath, err := filepath.Abs(filename)
 	if err != nil {
 		fmt.Printf("Erro ao resolver caminho absoluto: %s\n", err)
-		return
+		return nil, false
 	}
 	dir := filepath.Dir(absPath)
 
-	comp := compiler.NewWithStateAndDir(symbolTable, constants, dir) // AQUI
+	comp := compiler.NewWithStateAndDir(symbolTable, constants, dir)
+	comp.SetFile(filename)
+	comp.SetPolicy(opts.policy)
+	if opts.importRoot != "" {
+		comp.SetImportRoot(opts.importRoot, opts.allowImports...)
+	}
 	err = comp.Compile(program)
 	if err != nil {
 		fmt.Printf("Erro na compilação: %s\n", err)
-		return
+		return nil, false
 	}
 
-	code := comp.Bytecode()
-	constants = code.Constants
+	for _, warning := range comp.Warnings() {
+		fmt.Printf("Aviso: %s\n", warning)
+	}
 
-	machine := vm.NewWithGlobalsStore(code, globals)
-	err = machine.Run()
+	return comp.Bytecode(), true
+}
+
+// buildFile compiles a script and its imports into a bytecode file that
+// zumbra run executes without compiling them again.
+func buildFile(args []string, opts runOptions) {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("o", "", "arquivo de saída, por padrão o nome do script com a extensão .zumc")
+	flags.Parse(args)
+
+	// Allow the flags after the file too, as in zumbra build x.zum -o x.zumc.
+	filename := flags.Arg(0)
+	if flags.NArg() > 1 {
+		flags.Parse(flags.Args()[1:])
+	} else {
+		flags.Parse(nil)
+	}
+
+	if filename == "" || flags.NArg() != 0 {
+		fmt.Println("Uso: zumbra build arquivo.zum [-o arquivo.zumc]")
+		os.Exit(2)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".zumc"
+	}
+
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Printf("Erro na execução da VM: %s\n", err)
-		return
+		fmt.Printf("Erro ao ler o arquivo: %s\n", err)
+		os.Exit(1)
 	}
 
-	machine.LastPoppedStackElem()
+	code, ok := compileSource(filename, string(data), opts)
+	if !ok {
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if err := code.Encode(&out); err != nil {
+		fmt.Printf("Erro ao gerar o bytecode: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(*output, out.Bytes(), 0644); err != nil {
+		fmt.Printf("Erro ao escrever o arquivo: %s\n", err)
+		os.Exit(1)
+	}
 }
@@ -5,115 +5,149 @@ import (
 	"zumbra/object"
 )
 
+// Builtins lists the builtin functions with the capabilities they use.
+// Compiled code refers to a builtin by its position, so new ones go at the
+// end.
 var Builtins = []struct {
-	Name    string
-	Builtin *object.Builtin
+	Name         string
+	Builtin      *object.Builtin
+	Capabilities Capability
 }{
 	{
-		"toString", ToStringParserBuiltin(),
+		"toString", ToStringParserBuiltin(), Pure,
 	},
 	{
-		"toInt", ToIntParserBuiltin(),
+		"toInt", ToIntParserBuiltin(), Pure,
 	},
 	{
-		"toFloat", ToFloatParserBuiltin(),
+		"toFloat", ToFloatParserBuiltin(), Pure,
 	},
 	{
-		"toBool", ToBoolParserBuiltin(),
+		"toBool", ToBoolParserBuiltin(), Pure,
 	},
 	{
-		"date", DateBuiltin(),
+		"date", DateBuiltin(), Clock,
 	},
 	{
-		"show", ShowBuiltin(),
+		"show", ShowBuiltin(), Pure,
 	},
 	{
-		"input", InputBuiltin(),
+		"input", InputBuiltin(), Stdin,
 	},
 
 	{
-		"addToDict", AddToDictBuiltin(),
+		"addToDict", AddToDictBuiltin(), Pure,
 	},
 	{
-		"deleteFromDict", DeleteFromDictBuiltin(),
+		"deleteFromDict", DeleteFromDictBuiltin(), Pure,
 	},
 	{
-		"sizeOf", SizeOfBuiltin(),
+		"sizeOf", SizeOfBuiltin(), Pure,
 	},
 	{
-		"first", ArrayFirstBuiltin(),
+		"first", ArrayFirstBuiltin(), Pure,
 	},
 	{
-		"last", ArrayLastBuiltin(),
+		"last", ArrayLastBuiltin(), Pure,
 	},
 	{
-		"allButFirst", AllButFirstBuiltin(),
+		"allButFirst", AllButFirstBuiltin(), Pure,
 	},
 	{
-		"addToArrayStart", AddToArrayStartBuiltin(),
+		"addToArrayStart", AddToArrayStartBuiltin(), Pure,
 	},
 	{
-		"addToArrayEnd", AddToArrayEndBuiltin(),
+		"addToArrayEnd", AddToArrayEndBuiltin(), Pure,
 	},
 	{
-		"removeFromArray", RemoveFromArrayBuiltin(),
+		"removeFromArray", RemoveFromArrayBuiltin(), Pure,
 	},
 	{
-		"max", MaxBuiltin(),
+		"max", MaxBuiltin(), Pure,
 	},
 	{
-		"min", MinBuiltin(),
+		"min", MinBuiltin(), Pure,
 	},
 	{
-		"indexOf", IndexOfBuiltin(),
+		"indexOf", IndexOfBuiltin(), Pure,
 	},
 	{
-		"organize", OrganizeBuiltins(),
+		"organize", OrganizeBuiltins(), Pure,
 	},
 	{
-		"toUppercase", UppercaseBuiltin(),
+		"toUppercase", UppercaseBuiltin(), Pure,
 	},
 	{
-		"toLowercase", LowercaseBuiltin(),
+		"toLowercase", LowercaseBuiltin(), Pure,
 	},
 	{
-		"capitalize", CapitalizeBuiltin(),
+		"capitalize", CapitalizeBuiltin(), Pure,
 	},
 	{
-		"removeWhiteSpaces", RemoveWhiteSpacesBuiltin(),
+		"removeWhiteSpaces", RemoveWhiteSpacesBuiltin(), Pure,
 	},
 	{
-		"sum", SumBuiltin(),
+		"sum", SumBuiltin(), Pure,
 	},
 	{
-		"bhaskara", BhaskaraBuiltin(),
+		"bhaskara", BhaskaraBuiltin(), Pure,
 	},
 	{
-		"getFromDict", GetFromDictBuiltin(),
+		"getFromDict", GetFromDictBuiltin(), Pure,
 	},
 	{
-		"sendEmail", SendEmailBuiltin(),
+		"sendEmail", SendEmailBuiltin(), Network,
 	},
 	{
-		"randomInteger", GenerateRandomIntegerBuiltin(),
+		"randomInteger", GenerateRandomIntegerBuiltin(), Random,
 	},
 	{
-		"randomFloat", GenerateRandomFloatBuiltin(),
+		"randomFloat", GenerateRandomFloatBuiltin(), Random,
 	},
 	{
-		"sendWhatsapp", SendWhatsappBuiltin(),
+		"sendWhatsapp", SendWhatsappBuiltin(), Network,
 	},
 	{
-		"dictKeys", DictKeysBuiltin(),
+		"dictKeys", DictKeysBuiltin(), Pure,
 	},
 	{
-		"dictValues", DictValuesBuiltin(),
+		"dictValues", DictValuesBuiltin(), Pure,
 	},
 	{
-		"replace", ReplaceBuiltin(),
+		"replace", ReplaceBuiltin(), Pure,
+	},
+	{
+		"values", EnumValuesBuiltin(), Pure,
+	},
+	{
+		"next", NextBuiltin(), Pure,
+	},
+	{
+		"spawn", SpawnBuiltin(), Pure,
+	},
+	{
+		"channel", ChannelBuiltin(), Pure,
+	},
+	{
+		"send", SendBuiltin(), Pure,
+	},
+	{
+		"receive", ReceiveBuiltin(), Pure,
+	},
+	{
+		"close", CloseBuiltin(), Pure,
+	},
+	{
+		"waitAll", WaitAllBuiltin(), Pure,
 	},
 }
 
+func init() {
+	for _, def := range Builtins {
+		def.Builtin.Name = def.Name
+	}
+}
+
 func NewBoolean(value bool) *object.Boolean {
 	return &object.Boolean{Value: value}
 }
@@ -131,6 +165,13 @@ func NewError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// WrapError turns the error of a generator or task into an error value,
+// keeping err so that the runtime can tell limits, which must not be
+// caught, from ordinary errors.
+func WrapError(err error) *object.Error {
+	return &object.Error{Message: err.Error(), Err: err}
+}
+
 func GetBuiltinByName(name string) *object.Builtin {
 	for _, builtin := range Builtins {
 		if builtin.Name == name {
@@ -0,0 +1,76 @@
+package builtins
+
+import "strings"
+
+// Capability is a set of things outside the program that builtins reach,
+// such as the network or the clock.
+type Capability uint8
+
+const (
+	Network Capability = 1 << iota
+	Stdin
+	Filesystem
+	Clock
+	Random
+
+	// Pure builtins only work on their arguments.
+	Pure Capability = 0
+	// AllCapabilities is every capability a builtin may have.
+	AllCapabilities = Network | Stdin | Filesystem | Clock | Random
+)
+
+var capabilityNames = []struct {
+	capability Capability
+	name       string
+}{
+	{Network, "network"},
+	{Stdin, "stdin"},
+	{Filesystem, "filesystem"},
+	{Clock, "clock"},
+	{Random, "random"},
+}
+
+func (c Capability) String() string {
+	names := []string{}
+	for _, n := range capabilityNames {
+		if c&n.capability != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Policy decides which builtins a program may use, by the capabilities it
+// denies them. The zero Policy allows every builtin.
+type Policy struct {
+	Denied Capability
+}
+
+// Allows reports whether a builtin with the capabilities caps may be used.
+func (p Policy) Allows(caps Capability) bool {
+	return caps&p.Denied == 0
+}
+
+// Policies are the presets the command line offers. The playground keeps
+// scripts from reaching people or waiting for input; strict also takes the
+// clock and random numbers away, so that a graded script always gives the
+// same output.
+var Policies = map[string]Policy{
+	"off":        {},
+	"playground": {Denied: Network | Stdin | Filesystem},
+	"strict":     {Denied: AllCapabilities},
+}
+
+// Needs returns the capabilities of the builtin called name, and whether
+// there is one.
+func Needs(name string) (Capability, bool) {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Capabilities, true
+		}
+	}
+	return Pure, false
+}
@@ -0,0 +1,27 @@
+package builtins
+
+import (
+	"zumbra/object"
+)
+
+func EnumValuesBuiltin() *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			enum, ok := args[0].(*object.Enum)
+			if !ok {
+				return NewError("argument to `values` must be ENUM, got %s", args[0].Type())
+			}
+
+			elements := make([]object.Object, len(enum.Members))
+			for i, m := range enum.Members {
+				elements[i] = m
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	}
+}
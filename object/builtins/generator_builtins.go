@@ -0,0 +1,27 @@
+package builtins
+
+import (
+	"zumbra/object"
+)
+
+func NextBuiltin() *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			gen, ok := args[0].(*object.Generator)
+			if !ok {
+				return NewError("argument to `next` must be GENERATOR, got %s", args[0].Type())
+			}
+
+			value, err := gen.Next()
+			if err != nil {
+				return WrapError(err)
+			}
+
+			return value
+		},
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 func InputBuiltin() *object.Builtin {
 	return &object.Builtin{
+		Blocking: true,
 		Fn: func(args ...object.Object) object.Object {
 			var input string
 			if len(args) > 0 {
@@ -23,6 +23,8 @@ func ToStringParserBuiltin() *object.Builtin {
 				value = obj.Value
 			case *object.Boolean:
 				value = obj.Value
+			case *object.EnumValue:
+				value = obj.Inspect()
 			default:
 				return NewError("argument to `toString` not supported, got=%s", args[0].Type())
 			}
@@ -15,6 +15,7 @@ type MessageBody struct {
 
 func SendEmailBuiltin() *object.Builtin {
 	return &object.Builtin{
+		Blocking: true,
 		Fn: func(args ...object.Object) object.Object {
 
 			if len(args) != 1 {
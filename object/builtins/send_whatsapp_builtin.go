@@ -9,6 +9,7 @@ import (
 
 func SendWhatsappBuiltin() *object.Builtin {
 	return &object.Builtin{
+		Blocking: true,
 		Fn: func(args ...object.Object) object.Object {
 
 			if len(args) != 1 {
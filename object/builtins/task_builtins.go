@@ -0,0 +1,148 @@
+package builtins
+
+import (
+	"zumbra/object"
+)
+
+func SpawnBuiltin() *object.Builtin {
+	return &object.Builtin{
+		RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return NewError("wrong number of arguments. got=%d, want at least 1", len(args))
+			}
+
+			fnArgs := make([]object.Object, len(args)-1)
+			copy(fnArgs, args[1:])
+
+			run, err := rt.Start(args[0], fnArgs)
+			if err != nil {
+				return WrapError(err)
+			}
+
+			return rt.Scheduler().Spawn(run)
+		},
+	}
+}
+
+func ChannelBuiltin() *object.Builtin {
+	return &object.Builtin{
+		RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) > 2 {
+				return NewError("wrong number of arguments. got=%d, want=0, 1 or 2", len(args))
+			}
+
+			var elementType object.ObjectType
+			if len(args) > 0 {
+				if name, ok := args[0].(*object.String); ok {
+					elementType = object.ObjectType(name.Value)
+					args = args[1:]
+				}
+			}
+
+			capacity := 0
+			if len(args) > 0 {
+				size, ok := args[0].(*object.Integer)
+				if !ok || size.Value < 0 {
+					return NewError("capacity of `channel` must be a non-negative INTEGER, got %s", args[0].Inspect())
+				}
+				capacity = int(size.Value)
+			}
+
+			return object.NewChannel(rt.Scheduler(), elementType, capacity)
+		},
+	}
+}
+
+func SendBuiltin() *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return NewError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			ch, ok := args[0].(*object.Channel)
+			if !ok {
+				return NewError("argument to `send` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			if err := ch.Send(args[1]); err != nil {
+				return WrapError(err)
+			}
+
+			return nil
+		},
+	}
+}
+
+func ReceiveBuiltin() *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			ch, ok := args[0].(*object.Channel)
+			if !ok {
+				return NewError("argument to `receive` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			value, err := ch.Receive()
+			if err != nil {
+				return WrapError(err)
+			}
+
+			return value
+		},
+	}
+}
+
+func CloseBuiltin() *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			ch, ok := args[0].(*object.Channel)
+			if !ok {
+				return NewError("argument to `close` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			if err := ch.Close(); err != nil {
+				return WrapError(err)
+			}
+
+			return nil
+		},
+	}
+}
+
+func WaitAllBuiltin() *object.Builtin {
+	return &object.Builtin{
+		RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) == 1 {
+				if arr, ok := args[0].(*object.Array); ok {
+					args = arr.Elements
+				}
+			}
+
+			results := make([]object.Object, len(args))
+
+			for i, arg := range args {
+				task, ok := arg.(*object.Task)
+				if !ok {
+					return NewError("argument to `waitAll` must be TASK, got %s", arg.Type())
+				}
+
+				result, err := rt.Scheduler().Wait(task)
+				if err != nil {
+					return WrapError(err)
+				}
+
+				results[i] = result
+			}
+
+			return &object.Array{Elements: results}
+		},
+	}
+}
@@ -1,5 +1,12 @@
 package object
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"zumbra/resolver"
+)
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s, outer: nil, importedFiles: make(map[string]bool)}
@@ -9,6 +16,12 @@ type Environment struct {
 	store         map[string]Object
 	outer         *Environment
 	importedFiles map[string]bool
+	yield         func(Object)
+	scheduler     *Scheduler
+	modules       map[string]*Module
+	resolver      *resolver.Resolver
+	dir           string
+	call          *Call
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -24,12 +37,185 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// Assign updates name in the environment that defines it.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
 	return env
 }
 
+// NewGeneratorEnvironment creates the environment a generator body runs
+// in. yield is called with every value the body yields.
+func NewGeneratorEnvironment(outer *Environment, yield func(Object)) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.yield = yield
+	return env
+}
+
+// Yield hands val to the generator running in this environment and
+// reports whether there was one.
+func (e *Environment) Yield(val Object) bool {
+	if e.yield == nil {
+		if e.outer != nil {
+			return e.outer.Yield(val)
+		}
+		return false
+	}
+	e.yield(val)
+	return true
+}
+
+// Scheduler returns the scheduler of the program the environment belongs
+// to, which lives in its outermost environment.
+func (e *Environment) Scheduler() *Scheduler {
+	root := e.root()
+	if root.scheduler == nil {
+		root.scheduler = NewScheduler()
+	}
+	return root.scheduler
+}
+
+// NewModuleEnvironment creates the top-level environment of a module
+// imported from importer. The module gets its own names but shares the
+// scheduler and module cache of the importing program.
+func NewModuleEnvironment(importer *Environment) *Environment {
+	root := importer.root()
+	if root.modules == nil {
+		root.modules = map[string]*Module{}
+	}
+
+	env := NewEnvironment()
+	env.scheduler = root.Scheduler()
+	env.modules = root.modules
+	env.resolver = root.Resolver()
+	return env
+}
+
+// Module returns the module imported from path earlier in the program.
+func (e *Environment) Module(path string) (*Module, bool) {
+	m, ok := e.root().modules[path]
+	return m, ok
+}
+
+func (e *Environment) SetModule(path string, m *Module) {
+	root := e.root()
+	if root.modules == nil {
+		root.modules = map[string]*Module{}
+	}
+	root.modules[path] = m
+}
+
+// Resolver returns the resolver imports of the program are found with,
+// rooted at the working directory unless set otherwise.
+func (e *Environment) Resolver() *resolver.Resolver {
+	root := e.root()
+	if root.resolver == nil {
+		cwd, _ := os.Getwd()
+		root.resolver = resolver.New(cwd)
+	}
+	return root.resolver
+}
+
+func (e *Environment) SetResolver(r *resolver.Resolver) {
+	e.root().resolver = r
+}
+
+// Dir returns the directory of the file running in the environment, which
+// imports are resolved from first.
+func (e *Environment) Dir() string {
+	return e.root().dir
+}
+
+func (e *Environment) SetDir(dir string) {
+	e.root().dir = dir
+}
+
+// SetFile makes name the file running in the environment. Imports are
+// resolved from its directory, and the file counts as being imported, so
+// that an import leading back to it is reported as a cycle.
+func (e *Environment) SetFile(name string) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return
+	}
+
+	e.SetDir(filepath.Dir(path))
+	e.Resolver().Enter(path)
+}
+
+// Call is a function call being run by the evaluator. Position is the
+// source of the statement or call the function is at, and Caller the call
+// it was made from, if any.
+type Call struct {
+	Function string
+	Position SourcePosition
+	Caller   *Call
+}
+
+// Trace lists the calls from c outwards, innermost first, in the format of
+// the VM's stack traces. A call repeated by deep recursion is shown once,
+// with the number of repetitions.
+func (c *Call) Trace() []string {
+	trace := []string{}
+	repeated := 0
+
+	for call := c; call != nil; call = call.Caller {
+		line := fmt.Sprintf("at %s (%s)", call.Function, call.Position)
+
+		if len(trace) > 0 && trace[len(trace)-1] == line {
+			repeated++
+			continue
+		}
+		if repeated > 0 {
+			trace = append(trace, fmt.Sprintf("... repeated %d more times", repeated))
+			repeated = 0
+		}
+		trace = append(trace, line)
+	}
+	if repeated > 0 {
+		trace = append(trace, fmt.Sprintf("... repeated %d more times", repeated))
+	}
+
+	return trace
+}
+
+// Call returns the function call code running in the environment belongs
+// to. Code outside any function belongs to a call named main, kept by the
+// outermost environment.
+func (e *Environment) Call() *Call {
+	if e.call != nil {
+		return e.call
+	}
+	if e.outer != nil {
+		return e.outer.Call()
+	}
+	e.call = &Call{Function: "main"}
+	return e.call
+}
+
+// SetCall makes the environment the one the body of call runs in.
+func (e *Environment) SetCall(call *Call) {
+	e.call = call
+}
+
+func (e *Environment) root() *Environment {
+	if e.outer != nil {
+		return e.outer.root()
+	}
+	return e
+}
+
 func (e *Environment) IsImported(path string) bool {
 	return e.importedFiles[path]
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +29,13 @@ const (
 	CLOSURE_OBJ           = "CLOSURE_OBJ"
 	FLOAT_OBJ             = "FLOAT"
 	DATE_OBJ              = "DATE"
+	ENUM_OBJ              = "ENUM"
+	ENUM_VALUE_OBJ        = "ENUM_VALUE"
+	GENERATOR_OBJ         = "GENERATOR"
+	TASK_OBJ              = "TASK"
+	CHANNEL_OBJ           = "CHANNEL"
+	MODULE_OBJ            = "MODULE"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -63,15 +71,27 @@ func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+	// Stack lists the functions that were running when the error was
+	// raised, innermost first.
+	Stack []string
+	// Caught marks an error bound by a catch block, which is an ordinary
+	// value from then on rather than a failure in flight.
+	Caught bool
+	// Err is the Go error the error was made from, if any.
+	Err error
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return fmt.Sprintf("ERROR: %s", e.Message) }
 
 type Function struct {
-	Parameters []*ast.Identifier
-	Body       *ast.BlockStatement
-	Env        *Environment
+	// Name is the name the function was declared or assigned with, or
+	// empty for anonymous functions.
+	Name        string
+	Parameters  []*ast.Identifier
+	Body        *ast.BlockStatement
+	Env         *Environment
+	IsGenerator bool
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -99,13 +119,69 @@ func (s *String) Inspect() string  { return s.Value }
 
 type BuiltinFunction func(args ...Object) Object
 
+type RuntimeFunction func(rt Runtime, args ...Object) Object
+
+// Runtime is the interpreter calling a builtin, for builtins that need
+// more than their arguments.
+type Runtime interface {
+	Scheduler() *Scheduler
+	// Start prepares the call fn(args...) to run as a task of its own.
+	Start(fn Object, args []Object) (func() (Object, error), error)
+}
+
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	Fn   BuiltinFunction
+	// RuntimeFn is called instead of Fn when set.
+	RuntimeFn RuntimeFunction
+	// Blocking marks builtins that wait on the outside world, during which
+	// other tasks may run.
+	Blocking bool
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+func (b *Builtin) Call(rt Runtime, args ...Object) Object {
+	if b.RuntimeFn != nil {
+		return b.RuntimeFn(rt, args...)
+	}
+
+	if b.Blocking {
+		// Other tasks run in the meantime, so the builtin gets its own
+		// copy of any array or dict it was passed.
+		copied := make([]Object, len(args))
+		for i, arg := range args {
+			copied[i] = snapshot(arg)
+		}
+
+		var result Object
+		rt.Scheduler().Detach(func() { result = b.Fn(copied...) })
+		return result
+	}
+
+	return b.Fn(args...)
+}
+
+func snapshot(obj Object) Object {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]Object, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = snapshot(el)
+		}
+		return &Array{Elements: elements}
+	case *Dict:
+		pairs := make(map[DictKey]DictPair, len(obj.Pairs))
+		for k, pair := range obj.Pairs {
+			pairs[k] = DictPair{Key: pair.Key, Value: snapshot(pair.Value)}
+		}
+		return &Dict{Pairs: pairs}
+	default:
+		return obj
+	}
+}
+
 type Array struct {
 	Elements []Object
 }
@@ -184,9 +260,53 @@ type Dictable interface {
 }
 
 type CompiledFunction struct {
+	// Name is the name the function was declared or assigned with, or
+	// empty for anonymous functions.
+	Name          string
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Handlers      []ExceptionHandler
+	IsGenerator   bool
+	// Positions is the line table of the instructions, ordered by offset.
+	Positions []SourcePosition
+}
+
+// ExceptionHandler sends errors raised by instructions in [Start, End) to
+// the instruction at Target.
+type ExceptionHandler struct {
+	Start  int
+	End    int
+	Target int
+}
+
+// SourcePosition maps the instructions from Offset up to the next entry of
+// a line table to the source they were compiled from. File is empty for
+// code typed in the REPL.
+type SourcePosition struct {
+	Offset int
+	File   string
+	Line   int
+	Column int
+}
+
+func (p SourcePosition) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// PositionAt returns the source of the instruction that covers ip, which
+// may point at the opcode or at any of its operands.
+func (cf *CompiledFunction) PositionAt(ip int) (SourcePosition, bool) {
+	i := sort.Search(len(cf.Positions), func(i int) bool {
+		return cf.Positions[i].Offset > ip
+	})
+	if i == 0 {
+		return SourcePosition{}, false
+	}
+	return cf.Positions[i-1], true
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -204,6 +324,17 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Cell holds a local variable captured by a closure, shared between the
+// frame that defines it and every closure that captures it.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	return fmt.Sprintf("Cell[%p]", c)
+}
+
 type Float struct {
 	Value float64
 }
@@ -227,3 +358,119 @@ func (d *Date) Type() ObjectType { return DATE_OBJ }
 func (d *Date) Inspect() string {
 	return d.FullDate.String()
 }
+
+type Enum struct {
+	Name    string
+	Members []*EnumValue
+}
+
+func NewEnum(name string, members []string) *Enum {
+	enum := &Enum{Name: name}
+	for i, m := range members {
+		enum.Members = append(enum.Members, &EnumValue{Enum: name, Name: m, Ordinal: i})
+	}
+	return enum
+}
+
+func (e *Enum) Type() ObjectType { return ENUM_OBJ }
+func (e *Enum) Inspect() string {
+	var out bytes.Buffer
+
+	members := []string{}
+	for _, m := range e.Members {
+		members = append(members, m.Name)
+	}
+
+	out.WriteString("enum ")
+	out.WriteString(e.Name)
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(members, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+func (e *Enum) Member(name string) (*EnumValue, bool) {
+	for _, m := range e.Members {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+type EnumValue struct {
+	Enum    string
+	Name    string
+	Ordinal int
+}
+
+func (ev *EnumValue) Type() ObjectType { return ENUM_VALUE_OBJ }
+func (ev *EnumValue) Inspect() string  { return ev.Enum + "." + ev.Name }
+
+func (ev *EnumValue) DictKey() DictKey {
+	h := fnv.New64a()
+	h.Write([]byte(ev.Inspect()))
+
+	return DictKey{Type: ev.Type(), Value: h.Sum64()}
+}
+
+// Module holds the members an imported module exported, as they were once
+// the module finished running.
+type Module struct {
+	Name    string
+	Members map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module " + m.Name }
+
+// Generator produces values on demand. Resume runs the underlying code
+// until it hands over its next value, returning nil once it has finished.
+type Generator struct {
+	Resume func() (Object, error)
+	Done   bool
+}
+
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) Inspect() string {
+	return fmt.Sprintf("Generator[%p]", g)
+}
+
+// Next returns the next value of the generator, or nil when it is
+// exhausted. A generator that failed is exhausted from then on.
+func (g *Generator) Next() (Object, error) {
+	if g.Done {
+		return nil, nil
+	}
+
+	value, err := g.Resume()
+	if value == nil || err != nil {
+		g.Done = true
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Iterate returns a generator over the values of obj, which is either a
+// generator already, an array or a channel.
+func Iterate(obj Object) (*Generator, bool) {
+	switch obj := obj.(type) {
+	case *Generator:
+		return obj, true
+	case *Channel:
+		return &Generator{Resume: obj.Receive}, true
+	case *Array:
+		i := 0
+		return &Generator{Resume: func() (Object, error) {
+			if i >= len(obj.Elements) {
+				return nil, nil
+			}
+			i++
+			return obj.Elements[i-1], nil
+		}}, true
+	default:
+		return nil, false
+	}
+}
@@ -18,3 +18,24 @@ func TestStringDictKey(t *testing.T) {
 		t.Errorf("diff1.DictKey() != diff2.DictKey()")
 	}
 }
+
+func TestEnumValueDictKey(t *testing.T) {
+	status := NewEnum("Status", []string{"Pending", "Paid"})
+	other := NewEnum("Other", []string{"Pending"})
+
+	pending, _ := status.Member("Pending")
+	paid, _ := status.Member("Paid")
+	otherPending, _ := other.Member("Pending")
+
+	if pending.DictKey() != status.Members[0].DictKey() {
+		t.Errorf("same enum member has different dict keys")
+	}
+
+	if pending.DictKey() == paid.DictKey() {
+		t.Errorf("Status.Pending and Status.Paid share a dict key")
+	}
+
+	if pending.DictKey() == otherPending.DictKey() {
+		t.Errorf("Status.Pending and Other.Pending share a dict key")
+	}
+}
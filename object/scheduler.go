@@ -0,0 +1,286 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrDeadlock = errors.New("all tasks are asleep - deadlock")
+
+// Scheduler runs the tasks of a program one at a time. The running task
+// holds the baton until it finishes, blocks on a channel or a wait, or is
+// preempted; the baton then passes to the task that has been ready the
+// longest. Since only the baton holder runs user code, arrays and dicts
+// shared between tasks are never modified by two tasks at once.
+type Scheduler struct {
+	// Deterministic keeps the baton during blocking builtins too, so the
+	// order tasks run in never depends on outside timing.
+	Deterministic bool
+
+	mu       sync.Mutex
+	main     *Task
+	current  *Task
+	ready    []*Task
+	parked   []*Task
+	detached int
+}
+
+func NewScheduler() *Scheduler {
+	main := newTask()
+	return &Scheduler{main: main, current: main}
+}
+
+// Spawn starts run as a new task, which first runs once the tasks ready
+// before it have had their turn.
+func (s *Scheduler) Spawn(run func() (Object, error)) *Task {
+	t := newTask()
+
+	s.mu.Lock()
+	s.ready = append(s.ready, t)
+	s.mu.Unlock()
+
+	go func() {
+		<-t.wake
+		result, err := run()
+
+		s.mu.Lock()
+		t.done, t.result, t.err = true, result, err
+		s.wake(t.waiters)
+		t.waiters = nil
+		s.next()
+		s.mu.Unlock()
+	}()
+
+	return t
+}
+
+// Preempt lets the other ready tasks run before the current one continues.
+func (s *Scheduler) Preempt() {
+	s.mu.Lock()
+	if len(s.ready) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	self := s.current
+	s.ready = append(s.ready, self)
+	s.next()
+	s.mu.Unlock()
+
+	<-self.wake
+}
+
+// Detach runs fn without holding the baton, letting other tasks run while
+// fn waits on the outside world, unless the scheduler is deterministic.
+func (s *Scheduler) Detach(fn func()) {
+	if s.Deterministic {
+		fn()
+		return
+	}
+
+	s.mu.Lock()
+	self := s.current
+	s.detached++
+	s.next()
+	s.mu.Unlock()
+
+	fn()
+
+	s.mu.Lock()
+	s.detached--
+	if s.current == nil {
+		s.current = self
+		s.mu.Unlock()
+		return
+	}
+	s.ready = append(s.ready, self)
+	s.mu.Unlock()
+
+	<-self.wake
+}
+
+// Wait blocks until t has finished and returns its result.
+func (s *Scheduler) Wait(t *Task) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !t.done {
+		t.waiters = append(t.waiters, s.current)
+		if err := s.park(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.result, t.err
+}
+
+// park blocks the current task until another task wakes it. It is called
+// with s.mu held and returns with it held again.
+func (s *Scheduler) park() error {
+	self := s.current
+	s.parked = append(s.parked, self)
+	s.next()
+	s.mu.Unlock()
+
+	err := <-self.wake
+
+	s.mu.Lock()
+	return err
+}
+
+// wake makes parked tasks ready again.
+func (s *Scheduler) wake(tasks []*Task) {
+	for _, t := range tasks {
+		for i, p := range s.parked {
+			if p == t {
+				s.parked = append(s.parked[:i], s.parked[i+1:]...)
+				s.ready = append(s.ready, t)
+				break
+			}
+		}
+	}
+}
+
+// next passes the baton to the next ready task. When no task is ready and
+// none is detached, the parked tasks can never be woken, so one of them,
+// preferably main, is woken with ErrDeadlock instead.
+func (s *Scheduler) next() {
+	if len(s.ready) > 0 {
+		s.current = s.ready[0]
+		s.ready = s.ready[1:]
+		s.current.wake <- nil
+		return
+	}
+
+	s.current = nil
+	if s.detached > 0 || len(s.parked) == 0 {
+		return
+	}
+
+	i := 0
+	for j, t := range s.parked {
+		if t == s.main {
+			i = j
+		}
+	}
+
+	s.current = s.parked[i]
+	s.parked = append(s.parked[:i], s.parked[i+1:]...)
+	s.current.wake <- ErrDeadlock
+}
+
+type Task struct {
+	wake    chan error
+	done    bool
+	result  Object
+	err     error
+	waiters []*Task
+}
+
+func newTask() *Task {
+	return &Task{wake: make(chan error, 1)}
+}
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string {
+	return fmt.Sprintf("Task[%p]", t)
+}
+
+// Channel passes values between tasks. A send completes once at most
+// Capacity values sent before it are still waiting to be received, so an
+// unbuffered channel hands each value over directly.
+type Channel struct {
+	// ElementType restricts the values that can be sent, if set.
+	ElementType ObjectType
+	Capacity    int
+
+	scheduler *Scheduler
+	buffer    []Object
+	sent      int
+	received  int
+	closed    bool
+	waiting   []*Task
+}
+
+func NewChannel(s *Scheduler, elementType ObjectType, capacity int) *Channel {
+	return &Channel{ElementType: elementType, Capacity: capacity, scheduler: s}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("Channel[%p]", c)
+}
+
+func (c *Channel) Send(value Object) error {
+	s := c.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("send on closed channel")
+	}
+	if c.ElementType != "" && value.Type() != c.ElementType {
+		return fmt.Errorf("cannot send %s on channel of %s", value.Type(), c.ElementType)
+	}
+
+	c.buffer = append(c.buffer, value)
+	c.sent++
+	n := c.sent
+	c.wakeWaiting()
+
+	for c.received < n-c.Capacity && !c.closed {
+		c.waiting = append(c.waiting, s.current)
+		if err := s.park(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Receive returns the next value sent on the channel, or nil once it is
+// closed and drained.
+func (c *Channel) Receive() (Object, error) {
+	s := c.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(c.buffer) == 0 {
+		if c.closed {
+			return nil, nil
+		}
+
+		c.waiting = append(c.waiting, s.current)
+		if err := s.park(); err != nil {
+			return nil, err
+		}
+	}
+
+	value := c.buffer[0]
+	c.buffer = c.buffer[1:]
+	c.received++
+	c.wakeWaiting()
+
+	return value, nil
+}
+
+func (c *Channel) Close() error {
+	s := c.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("close of closed channel")
+	}
+
+	c.closed = true
+	c.wakeWaiting()
+
+	return nil
+}
+
+func (c *Channel) wakeWaiting() {
+	c.scheduler.wake(c.waiting)
+	c.waiting = nil
+}
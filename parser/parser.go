@@ -24,6 +24,7 @@ var precedences = map[token.TokenType]int{
 	token.POWER:     PRODUCT,
 	token.LPAREN:    CALL,
 	token.LBRACKET:  INDEX,
+	token.DOT:       INDEX,
 }
 
 const (
@@ -55,6 +56,11 @@ type Parser struct {
 
 	prefixParseFcts map[token.TokenType]prefixParseFct
 	infixParseFcts  map[token.TokenType]infixParseFct
+
+	// functionDepth and sawYield track the function literal being parsed
+	// so yield statements can mark it as a generator.
+	functionDepth int
+	sawYield      bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -137,6 +143,23 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseWhileStatement()
 	case token.IMPORT:
 		return p.parseImportStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.ENUM:
+		return p.parseEnumStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.YIELD:
+		return p.parseYieldStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	case token.IDENT:
 		if p.peekTokenIs(token.ASSIGN) {
 			return p.parseAssignStatement()
@@ -159,6 +182,236 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 		Value: p.curToken.Literal,
 	}
 
+	if p.peekTokenIs(token.AS) {
+		p.nextToken()
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	if p.functionDepth > 0 {
+		p.errors = append(p.errors, "export is only allowed at the top level")
+		return nil
+	}
+
+	p.nextToken()
+
+	switch p.curToken.Type {
+	case token.VAR:
+		if vs := p.parseVarStatement(); vs != nil {
+			stmt.Statement = vs
+			return stmt
+		}
+	case token.ENUM:
+		if es := p.parseEnumStatement(); es != nil {
+			stmt.Statement = es
+			return stmt
+		}
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			if fs := p.parseFunctionStatement(); fs != nil {
+				stmt.Statement = fs
+				return stmt
+			}
+			return nil
+		}
+		fallthrough
+	default:
+		msg := fmt.Sprintf("export must be followed by var, enum or fct, got %s", p.curToken.Type)
+		p.errors = append(p.errors, msg)
+	}
+
+	return nil
+}
+
+func (p *Parser) parseEnumStatement() *ast.EnumStatement {
+	stmt := &ast.EnumStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	stmt.Members = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		member := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if seen[member.Value] {
+			msg := fmt.Sprintf("duplicate member %s in enum %s", member.Value, stmt.Name.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		seen[member.Value] = true
+		stmt.Members = append(stmt.Members, member)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	if len(stmt.Members) == 0 {
+		msg := fmt.Sprintf("enum %s must have at least one member", stmt.Name.Value)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errors = append(p.errors, "try must be followed by catch or finally")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseYieldStatement() *ast.YieldStatement {
+	stmt := &ast.YieldStatement{Token: p.curToken}
+
+	if p.functionDepth == 0 {
+		p.errors = append(p.errors, "yield outside of function")
+		return nil
+	}
+	p.sawYield = true
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
 	return stmt
 }
 
@@ -210,6 +463,7 @@ func (p *Parser) parseVarStatement() *ast.VarStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Type = p.parseOptionalType()
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -335,18 +589,21 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
-func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
+// Precedence returns how tightly an infix operator binds, LOWEST for
+// tokens that are not infix operators.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
 		return p
 	}
 	return LOWEST
 }
 
+func (p *Parser) peekPrecedence() int {
+	return Precedence(p.peekToken.Type)
+}
+
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
-	}
-	return LOWEST
+	return Precedence(p.curToken.Type)
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
@@ -422,53 +679,141 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	block.End = p.curToken
 
 	return block
 }
 
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	stmt.Function = p.parseFunction(stmt.Token)
+	if stmt.Function == nil {
+		return nil
+	}
+	stmt.Function.Name = stmt.Name.Value
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{Token: p.curToken}
+	if lit := p.parseFunction(p.curToken); lit != nil {
+		return lit
+	}
+	return nil
+}
+
+// parseFunction parses the parameters and body of a function, starting
+// just before its opening parenthesis.
+func (p *Parser) parseFunction(tok token.Token) *ast.FunctionLiteral {
+	lit := &ast.FunctionLiteral{Token: tok}
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+	lit.ReturnType = p.parseOptionalType()
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	outerSawYield := p.sawYield
+	p.sawYield = false
+	p.functionDepth++
+
 	lit.Body = p.parseBlockStatement()
+	lit.IsGenerator = p.sawYield
+
+	p.functionDepth--
+	p.sawYield = outerSawYield
 
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []*ast.TypeAnnotation) {
 	identifiers := []*ast.Identifier{}
+	types := []*ast.TypeAnnotation{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, types
 	}
 
 	p.nextToken()
 
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
+	types = append(types, p.parseOptionalType())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
+		types = append(types, p.parseOptionalType())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+
+	return identifiers, types
+}
+
+// parseOptionalType parses the `: type` that may follow a name.
+func (p *Parser) parseOptionalType() *ast.TypeAnnotation {
+	if !p.peekTokenIs(token.COLON) {
+		return nil
+	}
+	p.nextToken()
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	return p.parseType()
+}
+
+// parseType parses a type such as `int` or `dict<string, array<int>>`,
+// starting at its name.
+func (p *Parser) parseType() *ast.TypeAnnotation {
+	ta := &ast.TypeAnnotation{Token: p.curToken, Name: p.curToken.Literal}
+
+	if !p.peekTokenIs(token.LT) {
+		return ta
+	}
+	p.nextToken()
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		param := p.parseType()
+		if param == nil {
+			return nil
+		}
+		ta.Parameters = append(ta.Parameters, param)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.GT) {
 		return nil
 	}
 
-	return identifiers
+	return ta
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
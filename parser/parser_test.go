@@ -406,6 +406,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"s == Status.Paid",
+			"(s == Status.Paid)",
+		},
+		{
+			"a.hour + b.minute",
+			"(a.hour + b.minute)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1085,3 +1093,349 @@ func TestLogicalExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestEnumStatement(t *testing.T) {
+	input := `enum Status { Pending, Paid, Shipped }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d\n", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.EnumStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.EnumStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Status" {
+		t.Errorf("stmt.Name.Value not 'Status'. got=%q", stmt.Name.Value)
+	}
+
+	expected := []string{"Pending", "Paid", "Shipped"}
+	if len(stmt.Members) != len(expected) {
+		t.Fatalf("wrong number of members. want=%d, got=%d", len(expected), len(stmt.Members))
+	}
+
+	for i, name := range expected {
+		testIdentifier(t, stmt.Members[i], name)
+	}
+}
+
+func TestEnumStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`enum Status { Paid, Paid }`, "duplicate member Paid in enum Status"},
+		{`enum Status { }`, "enum Status must have at least one member"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	input := `try { risky(); } catch (e) { show(e); } finally { done(); }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d\n", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.TryStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.TryStatement. got=%T", program.Statements[0])
+	}
+
+	if len(stmt.Block.Statements) != 1 {
+		t.Errorf("try block should contain 1 statement. got=%d", len(stmt.Block.Statements))
+	}
+
+	testIdentifier(t, stmt.Param, "e")
+
+	if stmt.Catch == nil || len(stmt.Catch.Statements) != 1 {
+		t.Errorf("catch block should contain 1 statement. got=%v", stmt.Catch)
+	}
+
+	if stmt.Finally == nil || len(stmt.Finally.Statements) != 1 {
+		t.Errorf("finally block should contain 1 statement. got=%v", stmt.Finally)
+	}
+}
+
+func TestTryWithoutCatchOrFinally(t *testing.T) {
+	l := lexer.New(`try { risky(); }`)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "try must be followed by catch or finally" {
+		t.Errorf("wrong parser errors. got=%v", errors)
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "boom";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Value.String() != "boom" {
+		t.Errorf("stmt.Value not 'boom'. got=%q", stmt.Value.String())
+	}
+}
+
+func TestYieldMarksGenerator(t *testing.T) {
+	l := lexer.New(`fct(n) { var inner << fct() { 1 }; yield n; }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+	}
+
+	if !function.IsGenerator {
+		t.Errorf("function.IsGenerator is false")
+	}
+
+	inner := function.Body.Statements[0].(*ast.VarStatement).Value.(*ast.FunctionLiteral)
+	if inner.IsGenerator {
+		t.Errorf("inner.IsGenerator is true")
+	}
+
+	yield, ok := function.Body.Statements[1].(*ast.YieldStatement)
+	if !ok {
+		t.Fatalf("function.Body.Statements[1] is not ast.YieldStatement. got=%T", function.Body.Statements[1])
+	}
+
+	testIdentifier(t, yield.Value, "n")
+}
+
+func TestYieldOutsideFunction(t *testing.T) {
+	l := lexer.New(`yield 1;`)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "yield outside of function" {
+		t.Errorf("wrong parser errors. got=%v", errors)
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	l := lexer.New(`for (x in items()) { show(x); };`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "x" {
+		t.Errorf("stmt.Name not 'x'. got=%q", stmt.Name.Value)
+	}
+
+	if stmt.Iterable.String() != "items()" {
+		t.Errorf("stmt.Iterable not 'items()'. got=%q", stmt.Iterable.String())
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Errorf("stmt.Body.Statements does not contain 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+}
+
+func TestImportStatementWithAlias(t *testing.T) {
+	l := lexer.New(`import "utils.zum" as utils; utils.twoTimes(8);`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ImportStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Alias == nil || stmt.Alias.Value != "utils" {
+		t.Errorf("stmt.Alias not 'utils'. got=%v", stmt.Alias)
+	}
+
+	if program.Statements[1].String() != "utils.twoTimes(8)" {
+		t.Errorf("wrong call. got=%q", program.Statements[1].String())
+	}
+}
+
+func TestExportStatement(t *testing.T) {
+	l := lexer.New(`export var x << 1; export enum Color { Red } export fct f() { 1 }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+
+	for i, expected := range []string{"*ast.VarStatement", "*ast.EnumStatement", "*ast.FunctionStatement"} {
+		stmt, ok := program.Statements[i].(*ast.ExportStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not ast.ExportStatement. got=%T", i, program.Statements[i])
+		}
+
+		if got := fmt.Sprintf("%T", stmt.Statement); got != expected {
+			t.Errorf("exported statement is not %s. got=%s", expected, got)
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	l := lexer.New(`fct add(x, y) { x + y }; fct() { 1 }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "add" || stmt.Function.Name != "add" {
+		t.Errorf("wrong function name. got=%q, %q", stmt.Name.Value, stmt.Function.Name)
+	}
+
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("wrong number of parameters. got=%d", len(stmt.Function.Parameters))
+	}
+
+	if stmt.String() != "fct add(x, y) (x + y)" {
+		t.Errorf("wrong string. got=%q", stmt.String())
+	}
+
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement); !ok {
+		t.Errorf("anonymous function is not an ast.ExpressionStatement. got=%T", program.Statements[1])
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`export show(1);`, "export must be followed by var, enum or fct, got IDENT"},
+		{`var f << fct() { export var x << 1; };`, "export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors. want=%q, got=%v", tt.expected, errors)
+		}
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`var total: int << 0;`, "var total: int = 0;"},
+		{`var xs: array<int> << [];`, "var xs: array<int> = [];"},
+		{`var ages: dict<string, array<float>> << {};`, "var ages: dict<string, array<float>> = {};"},
+		{`var f << fct(name: string, age: int): string { name };`, "var f = fct<%s>f(name: string, age: int): string name;"},
+		{`fct greet(name: string, times): array<string> { [] }`, "fct greet(name: string, times): array<string> []"},
+		{`fct(a, b) { a }`, "fct(a, b) a"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("wrong program. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	fn := parseTypedFunction(t, `fct(name: string, age): int { 1 }`)
+	if len(fn.ParameterTypes) != 2 || fn.ParameterTypes[0].Name != "string" || fn.ParameterTypes[1] != nil {
+		t.Errorf("wrong parameter types. got=%v", fn.ParameterTypes)
+	}
+	if fn.ReturnType == nil || fn.ReturnType.Name != "int" {
+		t.Errorf("wrong return type. got=%v", fn.ReturnType)
+	}
+}
+
+func parseTypedFunction(t *testing.T, input string) *ast.FunctionLiteral {
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	fn, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+	}
+	return fn
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`var x: << 1;`, "expected next token to be IDENT, got << instead"},
+		{`var xs: array<int << [];`, "expected next token to be >, got << instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors. want=%q, got=%v", tt.expected, errors)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"zumbra/compiler"
 	"zumbra/lexer"
@@ -15,20 +17,27 @@ import (
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
+// Options are the restrictions the command line puts on the code typed
+// into the REPL. The limits and the timeout apply to each entry on its
+// own.
+type Options struct {
+	Policy  builtins.Policy
+	Limits  vm.Limits
+	Timeout time.Duration
+}
+
+func Start(in io.Reader, out io.Writer, opts Options) {
 	scanner := bufio.NewScanner(in)
 
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalSize)
 	symbolTable := compiler.NewSymbolTable()
-
-	for i, v := range builtins.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(opts.Policy)
 
 	for {
 		var lines string
 		var openBraces int
+		eof := true
 
 		fmt.Printf(PROMPT)
 		for scanner.Scan() {
@@ -39,11 +48,16 @@ func Start(in io.Reader, out io.Writer) {
 			openBraces -= countChar(line, '}')
 
 			if openBraces <= 0 {
+				eof = false
 				break
 			}
 			fmt.Printf(".. ")
 		}
 
+		if eof && lines == "" {
+			return
+		}
+
 		l := lexer.New(lines)
 		p := parser.New(l)
 
@@ -54,24 +68,34 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
+		comp.SetPolicy(opts.Policy)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "compiler error: %s\n", err)
 			continue
 		}
 
+		for _, warning := range comp.Warnings() {
+			fmt.Fprintf(out, "Aviso: %s\n", warning)
+		}
+
 		code := comp.Bytecode()
 		constants = code.Constants
+		compiler.Optimize(code, compiler.AllOptimizations)
 
 		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
+		machine.SetPolicy(opts.Policy)
+		err = run(machine, opts)
 		if err != nil {
 			fmt.Fprintf(out, "vm error: %s\n", err)
+			for _, line := range machine.StackTrace() {
+				fmt.Fprintf(out, "\t%s\n", line)
+			}
 			continue
 		}
 
 		lastPopped := machine.LastPoppedStackElem()
-		if lastPopped.Type() != object.NULL_OBJ {
+		if lastPopped != nil && lastPopped.Type() != object.NULL_OBJ {
 			io.WriteString(out, lastPopped.Inspect())
 			io.WriteString(out, "\n")
 		}
@@ -79,6 +103,21 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+func run(machine *vm.VM, opts Options) error {
+	if opts.Limits != (vm.Limits{}) {
+		machine.SetLimits(opts.Limits)
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	return machine.RunContext(ctx)
+}
+
 func countChar(s string, ch rune) int {
 	count := 0
 	for _, c := range s {
@@ -0,0 +1,196 @@
+package resolver
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StdPrefix marks imports served from the standard library embedded in
+// the binary, as in `import "std:math" as math`.
+const StdPrefix = "std:"
+
+//go:embed std/*.zum
+var std embed.FS
+
+// Source is an imported file found by the resolver.
+type Source struct {
+	// Name identifies the file: its absolute path, or the std: name for
+	// standard library modules.
+	Name string
+	// Dir is where imports inside the file are resolved from.
+	Dir     string
+	Content string
+}
+
+// Resolver finds imported files and detects import cycles. Relative paths
+// are looked up in the importing file's directory, then in each directory
+// listed in ZUMBRA_PATH, then in the project root.
+type Resolver struct {
+	Root  string
+	Paths []string
+
+	// Sandbox, if set, is the only directory imports may be read from,
+	// besides the ones in Allowed and the standard library.
+	Sandbox string
+	Allowed []string
+
+	loading []string
+}
+
+func New(root string) *Resolver {
+	r := &Resolver{Root: root}
+
+	for _, dir := range filepath.SplitList(os.Getenv("ZUMBRA_PATH")) {
+		if dir != "" {
+			r.Paths = append(r.Paths, dir)
+		}
+	}
+
+	return r
+}
+
+func (r *Resolver) Resolve(path, fromDir string) (*Source, error) {
+	if strings.HasPrefix(path, StdPrefix) {
+		return r.resolveStd(path)
+	}
+
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{}
+		for _, dir := range r.searchPath(fromDir) {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		content, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+
+		name, err := filepath.Abs(candidate)
+		if err != nil {
+			name = filepath.Clean(candidate)
+		}
+
+		if err := r.checkSandbox(path, name); err != nil {
+			return nil, err
+		}
+
+		return &Source{Name: name, Dir: filepath.Dir(name), Content: string(content)}, nil
+	}
+
+	return nil, fmt.Errorf("could not find imported file: %s", path)
+}
+
+func (r *Resolver) resolveStd(path string) (*Source, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, StdPrefix), ".zum")
+
+	content, err := std.ReadFile("std/" + name + ".zum")
+	if err != nil {
+		return nil, fmt.Errorf("no standard library module %s", path)
+	}
+
+	return &Source{Name: StdPrefix + name, Content: string(content)}, nil
+}
+
+// checkSandbox rejects the file at name unless it lies inside the sandbox
+// or an allowed directory, both as written and once symlinks are followed.
+func (r *Resolver) checkSandbox(path, name string) error {
+	if r.Sandbox == "" {
+		return nil
+	}
+
+	dirs := append([]string{r.Sandbox}, r.Allowed...)
+
+	if !insideAny(name, dirs, filepath.Abs) {
+		return fmt.Errorf("import %s escapes the import root %s", path, r.Sandbox)
+	}
+
+	if !insideAny(name, dirs, realPath) {
+		return fmt.Errorf("import %s goes through a symlink outside the import root %s", path, r.Sandbox)
+	}
+
+	return nil
+}
+
+func insideAny(name string, dirs []string, canonical func(string) (string, error)) bool {
+	name, err := canonical(name)
+	if err != nil {
+		return false
+	}
+
+	for _, dir := range dirs {
+		dir, err := canonical(dir)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func realPath(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+func (r *Resolver) searchPath(fromDir string) []string {
+	dirs := []string{}
+	if fromDir != "" {
+		dirs = append(dirs, fromDir)
+	}
+	dirs = append(dirs, r.Paths...)
+	if r.Root != "" {
+		dirs = append(dirs, r.Root)
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, ".")
+	}
+	return dirs
+}
+
+// Enter records that the file called name is being imported, failing with
+// the chain of imports if it is already being imported further up.
+func (r *Resolver) Enter(name string) error {
+	for i, loading := range r.loading {
+		if loading == name {
+			chain := []string{}
+			for _, n := range append(r.loading[i:], name) {
+				chain = append(chain, r.display(n))
+			}
+			return fmt.Errorf("import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	r.loading = append(r.loading, name)
+	return nil
+}
+
+// Leave undoes the latest Enter.
+func (r *Resolver) Leave() {
+	r.loading = r.loading[:len(r.loading)-1]
+}
+
+func (r *Resolver) display(name string) string {
+	if r.Root == "" || strings.HasPrefix(name, StdPrefix) {
+		return name
+	}
+
+	rel, err := filepath.Rel(r.Root, name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return name
+	}
+	return rel
+}
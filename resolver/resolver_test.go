@@ -0,0 +1,157 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("could not create directory: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write file: %s", err)
+	}
+}
+
+func TestResolveSearchPath(t *testing.T) {
+	root := t.TempDir()
+	lib := t.TempDir()
+
+	writeFile(t, filepath.Join(root, "src", "local.zum"), "local")
+	writeFile(t, filepath.Join(root, "src", "shadowed.zum"), "from importer")
+	writeFile(t, filepath.Join(lib, "shadowed.zum"), "from path")
+	writeFile(t, filepath.Join(lib, "lib.zum"), "from path")
+	writeFile(t, filepath.Join(root, "lib.zum"), "from root")
+	writeFile(t, filepath.Join(root, "project.zum"), "from root")
+
+	t.Setenv("ZUMBRA_PATH", lib)
+	r := New(root)
+	fromDir := filepath.Join(root, "src")
+
+	tests := []struct {
+		path     string
+		expected string
+		name     string
+	}{
+		{"local.zum", "local", filepath.Join(root, "src", "local.zum")},
+		{"shadowed.zum", "from importer", filepath.Join(root, "src", "shadowed.zum")},
+		{"lib.zum", "from path", filepath.Join(lib, "lib.zum")},
+		{"project.zum", "from root", filepath.Join(root, "project.zum")},
+		{filepath.Join(lib, "lib.zum"), "from path", filepath.Join(lib, "lib.zum")},
+	}
+
+	for _, tt := range tests {
+		src, err := r.Resolve(tt.path, fromDir)
+		if err != nil {
+			t.Errorf("could not resolve %s: %s", tt.path, err)
+			continue
+		}
+		if src.Content != tt.expected {
+			t.Errorf("wrong file for %s. want=%q, got=%q", tt.path, tt.expected, src.Content)
+		}
+		if src.Name != tt.name {
+			t.Errorf("wrong name for %s. want=%q, got=%q", tt.path, tt.name, src.Name)
+		}
+		if src.Dir != filepath.Dir(tt.name) {
+			t.Errorf("wrong dir for %s. want=%q, got=%q", tt.path, filepath.Dir(tt.name), src.Dir)
+		}
+	}
+
+	_, err := r.Resolve("missing.zum", fromDir)
+	if err == nil || err.Error() != "could not find imported file: missing.zum" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestResolveStd(t *testing.T) {
+	r := New("")
+
+	for _, path := range []string{"std:math", "std:math.zum", "std:arrays", "std:strings"} {
+		src, err := r.Resolve(path, "")
+		if err != nil {
+			t.Errorf("could not resolve %s: %s", path, err)
+			continue
+		}
+		if !strings.HasPrefix(src.Name, StdPrefix) || src.Content == "" {
+			t.Errorf("wrong source for %s: %+v", path, src)
+		}
+	}
+
+	_, err := r.Resolve("std:nope", "")
+	if err == nil || err.Error() != "no standard library module std:nope" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestEnterDetectsCycles(t *testing.T) {
+	root := t.TempDir()
+	r := New(root)
+
+	a := filepath.Join(root, "a.zum")
+	b := filepath.Join(root, "lib", "b.zum")
+
+	if err := r.Enter(a); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Enter(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err := r.Enter(a)
+	expected := "import cycle: a.zum -> " + filepath.Join("lib", "b.zum") + " -> a.zum"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+
+	r.Leave()
+	r.Leave()
+	if err := r.Enter(b); err != nil {
+		t.Errorf("unexpected error after leaving: %s", err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	shared := filepath.Join(base, "shared")
+	secret := filepath.Join(base, "secret")
+
+	writeFile(t, filepath.Join(root, "main.zum"), "main")
+	writeFile(t, filepath.Join(shared, "lib.zum"), "lib")
+	writeFile(t, filepath.Join(secret, "keys.zum"), "keys")
+
+	if err := os.Symlink(secret, filepath.Join(root, "link")); err != nil {
+		t.Skipf("could not create symlink: %s", err)
+	}
+
+	r := New(root)
+	r.Sandbox = root
+	r.Allowed = []string{shared}
+
+	allowed := []string{"main.zum", "../shared/lib.zum", filepath.Join(shared, "lib.zum"), "std:math"}
+	for _, path := range allowed {
+		if _, err := r.Resolve(path, root); err != nil {
+			t.Errorf("could not resolve %s: %s", path, err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"../secret/keys.zum", "import ../secret/keys.zum escapes the import root " + root},
+		{filepath.Join(secret, "keys.zum"), "import " + filepath.Join(secret, "keys.zum") + " escapes the import root " + root},
+		{"link/keys.zum", "import link/keys.zum goes through a symlink outside the import root " + root},
+	}
+
+	for _, tt := range tests {
+		_, err := r.Resolve(tt.path, root)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.path, tt.expected, err)
+		}
+	}
+}
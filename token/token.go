@@ -57,25 +57,49 @@ const (
 	RETURN   = "RETURN"
 	WHILE    = "WHILE"
 	IMPORT   = "IMPORT"
+	ENUM     = "ENUM"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	YIELD    = "YIELD"
+	FOR      = "FOR"
+	IN       = "IN"
+	EXPORT   = "EXPORT"
+	AS       = "AS"
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Line and Column locate the first character of the token in the
+	// source, both starting at 1.
+	Line   int
+	Column int
 }
 
 var keywords = map[string]TokenType{
-	"fct":    FUNCTION,
-	"var":    VAR,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"while":  WHILE,
-	"import": IMPORT,
-	"and":    AND,
-	"or":     OR,
+	"fct":     FUNCTION,
+	"var":     VAR,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"while":   WHILE,
+	"import":  IMPORT,
+	"and":     AND,
+	"or":      OR,
+	"enum":    ENUM,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
+	"yield":   YIELD,
+	"for":     FOR,
+	"in":      IN,
+	"export":  EXPORT,
+	"as":      AS,
 }
 
 func LookupIdent(ident string) TokenType {
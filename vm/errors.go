@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"fmt"
+	"zumbra/code"
+	"zumbra/object"
+)
+
+// ThrownError is returned by Run when a value thrown with `throw` is not
+// caught by any try statement.
+type ThrownError struct {
+	Value object.Object
+}
+
+func (e *ThrownError) Error() string {
+	return fmt.Sprintf("uncaught error: %s", errorMessage(e.Value))
+}
+
+// RuntimeError is an error raised while executing bytecode, such as a
+// builtin reporting a failure. Errors the VM raises itself, like a
+// division by zero, name the opcode that failed.
+type RuntimeError struct {
+	Message  string
+	Builtin  string
+	Opcode   string
+	Function string
+	Ip       int
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Opcode != "" {
+		return fmt.Sprintf("%s (%s at %s, ip %d)", e.message(), e.Opcode, e.Function, e.Ip)
+	}
+	return fmt.Sprintf("%s (at %s, ip %d)", e.message(), e.Function, e.Ip)
+}
+
+func (e *RuntimeError) message() string {
+	if e.Builtin != "" {
+		return e.Builtin + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (vm *VM) builtinError(builtin *object.Builtin, errObj *object.Error, callIp int) *RuntimeError {
+	return &RuntimeError{
+		Message:  errObj.Message,
+		Builtin:  builtin.Name,
+		Function: vm.functionName(vm.framesIndex - 1),
+		Ip:       callIp,
+	}
+}
+
+// opcodeError reports the failure of the instruction op at ip in the
+// current function.
+func (vm *VM) opcodeError(op code.Opcode, ip int, format string, args ...interface{}) *RuntimeError {
+	name := fmt.Sprintf("opcode %d", op)
+	if def, err := code.Lookup(byte(op)); err == nil {
+		name = def.Name
+	}
+
+	return &RuntimeError{
+		Message:  fmt.Sprintf(format, args...),
+		Opcode:   name,
+		Function: vm.functionName(vm.framesIndex - 1),
+		Ip:       ip,
+	}
+}
+
+func (vm *VM) functionName(frameIndex int) string {
+	if frameIndex <= 0 {
+		return "main"
+	}
+	if name := vm.frames[frameIndex].cl.Fn.Name; name != "" {
+		return name
+	}
+	return "fct"
+}
+
+// handleError looks for an exception handler covering the instruction that
+// failed, starting at the current frame and unwinding towards main. When
+// one is found the frames above it are dropped, the error object is pushed
+// and execution continues at the handler.
+func (vm *VM) handleError(err error) bool {
+	var errObj *object.Error
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		for _, h := range frame.cl.Fn.Handlers {
+			if frame.ip < h.Start || frame.ip >= h.End {
+				continue
+			}
+
+			if errObj == nil {
+				errObj = vm.errorObject(err)
+			}
+
+			vm.framesIndex = i + 1
+			vm.sp = frame.basePointer + frame.cl.Fn.NumLocals
+			frame.ip = h.Target - 1
+
+			errObj.Caught = true
+			return vm.push(errObj) == nil
+		}
+	}
+
+	return false
+}
+
+func (vm *VM) errorObject(err error) *object.Error {
+	var errObj *object.Error
+
+	switch err := err.(type) {
+	case *ThrownError:
+		if e, ok := err.Value.(*object.Error); ok {
+			errObj = &object.Error{Message: e.Message, Stack: e.Stack}
+		} else {
+			errObj = &object.Error{Message: errorMessage(err.Value)}
+		}
+	case *RuntimeError:
+		errObj = &object.Error{Message: err.message()}
+	default:
+		errObj = &object.Error{Message: err.Error()}
+	}
+
+	if errObj.Stack == nil {
+		errObj.Stack = vm.StackTrace()
+	}
+
+	return errObj
+}
+
+// StackTrace lists the functions being run, innermost first, with the
+// source of the instruction each is at. After Run fails it shows where the
+// error happened. Code compiled without a line table shows the instruction
+// pointer instead. A frame repeated by deep recursion is shown once, with
+// the number of repetitions.
+func (vm *VM) StackTrace() []string {
+	trace := []string{}
+	repeated := 0
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		var line string
+		if pos, ok := frame.cl.Fn.PositionAt(frame.ip); ok {
+			line = fmt.Sprintf("at %s (%s)", vm.functionName(i), pos)
+		} else {
+			line = fmt.Sprintf("at %s (ip %d)", vm.functionName(i), frame.ip)
+		}
+
+		if len(trace) > 0 && trace[len(trace)-1] == line {
+			repeated++
+			continue
+		}
+		if repeated > 0 {
+			trace = append(trace, fmt.Sprintf("... repeated %d more times", repeated))
+			repeated = 0
+		}
+		trace = append(trace, line)
+	}
+	if repeated > 0 {
+		trace = append(trace, fmt.Sprintf("... repeated %d more times", repeated))
+	}
+
+	return trace
+}
+
+func errorMessage(obj object.Object) string {
+	if e, ok := obj.(*object.Error); ok {
+		return e.Message
+	}
+	return obj.Inspect()
+}
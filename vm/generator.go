@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"fmt"
+	"zumbra/object"
+)
+
+// callGenerator handles calls to functions containing yield. Instead of
+// running the body, it sets the call up on a VM of its own that shares
+// constants and globals with vm, so the generator's frames, stack and
+// instruction pointer survive between resumptions.
+func (vm *VM) callGenerator(cl *object.Closure, numArgs int) error {
+	if err := vm.allocate(forkSize); err != nil {
+		return err
+	}
+
+	g := vm.fork()
+	if err := g.growStack(numArgs + 1); err != nil {
+		return err
+	}
+	g.sp = copy(g.stack, vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = vm.sp - numArgs - 1
+
+	err := g.callClosure(cl, numArgs)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(&object.Generator{Resume: g.resume})
+}
+
+// resume runs the generator until its next yield, returning nil once the
+// function has returned.
+func (vm *VM) resume() (object.Object, error) {
+	if vm.running {
+		return nil, fmt.Errorf("generator is already running")
+	}
+
+	vm.running = true
+	defer func() { vm.running = false }()
+
+	vm.yielded = nil
+
+	err := vm.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.yielded, nil
+}
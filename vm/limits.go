@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"zumbra/object"
+)
+
+// Limits bounds what a program may use while it runs. A zero field leaves
+// that resource unlimited.
+type Limits struct {
+	// MaxSteps is the number of instructions the program may run.
+	MaxSteps int64
+	// MaxDepth is the number of calls that may be nested, on top of the
+	// MaxFrames every VM is limited to.
+	MaxDepth int
+	// MaxAllocation roughly bounds the bytes taken by the strings, arrays,
+	// dicts, closures and generators the program creates. Values are
+	// counted when made, even if they are no longer used.
+	MaxAllocation int64
+}
+
+// LimitError is returned by Run when the program goes over one of its
+// Limits, or by RunContext when the context is done. Unlike other runtime
+// errors it is not caught by try statements, so a program cannot keep
+// itself running.
+type LimitError struct {
+	// Limit is "steps", "depth", "allocation" or "context".
+	Limit    string
+	Message  string
+	Function string
+	Ip       int
+	// Err is the error of the context that stopped the program.
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s (at %s, ip %d)", e.Message, e.Function, e.Ip)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// budget counts what a program has used against its limits. It is shared
+// by the VMs forked for its generators and tasks, so they draw on the same
+// limits.
+type budget struct {
+	limits    Limits
+	ctx       context.Context
+	steps     int64
+	allocated int64
+}
+
+// SetLimits bounds the resources the program run by vm may use.
+func (vm *VM) SetLimits(limits Limits) {
+	vm.ensureBudget().limits = limits
+}
+
+// RunContext runs the program like Run, stopping it with a LimitError once
+// ctx is done. The context is checked every Quantum instructions, so a
+// program blocked in a builtin only stops when the builtin returns.
+func (vm *VM) RunContext(ctx context.Context) error {
+	if ctx.Done() != nil {
+		vm.ensureBudget().ctx = ctx
+	}
+	return vm.Run()
+}
+
+// ensureBudget returns the budget of vm, creating it on first use. VMs
+// without limits have none, so that counting costs them nothing.
+func (vm *VM) ensureBudget() *budget {
+	if vm.budget == nil {
+		vm.budget = &budget{}
+	}
+	return vm.budget
+}
+
+// step counts an instruction against the budget.
+func (vm *VM) step() error {
+	b := vm.budget
+	b.steps++
+
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return vm.limitError("steps", nil, "step limit exceeded: more than %d instructions", b.limits.MaxSteps)
+	}
+
+	if b.ctx != nil && b.steps%Quantum == 0 {
+		select {
+		case <-b.ctx.Done():
+			return vm.limitError("context", b.ctx.Err(), "execution stopped: %s", b.ctx.Err())
+		default:
+		}
+	}
+
+	return nil
+}
+
+// allocate counts size bytes against the allocation budget.
+func (vm *VM) allocate(size int64) error {
+	b := vm.budget
+	if b == nil || b.limits.MaxAllocation == 0 {
+		return nil
+	}
+
+	b.allocated += size
+	if b.allocated > b.limits.MaxAllocation {
+		return vm.limitError("allocation", nil, "allocation limit exceeded: more than %d bytes", b.limits.MaxAllocation)
+	}
+	return nil
+}
+
+// maxDepth returns the number of frames vm may hold.
+func (vm *VM) maxDepth() int {
+	if vm.budget != nil && vm.budget.limits.MaxDepth > 0 && vm.budget.limits.MaxDepth < MaxFrames {
+		// The frame of main, or of the VM's caller, is not a call.
+		return vm.budget.limits.MaxDepth + 1
+	}
+	return MaxFrames
+}
+
+func (vm *VM) limitError(limit string, err error, format string, args ...interface{}) *LimitError {
+	return &LimitError{
+		Limit:    limit,
+		Message:  fmt.Sprintf(format, args...),
+		Function: vm.functionName(vm.framesIndex - 1),
+		Ip:       vm.currentFrame().ip,
+		Err:      err,
+	}
+}
+
+// forkSize is roughly the bytes taken by the stack and frames a forked VM
+// starts with. Growing its stack later is counted as it happens.
+const forkSize = forkStackSize*16 + forkFrames*8
+
+// sizeOf estimates the bytes taken by a value the program created, not
+// counting the values it holds, which were counted when they were made.
+func sizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return 16 + int64(len(obj.Value))
+	case *object.Array:
+		return 24 + 16*int64(len(obj.Elements))
+	case *object.Dict:
+		return 48 + 64*int64(len(obj.Pairs))
+	case *object.Closure:
+		return 32 + 16*int64(len(obj.Free))
+	case *object.Module:
+		return 48 + 64*int64(len(obj.Members))
+	default:
+		return 16
+	}
+}
@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"fmt"
+	"zumbra/object"
+)
+
+// Quantum is the number of instructions a task runs before other ready
+// tasks get a turn.
+const Quantum = 1000
+
+// A forked VM starts with room for a few calls, and grows its stack and
+// frames as it needs up to StackSize and MaxFrames, since most generators
+// and tasks never nest deeply.
+const (
+	forkStackSize = 64
+	forkFrames    = 8
+)
+
+// fork creates an empty VM sharing constants, globals and the scheduler
+// with vm, for running a call apart from vm's own frames and stack.
+func (vm *VM) fork() *VM {
+	forked := &VM{
+		constants: vm.constants,
+		stack:     make([]object.Object, forkStackSize),
+		globals:   vm.globals,
+		frames:    make([]*Frame, 0, forkFrames),
+		scheduler: vm.scheduler,
+		budget:    vm.budget,
+		policy:    vm.policy,
+	}
+
+	forked.pushFrame(NewFrame(&object.Closure{Fn: &object.CompiledFunction{}}, 0))
+
+	return forked
+}
+
+func (vm *VM) Scheduler() *object.Scheduler {
+	return vm.scheduler
+}
+
+// Start prepares a call for spawn. Closures run on a VM of their own that
+// shares constants, globals and the scheduler with vm.
+func (vm *VM) Start(fn object.Object, args []object.Object) (func() (object.Object, error), error) {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if err := vm.allocate(forkSize); err != nil {
+			return nil, err
+		}
+
+		task := vm.fork()
+		task.push(fn)
+		for _, arg := range args {
+			task.push(arg)
+		}
+
+		err := task.executeCall(len(args), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() (object.Object, error) {
+			err := task.Run()
+			if err != nil {
+				return nil, err
+			}
+			return task.StackTop(), nil
+		}, nil
+
+	case *object.Builtin:
+		return func() (object.Object, error) {
+			result := fn.Call(vm, args...)
+			if errObj, ok := result.(*object.Error); ok && !errObj.Caught {
+				return nil, fmt.Errorf("%s: %s", fn.Name, errObj.Message)
+			}
+			if result == nil {
+				return Null, nil
+			}
+			return result, nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("cannot spawn %s", fn.Type())
+	}
+}
@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"zumbra/code"
 	"zumbra/compiler"
@@ -8,7 +9,7 @@ import (
 	"zumbra/object/builtins"
 )
 
-const StackSize = 2048
+const StackSize = compiler.StackSize
 const GlobalSize = 65536
 const MaxFrames = 1024
 
@@ -16,6 +17,8 @@ var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
 
+var errStackUnderflow = errors.New("stack underflow")
+
 type VM struct {
 	constants   []object.Object
 	stack       []object.Object
@@ -23,10 +26,23 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	// yielded and running are used when the VM drives a generator.
+	yielded object.Object
+	running bool
+
+	scheduler *object.Scheduler
+	steps     int
+	budget    *budget
+	policy    builtins.Policy
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFct := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFct := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		Positions:    bytecode.Positions,
+	}
 	mainClosure := &object.Closure{Fn: mainFct}
 	mainFrame := NewFrame(mainClosure, 0)
 
@@ -39,9 +55,17 @@ func New(bytecode *compiler.Bytecode) *VM {
 		globals:     make([]object.Object, GlobalSize),
 		frames:      frames,
 		framesIndex: 1,
+		scheduler:   object.NewScheduler(),
 	}
 }
 
+// SetPolicy keeps the program from using the builtins policy denies. The
+// compiler already rejects them in source code, so this is for bytecode
+// built without the policy.
+func (vm *VM) SetPolicy(policy builtins.Policy) {
+	vm.policy = policy
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -51,20 +75,61 @@ func (vm *VM) StackTop() object.Object {
 }
 
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		if _, ok := err.(*LimitError); ok || !vm.handleError(err) {
+			return err
+		}
+	}
+}
+
+// run executes instructions until the current function ends, a generator
+// yields or an error is raised. A Go panic while executing an instruction,
+// which only damaged bytecode or a bug in the VM should cause, is returned
+// as a runtime error of that instruction.
+func (vm *VM) run() (err error) {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.opcodeError(op, ip, "%v", r)
+		}
+
+		// Errors the instructions return without a location, like a
+		// stack overflow or an unsupported operand, are given the opcode
+		// and ip of the instruction.
+		switch err.(type) {
+		case nil, *RuntimeError, *LimitError, *ThrownError:
+		default:
+			err = vm.opcodeError(op, ip, "%s", err)
+		}
+	}()
+
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		vm.steps++
+		if vm.steps%Quantum == 0 {
+			vm.scheduler.Preempt()
+		}
+		if vm.budget != nil {
+			if err := vm.step(); err != nil {
+				return err
+			}
+		}
+
 		switch op {
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+		case code.OpConstant, code.OpConstantWide:
+			constIndex := vm.readLarge(ins, ip, op == code.OpConstantWide)
 
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
@@ -122,13 +187,12 @@ func (vm *VM) Run() error {
 		case code.OpPop:
 			vm.pop()
 
-		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
+		case code.OpJump, code.OpJumpWide:
+			pos := vm.readLarge(ins, ip, op == code.OpJumpWide)
 			vm.currentFrame().ip = pos - 1
 
-		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+		case code.OpJumpNotTruthy, code.OpJumpNotTruthyWide:
+			pos := vm.readLarge(ins, ip, op == code.OpJumpNotTruthyWide)
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
@@ -152,7 +216,12 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return vm.opcodeError(op, ip, "global %d is read before it is set", globalIndex)
+			}
+
+			err := vm.push(global)
 			if err != nil {
 				return err
 			}
@@ -164,6 +233,10 @@ func (vm *VM) Run() error {
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
 
+			if err := vm.allocate(sizeOf(array)); err != nil {
+				return err
+			}
+
 			err := vm.push(array)
 			if err != nil {
 				return err
@@ -180,6 +253,10 @@ func (vm *VM) Run() error {
 
 			vm.sp = vm.sp - numElements
 
+			if err := vm.allocate(sizeOf(dict)); err != nil {
+				return err
+			}
+
 			err = vm.push(dict)
 			if err != nil {
 				return err
@@ -194,27 +271,34 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpCall:
+		case code.OpCall, code.OpCallWide:
+			numArgs := vm.readSmall(ins, ip, op == code.OpCallWide)
+			err := vm.executeCall(numArgs, ip)
+			if err != nil {
+				return err
+			}
 
+		case code.OpTailCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
-			err := vm.executeCall(int(numArgs))
+
+			err := vm.executeTailCall(int(numArgs), ip)
 			if err != nil {
 				return err
 			}
 
 		case code.OpReturnValue:
-			returnValue := vm.pop()
-
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			err := vm.push(returnValue)
+			err := vm.returnValue()
 			if err != nil {
 				return err
 			}
 
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				vm.endProgram(Null)
+				break
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
@@ -223,28 +307,110 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		case code.OpSetLocal, code.OpSetLocalWide:
+			localIndex := vm.readSmall(ins, ip, op == code.OpSetLocalWide)
 
 			frame := vm.currentFrame()
 			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 
-		case code.OpGetLocal:
+		case code.OpAssignLocal, code.OpAssignLocalWide:
+			localIndex := vm.readSmall(ins, ip, op == code.OpAssignLocalWide)
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+
+		case code.OpGetLocal, code.OpGetLocalWide:
+			localIndex := vm.readSmall(ins, ip, op == code.OpGetLocalWide)
+
+			frame := vm.currentFrame()
+			local := deref(vm.stack[frame.basePointer+int(localIndex)])
+			if local == nil {
+				return vm.opcodeError(op, ip, "local %d is read before it is set", localIndex)
+			}
+
+			err := vm.push(local)
+			if err != nil {
+				return err
+			}
+
+		case code.OpAddLocalConstant:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			constIndex := code.ReadUint16(ins[ip+2:])
+			vm.currentFrame().ip += 3
 
 			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.addLocalConstant(frame.basePointer+int(localIndex), vm.constants[constIndex])
 			if err != nil {
 				return err
 			}
 
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		case code.OpCompareLocalJump:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+2:])
+			comparison := code.Opcode(ins[ip+4])
+			pos := int(code.ReadUint16(ins[ip+5:]))
+			vm.currentFrame().ip += 6
+
+			frame := vm.currentFrame()
+			holds, err := vm.compareLocalConstant(comparison, deref(vm.stack[frame.basePointer+int(localIndex)]), vm.constants[constIndex])
+			if err != nil {
+				return err
+			}
+
+			if !holds {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpCallGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			numArgs := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			if vm.globals[globalIndex] == nil {
+				return vm.opcodeError(op, ip, "global %d is called before it is set", globalIndex)
+			}
+
+			// Slide the arguments up to put the callee under them, where
+			// OpGetGlobal would have pushed it.
+			if err := vm.growStack(vm.sp + 1); err != nil {
+				return err
+			}
+			copy(vm.stack[vm.sp-numArgs+1:vm.sp+1], vm.stack[vm.sp-numArgs:vm.sp])
+			vm.stack[vm.sp-numArgs] = vm.globals[globalIndex]
+			vm.sp++
+
+			err := vm.executeCall(numArgs, ip)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetLocalCell, code.OpGetLocalCellWide:
+			localIndex := vm.readSmall(ins, ip, op == code.OpGetLocalCellWide)
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if _, ok := (*slot).(*object.Cell); !ok {
+				*slot = &object.Cell{Value: *slot}
+			}
+
+			err := vm.push(*slot)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin, code.OpGetBuiltinWide:
+			builtinIndex := vm.readSmall(ins, ip, op == code.OpGetBuiltinWide)
 
 			definition := builtins.Builtins[builtinIndex]
+			if !vm.policy.Allows(definition.Capabilities) {
+				return vm.opcodeError(op, ip, "%s is not allowed by the sandbox, it uses %s",
+					definition.Name, definition.Capabilities&vm.policy.Denied)
+			}
 
 			err := vm.push(definition.Builtin)
 
@@ -252,25 +418,49 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
+		case code.OpClosure, code.OpClosureWide:
+			wide := op == code.OpClosureWide
+			constIndex := vm.readLarge(ins, ip, wide)
+			numFree := vm.readSmall(ins, vm.currentFrame().ip, wide)
 
-			err := vm.pushClosure(int(constIndex), int(numFree))
+			err := vm.pushClosure(constIndex, numFree)
 			if err != nil {
 				return err
 			}
 
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		case code.OpGetFree, code.OpGetFreeWide:
+			freeIndex := vm.readSmall(ins, ip, op == code.OpGetFreeWide)
+
+			currentClosure := vm.currentFrame().cl
+			free := deref(currentClosure.Free[freeIndex])
+			if free == nil {
+				return vm.opcodeError(op, ip, "free variable %d is read before it is set", freeIndex)
+			}
+
+			err := vm.push(free)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree, code.OpSetFreeWide:
+			freeIndex := vm.readSmall(ins, ip, op == code.OpSetFreeWide)
+
+			currentClosure := vm.currentFrame().cl
+			if cell, ok := currentClosure.Free[freeIndex].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				currentClosure.Free[freeIndex] = vm.pop()
+			}
+
+		case code.OpGetFreeCell, code.OpGetFreeCellWide:
+			freeIndex := vm.readSmall(ins, ip, op == code.OpGetFreeCellWide)
 
 			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
+
 		case code.OpCurrentClosure:
 			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure)
@@ -319,10 +509,117 @@ func (vm *VM) Run() error {
 				default:
 					return fmt.Errorf("unknown attribute %s for Date", attrName.Value)
 				}
+			case *object.Enum:
+				member, ok := d.Member(attrName.Value)
+				if !ok {
+					return fmt.Errorf("enum %s has no member %s", d.Name, attrName.Value)
+				}
+				vm.push(member)
+			case *object.Module:
+				member, ok := d.Members[attrName.Value]
+				if !ok {
+					return fmt.Errorf("module %s has no member %s", d.Name, attrName.Value)
+				}
+				vm.push(member)
+			case *object.Error:
+				switch attrName.Value {
+				case "message":
+					vm.push(&object.String{Value: d.Message})
+				case "stack":
+					elements := make([]object.Object, len(d.Stack))
+					for i, line := range d.Stack {
+						elements[i] = &object.String{Value: line}
+					}
+					vm.push(&object.Array{Elements: elements})
+				default:
+					return fmt.Errorf("unknown attribute %s for Error", attrName.Value)
+				}
 			default:
 				return fmt.Errorf("object type %s has no attributes", obj.Type())
 			}
 
+		case code.OpThrow:
+			return &ThrownError{Value: vm.pop()}
+
+		case code.OpModule, code.OpModuleWide:
+			wide := op == code.OpModuleWide
+			nameIndex := vm.readLarge(ins, ip, wide)
+			numElements := vm.readLarge(ins, vm.currentFrame().ip, wide)
+
+			module := &object.Module{
+				Name:    vm.constants[nameIndex].(*object.String).Value,
+				Members: make(map[string]object.Object, numElements/2),
+			}
+			for i := vm.sp - numElements; i < vm.sp; i += 2 {
+				module.Members[vm.stack[i].(*object.String).Value] = vm.stack[i+1]
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.allocate(sizeOf(module)); err != nil {
+				return err
+			}
+
+			err := vm.push(module)
+			if err != nil {
+				return err
+			}
+
+		case code.OpImport:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			loaderIndex := code.ReadUint32(ins[ip+3:])
+			vm.currentFrame().ip += 6
+
+			if module := vm.globals[globalIndex]; module != nil {
+				if err := vm.push(module); err != nil {
+					return err
+				}
+				break
+			}
+
+			loader := &object.Closure{Fn: vm.constants[loaderIndex].(*object.CompiledFunction)}
+			if err := vm.push(loader); err != nil {
+				return err
+			}
+			if err := vm.callClosure(loader, 0); err != nil {
+				return err
+			}
+
+		case code.OpYield:
+			vm.yielded = vm.pop()
+			return nil
+
+		case code.OpIter:
+			obj := vm.pop()
+
+			iterator, ok := object.Iterate(obj)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", obj.Type())
+			}
+
+			err := vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext, code.OpIterNextWide:
+			pos := vm.readLarge(ins, ip, op == code.OpIterNextWide)
+
+			iterator := vm.pop().(*object.Generator)
+
+			value, err := iterator.Next()
+			if err != nil {
+				return err
+			}
+
+			if value == nil {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				err = vm.push(value)
+				if err != nil {
+					return err
+				}
+			}
+
 		}
 
 	}
@@ -330,9 +627,33 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// readSmall reads the one byte operand after ip, or the two byte operand
+// of a wide instruction, and moves the frame past it.
+func (vm *VM) readSmall(ins code.Instructions, ip int, wide bool) int {
+	if wide {
+		vm.currentFrame().ip += 2
+		return int(code.ReadUint16(ins[ip+1:]))
+	}
+	vm.currentFrame().ip++
+	return int(code.ReadUint8(ins[ip+1:]))
+}
+
+// readLarge reads the two byte operand after ip, or the four byte operand
+// of a wide instruction, and moves the frame past it.
+func (vm *VM) readLarge(ins code.Instructions, ip int, wide bool) int {
+	if wide {
+		vm.currentFrame().ip += 4
+		return int(code.ReadUint32(ins[ip+1:]))
+	}
+	vm.currentFrame().ip += 2
+	return int(code.ReadUint16(ins[ip+1:]))
+}
+
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -341,7 +662,43 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// pushPair pushes the operands of a superinstruction for the operation
+// it stands for.
+func (vm *VM) pushPair(left, right object.Object) error {
+	if err := vm.push(left); err != nil {
+		return err
+	}
+	return vm.push(right)
+}
+
+// growStack makes room for size values on the stack, which forked VMs
+// start small, failing once size goes over StackSize. The new room counts
+// against the allocation limit.
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	n := max(size, 2*len(vm.stack))
+	n = min(n, StackSize)
+	if err := vm.allocate(int64(n-len(vm.stack)) * 16); err != nil {
+		return err
+	}
+
+	stack := make([]object.Object, n)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
 func (vm *VM) pop() object.Object {
+	if vm.sp == 0 {
+		panic(errStackUnderflow)
+	}
+
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
@@ -386,6 +743,10 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 
 	var result int64
 
+	if rightValue == 0 && (op == code.OpDiv || op == code.OpMod) {
+		return vm.opcodeError(op, vm.currentFrame().ip, "division by zero")
+	}
+
 	switch op {
 	case code.OpAdd:
 		result = leftValue + rightValue
@@ -478,7 +839,12 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	result := &object.String{Value: leftValue + rightValue}
+	if err := vm.allocate(sizeOf(result)); err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
@@ -593,25 +959,48 @@ func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object)
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	result, err := integerComparison(op, left.(*object.Integer).Value, right.(*object.Integer).Value)
+	if err != nil {
+		return err
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
+}
 
+func integerComparison(op code.Opcode, leftValue, rightValue int64) (bool, error) {
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+		return rightValue == leftValue, nil
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+		return rightValue != leftValue, nil
 	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		return leftValue > rightValue, nil
 	case code.OpLessThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+		return leftValue < rightValue, nil
 	case code.OpGreaterThanOrEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+		return leftValue >= rightValue, nil
 	case code.OpLessThanOrEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+		return leftValue <= rightValue, nil
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return false, fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+// compareLocalConstant compares a local variable with a constant for
+// OpCompareLocalJump.
+func (vm *VM) compareLocalConstant(op code.Opcode, left, right object.Object) (bool, error) {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		return integerComparison(op, l.Value, r.Value)
+	}
+
+	if err := vm.pushPair(left, right); err != nil {
+		return false, err
+	}
+	if err := vm.executeComparison(op); err != nil {
+		return false, err
 	}
+	return isTruthy(vm.pop()), nil
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -700,6 +1089,8 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.DICT_OBJ:
 		return vm.executeDictIndex(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
@@ -736,9 +1127,21 @@ func (vm *VM) executeDictIndex(dict, index object.Object) error {
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: more than %d nested calls", MaxFrames)
+	}
+	if vm.budget != nil && vm.framesIndex >= vm.maxDepth() {
+		return vm.limitError("depth", nil, "call depth limit exceeded: more than %d nested calls", vm.budget.limits.MaxDepth)
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -751,32 +1154,129 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
 
+	if err := vm.growStack(vm.sp - numArgs + cl.Fn.NumLocals + 1); err != nil {
+		return err
+	}
+
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+
+	// The other locals start empty, not with what an earlier call left in
+	// their slots.
+	for i := vm.sp; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+// executeTailCall runs a call whose result the current function returns.
+// A closure takes over the frame of the current function instead of
+// pushing one of its own, so recursion in tail position runs in constant
+// space. Other callees are called as usual and their result returned.
+func (vm *VM) executeTailCall(numArgs, ip int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || cl.Fn.IsGenerator {
+		if err := vm.executeCall(numArgs, ip); err != nil {
+			return err
+		}
+		return vm.returnValue()
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals + 1); err != nil {
+		return err
+	}
+
+	// The arguments become the first locals, and the other locals start
+	// empty as in a new frame.
+	copy(vm.stack[frame.basePointer:], vm.stack[vm.sp-numArgs:vm.sp])
+	for i := frame.basePointer + numArgs; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+
+	frame.cl = cl
+	frame.ip = -1
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
 }
 
-func (vm *VM) executeCall(numArgs int) error {
+// returnValue returns the value on top of the stack from the current
+// function.
+func (vm *VM) returnValue() error {
+	returnValue := vm.pop()
+
+	if vm.framesIndex == 1 {
+		vm.endProgram(returnValue)
+		return nil
+	}
+
+	frame := vm.popFrame()
+	vm.sp = frame.basePointer - 1
+
+	return vm.push(returnValue)
+}
+
+// endProgram stops the main program at a return statement, leaving value
+// as the last one popped, the way the evaluator ends a program.
+func (vm *VM) endProgram(value object.Object) {
+	if vm.sp < len(vm.stack) {
+		vm.stack[vm.sp] = value
+	}
+
+	frame := vm.currentFrame()
+	frame.ip = len(frame.Instructions()) - 1
+}
+
+// executeCall calls the callee under the numArgs arguments on the stack,
+// for the call instruction at ip.
+func (vm *VM) executeCall(numArgs, ip int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 
 	switch callee := callee.(type) {
 	case *object.Closure:
+		if callee.Fn.IsGenerator {
+			return vm.callGenerator(callee, numArgs)
+		}
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
-		return vm.callBuiltin(callee, numArgs)
+		return vm.callBuiltin(callee, numArgs, ip)
 	default:
 		return fmt.Errorf("calling non-function and non-built-in object: %s", callee.Type())
 	}
 }
 
-func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs, ip int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	result := builtin.Fn(args...)
+	result := builtin.Call(vm, args...)
 	vm.sp = vm.sp - numArgs - 1
 
+	switch result.(type) {
+	case *object.String, *object.Array, *object.Dict:
+		if err := vm.allocate(sizeOf(result)); err != nil {
+			return err
+		}
+	}
+
+	if errObj, ok := result.(*object.Error); ok && !errObj.Caught {
+		// A generator or task that went over a limit stops the whole
+		// program, as if the limit had been reached here.
+		var limitErr *LimitError
+		if errors.As(errObj.Err, &limitErr) {
+			return limitErr
+		}
+		return vm.builtinError(builtin, errObj, ip)
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -786,6 +1286,46 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// addLocalConstant adds constant to the local variable at index in the
+// stack, writing through its cell if a closure captured it. The slot is
+// looked up again afterwards, since adding may have grown the stack.
+func (vm *VM) addLocalConstant(index int, constant object.Object) error {
+	left := deref(vm.stack[index])
+
+	var result object.Object
+	l, lok := left.(*object.Integer)
+	r, rok := constant.(*object.Integer)
+	if lok && rok {
+		result = &object.Integer{Value: l.Value + r.Value}
+	} else {
+		if err := vm.pushPair(left, constant); err != nil {
+			return err
+		}
+		if err := vm.executeBinaryOperation(code.OpAdd); err != nil {
+			return err
+		}
+		result = vm.pop()
+	}
+
+	if cell, ok := vm.stack[index].(*object.Cell); ok {
+		cell.Value = result
+	} else {
+		vm.stack[index] = result
+	}
+	return nil
+}
+
+// deref returns the value held by a captured variable's cell.
+func deref(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
+
+// pushClosure creates a closure over the numFree values on the stack. The
+// compiler pushes the cells of captured local and free variables, so the
+// closure shares them with the frame and closures they came from.
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -800,5 +1340,9 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	vm.sp = vm.sp - numFree
 
 	closure := &object.Closure{Fn: function, Free: free}
+	if err := vm.allocate(sizeOf(closure)); err != nil {
+		return err
+	}
+
 	return vm.push(closure)
 }
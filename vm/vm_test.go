@@ -1,12 +1,21 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 	"zumbra/ast"
+	"zumbra/code"
 	"zumbra/compiler"
 	"zumbra/lexer"
 	"zumbra/object"
+	"zumbra/object/builtins"
 	"zumbra/parser"
 )
 
@@ -39,34 +48,45 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
 	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+		// Every case also runs optimized, to check the optimizer keeps
+		// its meaning.
+		for _, optimizations := range []compiler.Optimization{compiler.NoOptimizations, compiler.AllOptimizations} {
+			program := parse(tt.input)
+			comp := compiler.New()
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
 
-		/*
-			for i, constant := range comp.Bytecode().Constants {
-				fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
-				switch constant := constant.(type) {
-				case *object.CompiledFunction:
-					fmt.Printf(" Instructions:\n%s", constant.Instructions)
-				case *object.Integer:
-					fmt.Printf(" Value: %d\n", constant.Value)
-				}
-				fmt.Printf("\n")
-			}*/
+			/*
+				for i, constant := range comp.Bytecode().Constants {
+					fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
+					switch constant := constant.(type) {
+					case *object.CompiledFunction:
+						fmt.Printf(" Instructions:\n%s", constant.Instructions)
+					case *object.Integer:
+						fmt.Printf(" Value: %d\n", constant.Value)
+					}
+					fmt.Printf("\n")
+				}*/
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+			bytecode := comp.Bytecode()
+			compiler.Optimize(bytecode, optimizations)
 
-		stackElem := vm.LastPoppedStackElem()
+			if err := compiler.Verify(bytecode); err != nil {
+				t.Fatalf("verify error for %q: %s", tt.input, err)
+			}
+
+			vm := New(bytecode)
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
 
-		testExpectedObject(t, tt.expected, stackElem)
+			stackElem := vm.LastPoppedStackElem()
+
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -530,15 +550,15 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fct() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `wrong number of arguments: want=0, got=1 (OpCall at main, ip 7)`,
 		},
 		{
 			input:    `fct(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `wrong number of arguments: want=1, got=0 (OpCall at main, ip 4)`,
 		},
 		{
 			input:    `fct(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `wrong number of arguments: want=2, got=1 (OpCall at main, ip 7)`,
 		},
 	}
 	for _, tt := range tests {
@@ -564,43 +584,126 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`sizeOf("")`, 0},
 		{`sizeOf("four")`, 4},
 		{`sizeOf("hello world")`, 11},
-		{
-			`sizeOf(1)`,
-			&object.Error{
-				Message: "argument to `sizeOf` not supported, got INTEGER",
-			},
-		},
-		{`sizeOf("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
 		{`sizeOf([1, 2, 3])`, 3},
 		{`sizeOf([])`, 0},
 		{`show("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
-		},
 		{`allButFirst([1, 2, 3])`, []int{2, 3}},
 		{`allButFirst([])`, Null},
 		{`addToArrayStart([], 1)`, []int{1}},
-		{`addToArrayStart(1, 1)`,
-			&object.Error{
-				Message: "argument to `addToArrayStart` must be ARRAY, got INTEGER",
-			},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`sizeOf(1)`,
+			"sizeOf: argument to `sizeOf` not supported, got INTEGER (at main, ip 5)",
+		},
+		{
+			`sizeOf("one", "two")`,
+			"sizeOf: wrong number of arguments. got=2, want=1 (at main, ip 8)",
+		},
+		{
+			`first(1)`,
+			"first: argument to `first` must be ARRAY, got INTEGER (at main, ip 5)",
+		},
+		{
+			`last(1)`,
+			"last: argument to `last` must be ARRAY, got INTEGER (at main, ip 5)",
+		},
+		{
+			`var f << fct() { addToArrayStart(1, 1) }; f();`,
+			"addToArrayStart: argument to `addToArrayStart` must be ARRAY, got INTEGER (at f, ip 8)",
+		},
+		{
+			`var x << first(1); show(x);`,
+			"first: argument to `first` must be ARRAY, got INTEGER (at main, ip 5)",
+		},
+		{
+			`fct f() { return sum("x"); } f()`,
+			"sum: argument to `sum` must be ARRAY, got STRING (at f, ip 5)",
 		},
 	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+		}
+
+		if runtimeErr.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, runtimeErr)
+		}
+	}
+}
+
+// TestBuiltinErrorsPointAtTheCall checks that a builtin's error reports
+// the call instruction, whichever opcode the call was compiled to.
+func TestBuiltinErrorsPointAtTheCall(t *testing.T) {
+	inputs := []string{
+		`sum("x")`,
+		`var s << sum; s("x")`,
+		`sizeOf(` + strings.Repeat("0, ", 300) + `0)`,
+	}
+
+	for _, input := range inputs {
+		for _, optimizations := range []compiler.Optimization{compiler.NoOptimizations, compiler.AllOptimizations} {
+			comp := compiler.New()
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+			compiler.Optimize(bytecode, optimizations)
+
+			err := New(bytecode).Run()
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+			}
+
+			def, err := code.Lookup(bytecode.Instructions[runtimeErr.Ip])
+			if err != nil {
+				t.Fatalf("no instruction at ip %d for %.30q: %s", runtimeErr.Ip, input, err)
+			}
+			switch code.Opcode(bytecode.Instructions[runtimeErr.Ip]) {
+			case code.OpCall, code.OpCallWide, code.OpCallGlobal:
+			default:
+				t.Errorf("error of %.30q points at %s, not a call", input, def.Name)
+			}
+		}
+	}
+}
+
+func TestCatchingBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`var r << ""; try { toInt(1, 2); } catch (e) { r << e.message; }; r`,
+			"toInt: wrong number of arguments. got=2, want=1",
+		},
+		{
+			`var r << ""; try { toInt(1, 2); } catch (e) { r << first([e]).message; }; r`,
+			"toInt: wrong number of arguments. got=2, want=1",
+		},
+	}
+
 	runVmTests(t, tests)
 }
 
@@ -757,6 +860,366 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{`fct count(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 1); } count(100000, 0)`, 100000},
+		{`fct count(n) { if (n == 0) { 0 } else { count(n - 1) } } count(100000)`, 0},
+		{`
+		fct isEven(n) { if (n == 0) { return true; } isOdd(n - 1) }
+		fct isOdd(n) { if (n == 0) { return false; } isEven(n - 1) }
+		isEven(100001)
+		`, false},
+		{`fct f(x) { sizeOf(x) } f([1, 2, 3]) + 1`, 4},
+		{`var g << fct() { yield 1; }; fct f() { g() } next(f())`, 1},
+		{`fct f(n) { if (n == 0) { throw "fim" } try { return f(n - 1); } catch (e) { return n; } } f(3)`, 1},
+		{`fct f(n) { fct() { n } } fct g(n) { var m << n * 2; f(m) } g(7)()`, 14},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	inputs := []string{
+		`fct f() { f() + 1 } f()`,
+		`fct f(a, b, c, d) { f(a, b, c, d) + 1 } f(1, 2, 3, 4)`,
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok || !strings.HasPrefix(runtimeErr.Message, "stack overflow") || runtimeErr.Opcode == "" || runtimeErr.Function != "f" {
+			t.Errorf("expected a stack overflow in f for %q, got=%v", input, err)
+		}
+
+		// The recursive frames are shown once.
+		if trace := vm.StackTrace(); len(trace) > 4 || !strings.Contains(strings.Join(trace, "\n"), "... repeated") {
+			t.Errorf("wrong stack trace for %q: %q", input, trace)
+		}
+	}
+}
+
+func TestOpcodeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`1 / 0`, "division by zero (OpDiv at main, ip 6)"},
+		{`fct f(n) { n % 0 } f(5)`, "division by zero (OpMod at f, ip 5)"},
+		{`var x << x;`, "global 0 is read before it is set (OpGetGlobal at main, ip 0)"},
+		{`fct f() { var q << q; q } f()`, "local 0 is read before it is set (OpGetLocal at f, ip 0)"},
+		{`fct f() { var r << late(); var k << 3; fct late() { k } r } f()`, "free variable 0 is read before it is set (OpGetFree at late, ip 0)"},
+		{`[1]["a"]`, "array index must be INTEGER, got STRING (OpIndex at main, ip 9)"},
+		{`var d << {}; d[[1]]`, "unusable as hash key: ARRAY (OpIndex at main, ip 15)"},
+		{`1 + "a"`, "unsupported types for binary operation: INTEGER STRING (OpAdd at main, ip 6)"},
+		{`var x << 1; x()`, "calling non-function and non-built-in object: INTEGER (OpCall at main, ip 9)"},
+		{`var x << 1; x.y`, "object type INTEGER has no attributes (OpGetAttr at main, ip 12)"},
+		{`var f << fct() { var a << 99; a }; var g << fct() { var q << q; q }; f(); g()`, "local 0 is read before it is set (OpGetLocal at g, ip 0)"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError for %q. got=%T (%+v)", tt.input, err, err)
+		}
+		if runtimeErr.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, runtimeErr)
+		}
+	}
+
+	runVmTests(t, []vmTestCase{
+		{`var r << ""; try { 1 / 0 } catch (e) { r << e.message }; r`, "division by zero"},
+	})
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{`return 5; 7`, 5},
+		{`var x << 1; if (x > 0) { return x + 1; } 10`, 2},
+		{`fct f() { 3 } return f();`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+// TestPanicsAreRuntimeErrors runs bytecode the compiler never emits, which
+// would crash the VM if it were not checked by Verify.
+func TestPanicsAreRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		instructions []code.Instructions
+		expected     string
+	}{
+		{
+			[]code.Instructions{code.Make(code.OpTrue), code.Make(code.OpPop), code.Make(code.OpPop)},
+			"stack underflow (OpPop at main, ip 2)",
+		},
+		{
+			[]code.Instructions{code.Make(code.OpTrue), code.Make(code.OpIterNext, 0)},
+			"interface conversion: object.Object is *object.Boolean, not *object.Generator (OpIterNext at main, ip 1)",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := &compiler.Bytecode{}
+		for _, ins := range tt.instructions {
+			bytecode.Instructions = append(bytecode.Instructions, ins...)
+		}
+
+		err := New(bytecode).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   Limits
+		limit    string
+		expected string
+	}{
+		{`while (true) { }`, Limits{MaxSteps: 100}, "steps", "step limit exceeded: more than 100 instructions"},
+		{`var r << 0; try { while (true) { } } catch (e) { r << 1 }; r`, Limits{MaxSteps: 100}, "steps", "step limit exceeded: more than 100 instructions"},
+		{`fct f(n) { f(n) + 1 } f(1)`, Limits{MaxDepth: 10}, "depth", "call depth limit exceeded: more than 10 nested calls"},
+		{`var s << "x"; while (true) { s << s + s; }`, Limits{MaxAllocation: 1000}, "allocation", "allocation limit exceeded: more than 1000 bytes"},
+		{`var a << []; while (true) { a << addToArrayEnd(a, 1); }`, Limits{MaxAllocation: 1000}, "allocation", "allocation limit exceeded: more than 1000 bytes"},
+		{`fct g() { while (true) { yield 1; } } for (x in g()) { }`, Limits{MaxSteps: 1000}, "steps", "step limit exceeded: more than 1000 instructions"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err := vm.Run()
+
+		limitErr, ok := err.(*LimitError)
+		if !ok {
+			t.Fatalf("error is not *LimitError for %q. got=%T (%+v)", tt.input, err, err)
+		}
+		if limitErr.Limit != tt.limit || limitErr.Message != tt.expected {
+			t.Errorf("wrong limit error for %q: got=%s (%q)", tt.input, limitErr.Limit, limitErr.Message)
+		}
+	}
+
+	// Programs within their limits run as usual.
+	comp := compiler.New()
+	if err := comp.Compile(parse(`fct f(n) { if (n == 0) { 0 } else { f(n - 1) + 1 } } f(10)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxSteps: 1000, MaxDepth: 11, MaxAllocation: 1000})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 10, vm.LastPoppedStackElem())
+
+	// Generators start with a small stack, so many of them fit in a
+	// modest allocation limit.
+	comp = compiler.New()
+	if err := comp.Compile(parse(`var g << fct() { yield 1; }; var i << 0; while (i < 100) { next(g()); i << i + 1; } i`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxAllocation: 200000})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 100, vm.LastPoppedStackElem())
+}
+
+// TestLimitsInGeneratorsAndTasks checks that a limit reached while a
+// generator or task runs stops the program there, instead of becoming an
+// error that the caller can catch.
+func TestLimitsInGeneratorsAndTasks(t *testing.T) {
+	tests := []struct {
+		input    string
+		function string
+	}{
+		{`fct g() { while (true) { } yield 1; } var r << 0; try { next(g()); } catch (e) { r << 1; }; r`, "g"},
+		{`fct spin() { while (true) { } } var r << 0; try { waitAll(spawn(spin)); } catch (e) { r << 1; }; r`, "spin"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(Limits{MaxSteps: 1000})
+		err := vm.Run()
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("error is not *LimitError for %q. got=%T (%+v)", tt.input, err, err)
+		}
+		if limitErr.Limit != "steps" || limitErr.Function != tt.function {
+			t.Errorf("wrong limit error for %q: got=%s at %s", tt.input, limitErr.Limit, limitErr.Function)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`while (true) { }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := New(comp.Bytecode()).RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the program, got=%v", err)
+	}
+	if limitErr, ok := err.(*LimitError); !ok || limitErr.Limit != "context" {
+		t.Errorf("error is not a context *LimitError. got=%T (%+v)", err, err)
+	}
+}
+
+func TestSandboxPolicy(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`var r << ""; try { randomInteger(1, 2) } catch (e) { r << e.message }; [r, sizeOf("ok")]`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetPolicy(builtins.Policies["strict"])
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	result, ok := vm.LastPoppedStackElem().(*object.Array)
+	if !ok || len(result.Elements) != 2 {
+		t.Fatalf("wrong result: %v", vm.LastPoppedStackElem())
+	}
+	testExpectedObject(t, "randomInteger is not allowed by the sandbox, it uses random", result.Elements[0])
+	testExpectedObject(t, 2, result.Elements[1])
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		fct isEven(n) { if (n == 0) { return true; } isOdd(n - 1) }
+		fct isOdd(n) { if (n == 0) { return false; } isEven(n - 1) }
+		isEven(10)
+		`, true},
+		{`
+		fct outer(x) {
+			fct a(n) { if (n == 0) { return x; } b(n - 1) }
+			fct b(n) { if (n == 0) { return 0 - x; } a(n - 1) }
+			a(3) + a(4) * 10
+		}
+		outer(7)
+		`, 63},
+		{`
+		fct f() {
+			fct twice() { fct() { once() * 2 }() }
+			fct once() { 21 }
+			twice()
+		}
+		f()
+		`, 42},
+		{`
+		fct fact(n) { if (n < 2) { return 1; } n * fact(n - 1) }
+		var nested << fct() { fct fib(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) } fib(10) };
+		fact(5) + nested()
+		`, 175},
+		{`fct a() { b() } a(); fct b() { 1 }`, 1},
+		{`var x << early(); fct early() { 5 } x`, 5},
+		{`fct f() { var r << helper(2); fct helper(x) { x * 10 } r } f()`, 20},
+		{`var total << 1; fct add(n) { total << total + n; total } add(2) * 10 + total`, 33},
+		{`fct f() { var base << 4; fct scaled(n) { n * base } var first << scaled(1); base << 5; first * 10 + scaled(1) }; f()`, 45},
+		{`fct f() { var fs << []; var i << 0; while (i < 3) { var v << i; fct get() { v } fs << addToArrayEnd(fs, get); i << i + 1; } fs[0]() * 100 + fs[1]() * 10 + fs[2]() }; f()`, 12},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMutableCapturedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		fct makeCounter() {
+			var count << 0;
+			fct() { count << count + 1; count }
+		}
+		var c << makeCounter();
+		c(); c();
+		var d << makeCounter();
+		c() * 10 + d()
+		`, 31},
+		{`
+		fct pair() {
+			var value << 0;
+			var get << fct() { value };
+			var set << fct(v) { value << v };
+			value << 5;
+			var before << get();
+			set(10);
+			[before, get(), value]
+		}
+		pair()
+		`, []int{5, 10, 10}},
+		{`
+		fct deep() {
+			var total << 0;
+			var add << fct(n) { fct() { total << total + n } };
+			add(2)();
+			add(3)();
+			total
+		}
+		deep()
+		`, 5},
+		{`
+		fct loop() {
+			var fs << [];
+			for (x in [1, 2, 3]) { addToArrayEnd(fs, fct() { x }) }
+			[fs[0](), fs[1](), fs[2]()]
+		}
+		loop()
+		`, []int{1, 2, 3}},
+		{`
+		fct count() {
+			var seen << 0;
+			var g << fct() { yield 1; seen << seen + 1; yield 2; seen << seen + 1; };
+			for (v in g()) { }
+			seen
+		}
+		count()
+		`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTypeAnnotationsAreIgnored(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		var total: int << 0;
+		var scores: array<int> << [1, 2, 3];
+		fct add(a: int, b: int): int { a + b }
+		var f << fct(xs: array<int>): int { sum(xs) };
+		total << add(f(scores), 4);
+		total
+		`, 10},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestAssign(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -799,6 +1262,89 @@ func TestWhileLoops(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// names returns n distinct variable names, joined with sep around format.
+func names(n int, format, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		name := "v"
+		for j := i; j >= 0; j = j/26 - 1 {
+			name += "_" + string(rune('a'+j%26))
+		}
+		parts[i] = strings.ReplaceAll(format, "@", name)
+	}
+	return strings.Join(parts, sep)
+}
+
+func TestWideInstructions(t *testing.T) {
+	numbers := make([]string, 65537)
+	for i := range numbers {
+		numbers[i] = fmt.Sprint(i + 1)
+	}
+
+	tests := []vmTestCase{
+		{
+			// Locals past 255.
+			input: "var f << fct() { " + names(300, "var @ << 1;", " ") +
+				" v_n_k << v_n_k + 5; v_n_k + v_a }; f()",
+			expected: 7,
+		},
+		{
+			// A closure capturing more than 255 variables.
+			input: "var f << fct() { " + names(300, "var @ << 1;", " ") +
+				" fct() { " + names(300, "@", " + ") + " } }; f()()",
+			expected: 300,
+		},
+		{
+			input:    "var f << fct(" + names(300, "@", ", ") + ") { v_n_k }; f(" + strings.Join(numbers[:300], ", ") + ")",
+			expected: 300,
+		},
+		{
+			input:    strings.Join(numbers, "; "),
+			expected: 65537,
+		},
+		{
+			// Jumps past 65535 bytes.
+			input: "var x << 0; var y << 0; while (x < 3) { " + strings.Repeat("y; ", 17000) +
+				"x << x + 1; } if (x == 3) { " + strings.Repeat("y; ", 17000) + " x } else { 0 }",
+			expected: 3,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRunDecodedBytecode(t *testing.T) {
+	tests := []vmTestCase{
+		{`fct add(a, b) { a + b }; var k << 2.5; add(1, 2) * 2`, 6},
+		{`var counter << fct() { var n << 0; fct() { n << n + 1; n } }; var c << counter(); c(); c()`, 2},
+		{`enum Color { Red, Green }; Color.Green == Color.Green`, true},
+		{`var g << fct() { yield "a"; yield "b"; }; var s << ""; for (x in g()) { s << s + x; }; s`, "ab"},
+		{`try { throw "no" } catch (e) { e.message }`, "no"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var file bytes.Buffer
+		if err := comp.Bytecode().Encode(&file); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		bytecode, err := compiler.Decode(&file)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		vm := New(bytecode)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
 func TestAttributeAccess(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -811,3 +1357,411 @@ func TestAttributeAccess(t *testing.T) {
 	}
 	runVmTests(t, tests)
 }
+
+func TestEnums(t *testing.T) {
+	tests := []vmTestCase{
+		{`enum Status { Pending, Paid }; Status.Paid == Status.Paid`, true},
+		{`enum Status { Pending, Paid }; Status.Paid == Status.Pending`, false},
+		{`enum Status { Pending, Paid }; toString(Status.Paid)`, "Status.Paid"},
+		{`enum Status { Pending, Paid }; sizeOf(values(Status))`, 2},
+		{`enum Status { Pending, Paid }; var d << {Status.Paid: 1, Status.Pending: 2}; d[Status.Pending]`, 2},
+		{`
+		enum Status { Pending, Paid, Shipped };
+		var label << fct(s) {
+			if (s == Status.Pending) { "waiting" } else { if (s == Status.Paid) { "paid" } else { "gone" } }
+		};
+		label(Status.Paid)
+		`, "paid"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryCatchFinally(t *testing.T) {
+	tests := []vmTestCase{
+		{`var r << 0; try { throw "boom"; r << 1; } catch (e) { r << 2; }; r`, 2},
+		{`var r << ""; try { throw "boom"; } catch (e) { r << e.message; }; r`, "boom"},
+		{`var r << ""; try { 1 + "a"; } catch (e) { r << e.message; }; r`,
+			"unsupported types for binary operation: INTEGER STRING"},
+		{`var r << 0; try { r << 1; } catch (e) { r << 2; } finally { r << r + 10; }; r`, 11},
+		{`var r << 0; try { throw "x"; } catch (e) { r << 2; } finally { r << r + 10; }; r`, 12},
+		{`
+		var r << 0;
+		var fail << fct() { throw "deep"; };
+		var middle << fct() { fail(); r << 100; };
+		try { middle(); } catch (e) { r << r + 1; };
+		r
+		`, 1},
+		{`
+		var log << 0;
+		var f << fct() {
+			try { return 1; } finally { log << 5; }
+		};
+		f() + log
+		`, 6},
+		{`
+		var r << 0;
+		try {
+			try { throw "inner"; } finally { r << 1; }
+		} catch (e) {
+			r << r + 10;
+		};
+		r
+		`, 11},
+		{`
+		var r << "";
+		try {
+			try { throw "first"; } catch (e) { throw e.message + "!"; }
+		} catch (e) {
+			r << e.message;
+		};
+		r
+		`, "first!"},
+		{`
+		var f << fct(x) {
+			try { if (x > 1) { throw "big"; }; return x; } catch (e) { return 0; }
+		};
+		f(1) + f(5)
+		`, 1},
+		{`var e << 0; try { throw "x"; } catch (err) { e << sizeOf(err.stack); }; e`, 1},
+		{`if (true) { var y << 1; }`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	program := parse(`var f << fct() { throw "boom"; }; f();`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	if err.Error() != "uncaught error: boom" {
+		t.Fatalf("wrong VM error: want=%q, got=%q", "uncaught error: boom", err)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []vmTestCase{
+		{`var g << fct() { yield 1; yield 2; }; var it << g(); [next(it), next(it)]`, []int{1, 2}},
+		{`var g << fct() { yield 1; yield 2; }; var it << g(); next(it); next(it); next(it); next(it)`, Null},
+		{`var count << fct(n) { var i << 0; while (i < n) { yield i; i << i + 1; } }; var s << 0; for (x in count(5)) { s << s + x; }; s`, 10},
+		{`var s << 0; for (x in [1, 2, 3]) { s << s + x; }; s`, 6},
+		{`var f << fct() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()`, 20},
+		{`var base << 100; var g << fct(a) { yield a + base; yield fct() { a }(); }; var it << g(1); [next(it), next(it)]`, []int{101, 1}},
+		{`var nat << fct() { var i << 0; while (true) { i << i + 1; yield i; } }; var it << nat(); next(it); next(it); next(it)`, 3},
+		{`var g << fct() { yield 1; throw "boom"; }; var r << ""; try { for (x in g()) { r << r + toString(x); } } catch (e) { r << r + e.message; }; r`, "1boom"},
+		{`var g << fct() { try { yield 1; throw "x"; } catch (e) { yield 2; } }; var s << 0; for (x in g()) { s << s + x; }; s`, 3},
+		{`fct depth(n) { if (n == 0) { 0 } else { depth(n - 1) + 1 } } var g << fct() { yield depth(500); }; next(g())`, 500},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIteratingNonIterable(t *testing.T) {
+	program := parse(`for (x in 1) { x; }`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	expected := "cannot iterate over INTEGER (OpIter at main, ip 3)"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	tests := []vmTestCase{
+		{`var t << spawn(fct(a, b) { a + b }, 1, 2); waitAll(t)`, []int{3}},
+		{`var f << fct(n) { n * 2 }; waitAll([spawn(f, 1), spawn(f, 2), spawn(f, 3)])`, []int{2, 4, 6}},
+		{`var ch << channel(); spawn(fct() { send(ch, 42); }); receive(ch)`, 42},
+		{`var ch << channel(); var order << []; var t << spawn(fct() { addToArrayEnd(order, 1); send(ch, 0); addToArrayEnd(order, 3); }); addToArrayEnd(order, 0); receive(ch); addToArrayEnd(order, 2); waitAll(t); order`, []int{0, 1, 2, 3}},
+		{`var ch << channel(3); send(ch, 1); send(ch, 2); close(ch); var s << 0; for (x in ch) { s << s + x; }; s`, 3},
+		{`var ch << channel(); var s << 0; spawn(fct() { var i << 1; while (i <= 4) { send(ch, i); i << i + 1; } close(ch); }); for (x in ch) { s << s + x; }; s`, 10},
+		{`var items << []; var add << fct(n) { var i << 0; while (i < n) { addToArrayEnd(items, i); i << i + 1; } }; waitAll([spawn(add, 3000), spawn(add, 3000)]); sizeOf(items)`, 6000},
+		{`var ch << channel(); close(ch); receive(ch)`, Null},
+		{`var r << ""; try { waitAll(spawn(fct() { throw "boom"; })); } catch (e) { r << e.message; }; r`, "waitAll: uncaught error: boom"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTaskErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`receive(channel())`,
+			"receive: all tasks are asleep - deadlock (at main, ip 6)",
+		},
+		{
+			`var ch << channel(); spawn(fct() { receive(ch); }); send(ch, 1); send(ch, 2)`,
+			"send: all tasks are asleep - deadlock (at main, ip 35)",
+		},
+		{
+			`send(channel("INTEGER", 1), "one")`,
+			"send: cannot send STRING on channel of INTEGER (at main, ip 15)",
+		},
+		{
+			`var ch << channel(); close(ch); send(ch, 1)`,
+			"send: send on closed channel (at main, ip 23)",
+		},
+		{
+			`spawn(1)`,
+			"spawn: cannot spawn INTEGER (at main, ip 5)",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.Scheduler().Deterministic = true
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestDeterministicScheduling(t *testing.T) {
+	input := `
+var log << [];
+var worker << fct(id) {
+	var i << 0;
+	while (i < 500) { addToArrayEnd(log, id); i << i + 1; }
+};
+waitAll([spawn(worker, 1), spawn(worker, 2), spawn(worker, 3)]);
+log`
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		program := parse(input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.Scheduler().Deterministic = true
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		outputs = append(outputs, vm.LastPoppedStackElem().Inspect())
+	}
+
+	if outputs[0] != outputs[1] {
+		t.Fatalf("scheduling is not deterministic.\nfirst=%s\nsecond=%s", outputs[0], outputs[1])
+	}
+
+	if !strings.Contains(outputs[0], "3, 1") {
+		t.Errorf("tasks were not preempted: %s", outputs[0])
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"utils.zum": `
+var factor << 2;
+var calls << [];
+export var twoTimes << fct(x) { addToArrayEnd(calls, x); x * factor };
+export var callCount << fct() { sizeOf(calls) };
+`,
+		"public.zum": `
+var _secret << 1;
+var visible << _secret + 1;
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+
+	utils := filepath.Join(dir, "utils.zum")
+	public := filepath.Join(dir, "public.zum")
+
+	tests := []vmTestCase{
+		{fmt.Sprintf(`import "%s" as utils; utils.twoTimes(8)`, utils), 16},
+		{fmt.Sprintf(`var factor << 10; import "%s" as utils; utils.twoTimes(8) + factor`, utils), 26},
+		{fmt.Sprintf(`import "%s" as a; import "%s" as b; a.twoTimes(1); b.twoTimes(2); a.callCount()`, utils, utils), 2},
+		{fmt.Sprintf(`import "%s" as p; p.visible`, public), 2},
+		// The first import the program reaches loads the module.
+		{fmt.Sprintf(`var f << fct() { import "%s" as a; a.twoTimes(1) }; import "%s" as b; b.twoTimes(2)`, utils, utils), 4},
+		{fmt.Sprintf(`var f << fct() { import "%s" as a; a.callCount() }; import "%s" as b; b.twoTimes(2); f()`, utils, utils), 1},
+		{fmt.Sprintf(`if (false) { import "%s" as a; } import "%s" as b; b.twoTimes(3)`, utils, utils), 6},
+	}
+
+	runVmTests(t, tests)
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{fmt.Sprintf(`import "%s" as utils; utils.factor`, utils), "module " + utils + " has no member factor (OpGetAttr at main, ip 16)"},
+		{fmt.Sprintf(`import "%s" as p; p._secret`, public), "module " + public + " has no member _secret (OpGetAttr at main, ip 16)"},
+	}
+
+	for _, tt := range errors {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	dir := t.TempDir()
+	math := "fct soma(a, b) {\n  return a + b;\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "math.zum"), []byte(math), 0644); err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	input := "import \"math.zum\";\nvar total << fct() {\n  soma(\"x\", 1) + 1\n};\ntotal();\n"
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithStateAndDir(symbolTable, []object.Object{}, dir)
+	comp.SetFile("app.zum")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	for _, optimizations := range []compiler.Optimization{compiler.NoOptimizations, compiler.AllOptimizations} {
+		bytecode := comp.Bytecode()
+		compiler.Optimize(bytecode, optimizations)
+
+		vm := New(bytecode)
+		err := vm.Run()
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok || runtimeErr.Message != "unsupported types for binary operation: STRING INTEGER" || runtimeErr.Opcode != "OpAdd" {
+			t.Fatalf("wrong VM error: %v", err)
+		}
+
+		expected := []string{
+			"at soma (math.zum:2:12)",
+			"at total (app.zum:3:3)",
+			"at main (app.zum:5:1)",
+		}
+		if trace := vm.StackTrace(); strings.Join(trace, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("wrong stack trace.\nwant=%q\ngot=%q", expected, trace)
+		}
+	}
+}
+
+func TestStdLibrary(t *testing.T) {
+	tests := []vmTestCase{
+		{`import "std:math" as math; math.power(2, 10)`, 1024},
+		{`import "std:math" as math; math.clamp(15, 0, 10) + math.abs(-3)`, 13},
+		{`import "std:arrays" as arrays; arrays.reduce(arrays.map([1, 2, 3], fct(x) { x * x }), fct(a, b) { a + b }, 0)`, 14},
+		{`import "std:arrays" as arrays; arrays.filter([1, 2, 3, 4], fct(x) { x > 2 })`, []int{3, 4}},
+		{`import "std:strings" as strings; strings.join([1, 2, 3], ", ")`, "1, 2, 3"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestImportCycles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.zum":     `import "lib/b.zum"`,
+		"lib/b.zum": `import "../a.zum"`,
+		"m1.zum":    `import "m2.zum" as m2; export var one << 1;`,
+		"m2.zum":    `import "m1.zum" as m1; export var two << 2;`,
+		"self.zum":  `import "self.zum" as me;`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "a.zum"`, "import cycle: a.zum -> " + filepath.Join("lib", "b.zum") + " -> a.zum"},
+		{`import "m1.zum" as m1`, "import cycle: m1.zum -> m2.zum -> m1.zum"},
+		{`import "self.zum" as me`, "import cycle: self.zum -> self.zum"},
+		{`import "missing.zum"`, "could not find imported file: missing.zum"},
+	}
+
+	for _, tt := range tests {
+		symbolTable := compiler.NewSymbolTable()
+		for i, v := range builtins.Builtins {
+			symbolTable.DefineBuiltin(i, v.Name)
+		}
+
+		comp := compiler.NewWithStateAndDir(symbolTable, []object.Object{}, dir)
+		err := comp.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong compiler error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestImportCyclesThroughTheEntryFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.zum": `import "back.zum"`,
+		"back.zum": `import "main.zum" as main`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	comp := compiler.NewWithStateAndDir(symbolTable, []object.Object{}, dir)
+	comp.SetFile(filepath.Join(dir, "main.zum"))
+	err := comp.Compile(parse(files["main.zum"]))
+
+	expected := "import cycle: main.zum -> back.zum -> main.zum"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong compiler error: want=%q, got=%v", expected, err)
+	}
+}